@@ -3,12 +3,16 @@ package ast
 import (
 	"bytes"
 	"interpreter/token"
+	"math/big"
+	"sort"
 	"strings"
 )
 
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Pos() token.Position // position of the first character of the node
+	End() token.Position // position immediately after the node
 }
 
 type Expression interface {
@@ -23,6 +27,8 @@ type Statement interface {
 
 type Program struct {
 	Statements []Statement
+	Resolved   bool // set once the resolver has bound every identifier without errors
+	Slots      int  // the slots the global environment needs, set by the resolver
 }
 
 func (p *Program) TokenLiteral() string {
@@ -34,6 +40,20 @@ func (p *Program) TokenLiteral() string {
 
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
+func (p *Program) End() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[len(p.Statements)-1].End()
+	}
+	return token.Position{}
+}
+
 func (p *Program) String() string {
 	out := &bytes.Buffer{}
 
@@ -51,6 +71,13 @@ type LetStatement struct {
 
 func (ls *LetStatement) statementNode()       {}
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LetStatement) Pos() token.Position  { return ls.Token.Pos }
+func (ls *LetStatement) End() token.Position {
+	if ls.Value != nil {
+		return ls.Value.End()
+	}
+	return ls.Name.End()
+}
 func (ls *LetStatement) String() string {
 	out := &bytes.Buffer{}
 	out.WriteString(ls.TokenLiteral() + " ")
@@ -72,6 +99,13 @@ type ReturnStatement struct {
 
 func (rs *ReturnStatement) statementNode()       {}
 func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *ReturnStatement) Pos() token.Position  { return rs.Token.Pos }
+func (rs *ReturnStatement) End() token.Position {
+	if rs.ReturnValue != nil {
+		return rs.ReturnValue.End()
+	}
+	return rs.Token.End
+}
 func (rs *ReturnStatement) String() string {
 	out := &bytes.Buffer{}
 	out.WriteString(rs.TokenLiteral() + " ")
@@ -89,6 +123,13 @@ type ExpressionStatement struct {
 
 func (es *ExpressionStatement) statementNode()       {}
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) Pos() token.Position  { return es.Token.Pos }
+func (es *ExpressionStatement) End() token.Position {
+	if es.Expression != nil {
+		return es.Expression.End()
+	}
+	return es.Token.End
+}
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
 		return es.Expression.String()
@@ -99,20 +140,43 @@ func (es *ExpressionStatement) String() string {
 type Identifier struct {
 	Token token.Token //the token IDENT token
 	Value string
+
+	// set by the resolver: a Local variable is in slot Slot of the function
+	// Depth functions out from where it is used. Any other name, a global, a
+	// builtin or one never resolved, is looked up by name.
+	Local bool
+	Depth int
+	Slot  int
 }
 
 func (i *Identifier) expressionNode()      {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
 func (i *Identifier) String() string       { return i.Value }
+func (i *Identifier) Pos() token.Position  { return i.Token.Pos }
+func (i *Identifier) End() token.Position  { return i.Token.End }
 
 type IntegerLiteral struct {
 	Token token.Token
 	Value int64
+	Big   *big.Int // the value of a literal beyond the int64 range, in which case Value is 0
 }
 
 func (il *IntegerLiteral) expressionNode()      {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
+func (il *IntegerLiteral) Pos() token.Position  { return il.Token.Pos }
+func (il *IntegerLiteral) End() token.Position  { return il.Token.End }
+
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (fl *FloatLiteral) expressionNode()      {}
+func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FloatLiteral) String() string       { return fl.Token.Literal }
+func (fl *FloatLiteral) Pos() token.Position  { return fl.Token.Pos }
+func (fl *FloatLiteral) End() token.Position  { return fl.Token.End }
 
 type PrefixExpression struct {
 	Token    token.Token
@@ -122,6 +186,13 @@ type PrefixExpression struct {
 
 func (pe *PrefixExpression) expressionNode()      {}
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) Pos() token.Position  { return pe.Token.Pos }
+func (pe *PrefixExpression) End() token.Position {
+	if pe.Right != nil {
+		return pe.Right.End()
+	}
+	return pe.Token.End
+}
 func (pe *PrefixExpression) String() string {
 	out := &bytes.Buffer{}
 
@@ -142,6 +213,13 @@ type InfixExpression struct {
 
 func (ie *InfixExpression) expressionNode()      {}
 func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *InfixExpression) Pos() token.Position  { return ie.Left.Pos() }
+func (ie *InfixExpression) End() token.Position {
+	if ie.Right != nil {
+		return ie.Right.End()
+	}
+	return ie.Token.End
+}
 func (ie *InfixExpression) String() string {
 
 	out := &bytes.Buffer{}
@@ -155,6 +233,70 @@ func (ie *InfixExpression) String() string {
 	return out.String()
 }
 
+//LogicalExpression is left && right or left || right. Unlike an infix
+//expression the right operand is only evaluated when the left one does not
+//already decide the result.
+type LogicalExpression struct {
+	Token    token.Token // the && or || token
+	Left     Expression
+	Operator string
+	Right    Expression
+}
+
+func (le *LogicalExpression) expressionNode()      {}
+func (le *LogicalExpression) TokenLiteral() string { return le.Token.Literal }
+func (le *LogicalExpression) Pos() token.Position  { return le.Left.Pos() }
+func (le *LogicalExpression) End() token.Position {
+	if le.Right != nil {
+		return le.Right.End()
+	}
+	return le.Token.End
+}
+func (le *LogicalExpression) String() string {
+
+	out := &bytes.Buffer{}
+
+	out.WriteString("(")
+	out.WriteString(le.Left.String())
+	out.WriteString(" " + le.Operator + " ")
+	out.WriteString(le.Right.String())
+	out.WriteString(")")
+
+	return out.String()
+}
+
+//AssignExpression is target = value or a compound assignment such as
+//target += value, which stores target + value. Target is an *Identifier or
+//an *IndexExpression.
+type AssignExpression struct {
+	Token    token.Token // the = or compound assignment token
+	Target   Expression
+	Operator string
+	Value    Expression
+}
+
+func (ae *AssignExpression) expressionNode()      {}
+func (ae *AssignExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AssignExpression) Pos() token.Position  { return ae.Target.Pos() }
+func (ae *AssignExpression) End() token.Position {
+	if ae.Value != nil {
+		return ae.Value.End()
+	}
+	return ae.Token.End
+}
+func (ae *AssignExpression) String() string {
+
+	out := &bytes.Buffer{}
+
+	out.WriteString("(")
+	out.WriteString(ae.Target.String())
+	out.WriteString(" " + ae.Operator + " ")
+	out.WriteString(ae.Value.String())
+	out.WriteString(")")
+
+	return out.String()
+}
+
 type Boolean struct {
 	Token token.Token
 	Value bool
@@ -163,6 +305,8 @@ type Boolean struct {
 func (b *Boolean) expressionNode()      {}
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
 func (b *Boolean) String() string       { return b.Token.Literal }
+func (b *Boolean) Pos() token.Position  { return b.Token.Pos }
+func (b *Boolean) End() token.Position  { return b.Token.End }
 
 type IfExpression struct {
 	Token       token.Token //IF
@@ -173,6 +317,13 @@ type IfExpression struct {
 
 func (ie *IfExpression) expressionNode()      {}
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpression) Pos() token.Position  { return ie.Token.Pos }
+func (ie *IfExpression) End() token.Position {
+	if ie.Alternative != nil {
+		return ie.Alternative.End()
+	}
+	return ie.Consequence.End()
+}
 func (ie *IfExpression) String() string {
 
 	var out bytes.Buffer
@@ -192,10 +343,13 @@ func (ie *IfExpression) String() string {
 type BlockStatement struct {
 	Token      token.Token //the { token
 	Statements []Statement
+	Rbrace     token.Token //the } token
 }
 
 func (bs *BlockStatement) expressionNode()      {}
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BlockStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BlockStatement) End() token.Position  { return bs.Rbrace.End }
 func (bs *BlockStatement) String() string {
 
 	var out bytes.Buffer
@@ -209,10 +363,13 @@ type FunctionLiteral struct {
 	Token      token.Token // the fn token
 	Parameters []*Identifier
 	Body       *BlockStatement
+	Slots      int // set by the resolver, the number of local variables of a call, parameters first
 }
 
 func (fl *FunctionLiteral) expressionNode()      {}
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FunctionLiteral) Pos() token.Position  { return fl.Token.Pos }
+func (fl *FunctionLiteral) End() token.Position  { return fl.Body.End() }
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 	var params []string
@@ -233,10 +390,14 @@ type CallExpression struct {
 	Token     token.Token // the "(" token
 	Function  Expression  //either FunctionLiteral or Identifier
 	Arguments []Expression
+	Rparen    token.Token // the ")" token
+	Tail      bool        // set by MarkTailCalls when the call is the last thing its function does
 }
 
 func (ce *CallExpression) expressionNode()      {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) Pos() token.Position  { return ce.Function.Pos() }
+func (ce *CallExpression) End() token.Position  { return ce.Rparen.End }
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 	args := []string{}
@@ -261,14 +422,49 @@ type StringLiteral struct {
 func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
 func (sl *StringLiteral) String() string       { return sl.Token.Literal }
+func (sl *StringLiteral) Pos() token.Position  { return sl.Token.Pos }
+func (sl *StringLiteral) End() token.Position  { return sl.Token.End }
+
+//InterpolatedString is a string such as "count: ${n + 1}". Parts holds the
+//text between the interpolations as StringLiterals, leaving out empty ones,
+//and the embedded expressions, in source order.
+type InterpolatedString struct {
+	Token token.Token // the STRING_START token
+	Parts []Expression
+	Tail  token.Token // the STRING_END token
+}
+
+func (is *InterpolatedString) expressionNode()      {}
+func (is *InterpolatedString) TokenLiteral() string { return is.Token.Literal }
+func (is *InterpolatedString) Pos() token.Position  { return is.Token.Pos }
+func (is *InterpolatedString) End() token.Position  { return is.Tail.End }
+func (is *InterpolatedString) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("\"")
+	for _, part := range is.Parts {
+		if str, ok := part.(*StringLiteral); ok {
+			out.WriteString(str.Value)
+			continue
+		}
+		out.WriteString("${")
+		out.WriteString(part.String())
+		out.WriteString("}")
+	}
+	out.WriteString("\"")
+	return out.String()
+}
 
 type ArrayLiteral struct {
 	Token    token.Token // the '[' token
 	Elements []Expression
+	Rbracket token.Token // the ']' token
 }
 
 func (al *ArrayLiteral) expressionNode()      {}
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) Pos() token.Position  { return al.Token.Pos }
+func (al *ArrayLiteral) End() token.Position  { return al.Rbracket.End }
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 
@@ -283,13 +479,16 @@ func (al *ArrayLiteral) String() string {
 }
 
 type IndexExpression struct {
-	Token token.Token //The [ token
-	Left  Expression
-	Index Expression
+	Token    token.Token //The [ token
+	Left     Expression
+	Index    Expression
+	Rbracket token.Token //The ] token
 }
 
 func (ie *IndexExpression) expressionNode()      {}
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) Pos() token.Position  { return ie.Left.Pos() }
+func (ie *IndexExpression) End() token.Position  { return ie.Rbracket.End }
 func (ie *IndexExpression) String() string {
 
 	var out bytes.Buffer
@@ -304,12 +503,15 @@ func (ie *IndexExpression) String() string {
 }
 
 type HashLiteral struct {
-	Token token.Token //the '{' token
-	Pairs map[Expression]Expression
+	Token  token.Token //the '{' token
+	Pairs  map[Expression]Expression
+	Rbrace token.Token //the '}' token
 }
 
 func (hl *HashLiteral) expressionNode() {}
 func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
+func (hl *HashLiteral) Pos() token.Position  { return hl.Token.Pos }
+func (hl *HashLiteral) End() token.Position  { return hl.Rbrace.End }
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 	pairs := []string{}
@@ -324,3 +526,254 @@ func (hl *HashLiteral) String() string {
 	return out.String()
 }
 
+
+type WhileStatement struct {
+	Token     token.Token // the while token
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (ws *WhileStatement) statementNode()       {}
+func (ws *WhileStatement) TokenLiteral() string { return ws.Token.Literal }
+func (ws *WhileStatement) Pos() token.Position  { return ws.Token.Pos }
+func (ws *WhileStatement) End() token.Position  { return ws.Body.End() }
+func (ws *WhileStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("while (")
+	out.WriteString(ws.Condition.String())
+	out.WriteString(") ")
+	out.WriteString(ws.Body.String())
+
+	return out.String()
+}
+
+//ForInStatement is for (value in iterable) or for (key, value in iterable).
+//With a single name the name is bound to the array element, the character of
+//a string or the key of a hash. With two names Key receives the index or hash
+//key and Value the element, character or hash value.
+type ForInStatement struct {
+	Token    token.Token // the for token
+	Key      *Identifier // nil unless two names are given
+	Value    *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (fs *ForInStatement) statementNode()       {}
+func (fs *ForInStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForInStatement) Pos() token.Position  { return fs.Token.Pos }
+func (fs *ForInStatement) End() token.Position  { return fs.Body.End() }
+func (fs *ForInStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("for (")
+	if fs.Key != nil {
+		out.WriteString(fs.Key.String() + ", ")
+	}
+	out.WriteString(fs.Value.String())
+	out.WriteString(" in ")
+	out.WriteString(fs.Iterable.String())
+	out.WriteString(") ")
+	out.WriteString(fs.Body.String())
+
+	return out.String()
+}
+
+type BreakStatement struct {
+	Token token.Token // the break token
+}
+
+func (bs *BreakStatement) statementNode()       {}
+func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BreakStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BreakStatement) End() token.Position  { return bs.Token.End }
+func (bs *BreakStatement) String() string       { return bs.Token.Literal + ";" }
+
+type ContinueStatement struct {
+	Token token.Token // the continue token
+}
+
+func (cs *ContinueStatement) statementNode()       {}
+func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ContinueStatement) Pos() token.Position  { return cs.Token.Pos }
+func (cs *ContinueStatement) End() token.Position  { return cs.Token.End }
+func (cs *ContinueStatement) String() string       { return cs.Token.Literal + ";" }
+
+//ThrowStatement raises an error, Value is the message or an error caught
+//earlier to raise again
+type ThrowStatement struct {
+	Token token.Token // the throw token
+	Value Expression
+}
+
+func (ts *ThrowStatement) statementNode()       {}
+func (ts *ThrowStatement) TokenLiteral() string { return ts.Token.Literal }
+func (ts *ThrowStatement) Pos() token.Position  { return ts.Token.Pos }
+func (ts *ThrowStatement) End() token.Position  { return ts.Value.End() }
+func (ts *ThrowStatement) String() string {
+	return ts.TokenLiteral() + " " + ts.Value.String() + ";"
+}
+
+//TryExpression evaluates to the value of Block or, if Block raises an error,
+//of Catch with the error bound to Param. Finally runs last in either case.
+//At least one of Catch and Finally is set.
+type TryExpression struct {
+	Token   token.Token // the try token
+	Block   *BlockStatement
+	Param   *Identifier // nil without a catch
+	Catch   *BlockStatement
+	Finally *BlockStatement
+}
+
+func (te *TryExpression) expressionNode()      {}
+func (te *TryExpression) TokenLiteral() string { return te.Token.Literal }
+func (te *TryExpression) Pos() token.Position  { return te.Token.Pos }
+func (te *TryExpression) End() token.Position {
+	if te.Finally != nil {
+		return te.Finally.End()
+	}
+	return te.Catch.End()
+}
+func (te *TryExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("try ")
+	out.WriteString(te.Block.String())
+	if te.Catch != nil {
+		out.WriteString(" catch (" + te.Param.String() + ") ")
+		out.WriteString(te.Catch.String())
+	}
+	if te.Finally != nil {
+		out.WriteString(" finally ")
+		out.WriteString(te.Finally.String())
+	}
+
+	return out.String()
+}
+
+//MarkTailCalls sets Tail on the calls of a function body whose value the
+//function returns, that is the last expression of the body, a returned value
+//and, through if expressions, the last expression of their branches. Calls in
+//try expressions are never tail calls as their errors must still be caught,
+//and nested function literals are marked by the parser on their own.
+func MarkTailCalls(body *BlockStatement) {
+	markTailBlock(body, true)
+}
+
+func markTailBlock(block *BlockStatement, valued bool) {
+	if block == nil {
+		return
+	}
+	for i, stmt := range block.Statements {
+		switch stmt := stmt.(type) {
+		case *ReturnStatement:
+			markTailExpression(stmt.ReturnValue)
+		case *ExpressionStatement:
+			if valued && i == len(block.Statements)-1 {
+				markTailExpression(stmt.Expression)
+			} else if ifExp, ok := stmt.Expression.(*IfExpression); ok {
+				markTailBlock(ifExp.Consequence, false)
+				markTailBlock(ifExp.Alternative, false)
+			}
+		case *WhileStatement:
+			markTailBlock(stmt.Body, false)
+		case *ForInStatement:
+			markTailBlock(stmt.Body, false)
+		}
+	}
+}
+
+func markTailExpression(exp Expression) {
+	switch exp := exp.(type) {
+	case *CallExpression:
+		exp.Tail = true
+	case *IfExpression:
+		markTailBlock(exp.Consequence, true)
+		markTailBlock(exp.Alternative, true)
+	}
+}
+
+//Walk calls visit for node and every node inside it, going into function
+//literals only when functions is set
+func Walk(node Node, functions bool, visit func(Node)) {
+	var children []Node
+
+	switch n := node.(type) {
+	case nil:
+		return
+	case *ExpressionStatement:
+		children = []Node{n.Expression}
+	case *LetStatement:
+		children = []Node{n.Value}
+	case *ReturnStatement:
+		children = []Node{n.ReturnValue}
+	case *ThrowStatement:
+		children = []Node{n.Value}
+	case *BlockStatement:
+		for _, s := range n.Statements {
+			children = append(children, s)
+		}
+	case *WhileStatement:
+		children = []Node{n.Condition, n.Body}
+	case *ForInStatement:
+		children = []Node{n.Iterable, n.Body}
+	case *PrefixExpression:
+		children = []Node{n.Right}
+	case *InfixExpression:
+		children = []Node{n.Left, n.Right}
+	case *LogicalExpression:
+		children = []Node{n.Left, n.Right}
+	case *AssignExpression:
+		children = []Node{n.Target, n.Value}
+	case *IfExpression:
+		children = []Node{n.Condition, n.Consequence}
+		if n.Alternative != nil {
+			children = append(children, n.Alternative)
+		}
+	case *TryExpression:
+		children = []Node{n.Block}
+		if n.Catch != nil {
+			children = append(children, n.Catch)
+		}
+		if n.Finally != nil {
+			children = append(children, n.Finally)
+		}
+	case *FunctionLiteral:
+		if functions {
+			children = []Node{n.Body}
+		}
+	case *CallExpression:
+		children = []Node{n.Function}
+		for _, a := range n.Arguments {
+			children = append(children, a)
+		}
+	case *InterpolatedString:
+		for _, p := range n.Parts {
+			children = append(children, p)
+		}
+	case *ArrayLiteral:
+		for _, el := range n.Elements {
+			children = append(children, el)
+		}
+	case *IndexExpression:
+		children = []Node{n.Left, n.Index}
+	case *HashLiteral:
+		keys := []Expression{}
+		for k := range n.Pairs {
+			keys = append(keys, k)
+		}
+		// the pairs are stored in a map, sort them so nodes are visited in source order
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].Pos().Offset < keys[j].Pos().Offset
+		})
+		for _, k := range keys {
+			children = append(children, k, n.Pairs[k])
+		}
+	}
+
+	visit(node)
+	for _, child := range children {
+		Walk(child, functions, visit)
+	}
+}
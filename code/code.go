@@ -0,0 +1,275 @@
+package code
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"interpreter/token"
+	"sort"
+)
+
+type Instructions []byte
+
+func (ins Instructions) String() string {
+	var out bytes.Buffer
+
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
+			continue
+		}
+
+		operands, read := ReadOperands(def, ins[i+1:])
+		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+
+		i += 1 + read
+	}
+	return out.String()
+}
+
+func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
+	operandCount := len(def.OperandWidths)
+
+	if len(operands) != operandCount {
+		return fmt.Sprintf("ERROR: operand len %d does not match defined %d\n", len(operands), operandCount)
+	}
+
+	switch operandCount {
+	case 0:
+		return def.Name
+	case 1:
+		return fmt.Sprintf("%s %d", def.Name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
+	}
+	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
+}
+
+type Opcode byte
+
+const (
+	OpConstant Opcode = iota
+	OpPop
+
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpMod
+
+	OpTrue
+	OpFalse
+	OpNull
+
+	OpEqual
+	OpNotEqual
+	OpGreaterThan
+	OpLessThan
+	OpLessEqual
+	OpGreaterEqual
+
+	OpMinus
+	OpBang
+
+	OpJumpNotTruthy
+	OpJump
+
+	OpGetGlobal
+	OpSetGlobal
+	OpGetLocal
+	OpSetLocal
+	OpGetBuiltin
+	OpGetFree
+	OpSetFree
+	OpCaptureLocal // pushes the cell holding a local, boxing the local first if needed
+	OpCaptureFree  // pushes the cell holding a free variable
+	OpCurrentClosure
+
+	OpArray
+	OpHash
+	OpInterpolate // concatenates the Inspect output of the top n stack elements into a string
+	OpIndex
+	OpSetIndex
+	OpDup // pushes copies of the top n stack elements
+
+	OpCall
+	OpTailCall // OpCall in place of the current call, for a call the function returns the value of
+	OpReturnValue
+	OpReturn
+	OpClosure
+
+	OpLoopEnter
+	OpLoopExit
+	OpLoopJump
+	OpIterInit
+	OpIterNext
+
+	OpTry
+	OpEndTry
+	OpThrow
+)
+
+type Definition struct {
+	Name          string
+	OperandWidths []int
+}
+
+var definitions = map[Opcode]*Definition{
+	OpConstant: {"OpConstant", []int{2}},
+	OpPop:      {"OpPop", []int{}},
+
+	OpAdd: {"OpAdd", []int{}},
+	OpSub: {"OpSub", []int{}},
+	OpMul: {"OpMul", []int{}},
+	OpDiv: {"OpDiv", []int{}},
+	OpMod: {"OpMod", []int{}},
+
+	OpTrue:  {"OpTrue", []int{}},
+	OpFalse: {"OpFalse", []int{}},
+	OpNull:  {"OpNull", []int{}},
+
+	OpEqual:        {"OpEqual", []int{}},
+	OpNotEqual:     {"OpNotEqual", []int{}},
+	OpGreaterThan:  {"OpGreaterThan", []int{}},
+	OpLessThan:     {"OpLessThan", []int{}},
+	OpLessEqual:    {"OpLessEqual", []int{}},
+	OpGreaterEqual: {"OpGreaterEqual", []int{}},
+
+	OpMinus: {"OpMinus", []int{}},
+	OpBang:  {"OpBang", []int{}},
+
+	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
+	OpJump:          {"OpJump", []int{2}},
+
+	OpGetGlobal:      {"OpGetGlobal", []int{2}},
+	OpSetGlobal:      {"OpSetGlobal", []int{2}},
+	OpGetLocal:       {"OpGetLocal", []int{1}},
+	OpSetLocal:       {"OpSetLocal", []int{1}},
+	OpGetBuiltin:     {"OpGetBuiltin", []int{1}},
+	OpGetFree:        {"OpGetFree", []int{1}},
+	OpSetFree:        {"OpSetFree", []int{1}},
+	OpCaptureLocal:   {"OpCaptureLocal", []int{1}},
+	OpCaptureFree:    {"OpCaptureFree", []int{1}},
+	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+
+	OpArray:       {"OpArray", []int{2}},
+	OpHash:        {"OpHash", []int{2}},
+	OpInterpolate: {"OpInterpolate", []int{2}},
+	OpIndex:       {"OpIndex", []int{}},
+	OpSetIndex:    {"OpSetIndex", []int{}},
+	OpDup:         {"OpDup", []int{1}},
+
+	OpCall:        {"OpCall", []int{1}},
+	OpTailCall:    {"OpTailCall", []int{1}},
+	OpReturnValue: {"OpReturnValue", []int{}},
+	OpReturn:      {"OpReturn", []int{}},
+	// constant index of the function, number of free variables
+	OpClosure: {"OpClosure", []int{2, 1}},
+
+	// OpLoopEnter records the stack height so break and continue, which
+	// compile to OpLoopJump, can drop whatever a partly evaluated
+	// expression left on the stack before jumping
+	OpLoopEnter: {"OpLoopEnter", []int{}},
+	OpLoopExit:  {"OpLoopExit", []int{}},
+	OpLoopJump:  {"OpLoopJump", []int{2}},
+	OpIterInit:  {"OpIterInit", []int{}},
+	// jump target once the iterator is exhausted, number of loop variables
+	OpIterNext: {"OpIterNext", []int{2, 1}},
+
+	// OpTry starts a try block whose errors jump to the operand with the
+	// error pushed onto the stack as it was when OpTry ran, OpEndTry ends the
+	// innermost try block
+	OpTry:    {"OpTry", []int{2}},
+	OpEndTry: {"OpEndTry", []int{}},
+	OpThrow:  {"OpThrow", []int{}},
+}
+
+func Lookup(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+	return def, nil
+}
+
+//Make encodes an instruction, operands are written big endian with the widths
+//from the opcode's definition
+func Make(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	instructionLen := 1
+	for _, w := range def.OperandWidths {
+		instructionLen += w
+	}
+
+	instruction := make([]byte, instructionLen)
+	instruction[0] = byte(op)
+
+	offset := 1
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		switch width {
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
+		}
+		offset += width
+	}
+	return instruction
+}
+
+//ReadOperands decodes the operands of an instruction and returns them together
+//with the number of bytes read
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+
+	for i, width := range def.OperandWidths {
+		switch width {
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
+		}
+		offset += width
+	}
+	return operands, offset
+}
+
+func ReadUint16(ins Instructions) uint16 { return binary.BigEndian.Uint16(ins) }
+
+func ReadUint8(ins Instructions) uint8 { return uint8(ins[0]) }
+
+//SourceMapEntry records that the instructions starting at Offset were compiled
+//from the node at Pos
+type SourceMapEntry struct {
+	Offset int
+	Pos    token.Position
+	Callee string // for a call, the name the function is called through, if any
+}
+
+//SourceMap maps instruction offsets back to source positions, entries are
+//sorted by offset
+type SourceMap []SourceMapEntry
+
+//Lookup returns the position of the instruction at offset ip
+func (sm SourceMap) Lookup(ip int) token.Position {
+	return sm.Entry(ip).Pos
+}
+
+//Entry returns the entry covering the instruction at offset ip, a zero entry
+//if there is none
+func (sm SourceMap) Entry(ip int) SourceMapEntry {
+	i := sort.Search(len(sm), func(i int) bool { return sm[i].Offset > ip })
+	if i == 0 {
+		return SourceMapEntry{}
+	}
+	return sm[i-1]
+}
@@ -0,0 +1,959 @@
+package compiler
+
+import (
+	"fmt"
+	"interpreter/ast"
+	"interpreter/code"
+	"interpreter/diagnostic"
+	"interpreter/object"
+	"interpreter/resolver"
+	"interpreter/token"
+	"sort"
+)
+
+//diagnostic codes reported by the compiler
+const (
+	CodeUndefinedVariable = resolver.CodeUndefinedVariable // the same as the evaluator's
+	CodeAssignToBuiltin   = resolver.CodeAssignToBuiltin
+	CodeUnsupported       = "C002"
+	CodeOutsideLoop       = "C003"
+)
+
+type Compiler struct {
+	constants   []object.Object
+	symbolTable *SymbolTable
+
+	scopes     []CompilationScope
+	scopeIndex int
+
+	open     bool // names functions read may be globals of later programs
+	warnings []diagnostic.Diagnostic
+}
+
+type CompilationScope struct {
+	instructions        code.Instructions
+	sourceMap           code.SourceMap
+	lastInstruction     EmittedInstruction
+	previousInstruction EmittedInstruction
+	loops               []*loop     // the loops enclosing the code being compiled, innermost last
+	tries               []*tryBlock // the try blocks enclosing the code being compiled, innermost last
+}
+
+//loop tracks the jump targets of break and continue inside a loop body
+type loop struct {
+	continueTarget int
+	breakJumps     []int // positions of the OpLoopJump instructions to patch with the loop's exit
+}
+
+//tryBlock is code protected by an OpTry, which return, break and continue
+//must end with OpEndTry and the finally block, if any, before leaving it
+type tryBlock struct {
+	finally *ast.BlockStatement
+	loops   int // the number of loops enclosing the try expression
+}
+
+type EmittedInstruction struct {
+	Opcode   code.Opcode
+	Position int
+}
+
+type Bytecode struct {
+	Instructions code.Instructions
+	SourceMap    code.SourceMap
+	Constants    []object.Object
+	GlobalNames  []string // names of the global slots, used in runtime error messages
+}
+
+func New() *Compiler {
+	return &Compiler{
+		constants:   []object.Object{},
+		symbolTable: NewSymbolTableWithBuiltins(),
+		scopes:      []CompilationScope{{}},
+	}
+}
+
+//NewWithState returns a compiler that continues from the symbol table and
+//constants of an earlier compilation, which is how the REPL keeps its globals
+func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
+	compiler := New()
+	compiler.symbolTable = s
+	compiler.constants = constants
+	return compiler
+}
+
+//SetOpen compiles a program that later programs may add globals to, as in
+//the REPL. A name a function reads that is bound nowhere yet then becomes a
+//global looked up when the function runs, and is reported in Warnings.
+func (c *Compiler) SetOpen(open bool) {
+	c.open = open
+}
+
+//Warnings returns the warnings about the program compiled
+func (c *Compiler) Warnings() []diagnostic.Diagnostic {
+	return c.warnings
+}
+
+//NewSymbolTableWithBuiltins returns a global symbol table that already knows
+//every builtin, for callers that keep state between compilations
+func NewSymbolTableWithBuiltins() *SymbolTable {
+	symbolTable := NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+	return symbolTable
+}
+
+func (c *Compiler) Compile(node ast.Node) error {
+	switch node := node.(type) {
+	case *ast.Program:
+		c.hoistGlobals(node.Statements)
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+
+	case *ast.ExpressionStatement:
+		if err := c.Compile(node.Expression); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+
+	case *ast.LetStatement:
+		var err error
+		if fl, ok := node.Value.(*ast.FunctionLiteral); ok {
+			err = c.compileFunction(fl, node.Name.Value)
+		} else {
+			err = c.Compile(node.Value)
+		}
+		if err != nil {
+			return err
+		}
+
+		c.setSymbol(c.symbolTable.Define(node.Name.Value))
+
+	case *ast.ReturnStatement:
+		if err := c.Compile(node.ReturnValue); err != nil {
+			return err
+		}
+		if err := c.leaveTryBlocks(0); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+
+	case *ast.ThrowStatement:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.mark(node.Pos())
+		c.emit(code.OpThrow)
+
+	case *ast.TryExpression:
+		return c.compileTry(node)
+
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(node.Value)
+		if !ok && c.open && c.symbolTable.Outer != nil {
+			symbol = c.defineLaterGlobal(node)
+		} else if !ok {
+			return c.errorAt(node, CodeUndefinedVariable, "undefined variable %s", node.Value)
+		}
+		if symbol.Scope == GlobalScope || symbol.Scope == LocalScope || symbol.Scope == FreeScope {
+			// variables are hoisted, so they may be read before their let runs
+			c.mark(node.Pos())
+		}
+		c.loadSymbol(symbol)
+
+	case *ast.AssignExpression:
+		return c.compileAssign(node)
+
+	case *ast.IntegerLiteral:
+		var integer object.Object = &object.Integer{Value: node.Value}
+		if node.Big != nil {
+			integer = &object.BigInt{Value: node.Big}
+		}
+		c.emit(code.OpConstant, c.addConstant(integer))
+
+	case *ast.FloatLiteral:
+		float := &object.Float{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(float))
+
+	case *ast.StringLiteral:
+		str := &object.String{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(str))
+
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+
+	case *ast.PrefixExpression:
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+
+		c.mark(node.Pos())
+		switch node.Operator {
+		case "!":
+			c.emit(code.OpBang)
+		case "-":
+			c.emit(code.OpMinus)
+		default:
+			return c.errorAt(node, CodeUnsupported, "unknown operator %s", node.Operator)
+		}
+
+	case *ast.InfixExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+
+		c.mark(node.Token.Pos)
+		switch node.Operator {
+		case "+":
+			c.emit(code.OpAdd)
+		case "-":
+			c.emit(code.OpSub)
+		case "*":
+			c.emit(code.OpMul)
+		case "/":
+			c.emit(code.OpDiv)
+		case "%":
+			c.emit(code.OpMod)
+		case ">":
+			c.emit(code.OpGreaterThan)
+		case "<":
+			c.emit(code.OpLessThan)
+		case "<=":
+			c.emit(code.OpLessEqual)
+		case ">=":
+			c.emit(code.OpGreaterEqual)
+		case "==":
+			c.emit(code.OpEqual)
+		case "!=":
+			c.emit(code.OpNotEqual)
+		default:
+			return c.errorAt(node, CodeUnsupported, "unknown operator %s", node.Operator)
+		}
+
+	case *ast.LogicalExpression:
+		return c.compileLogical(node)
+
+	case *ast.IfExpression:
+		if err := c.Compile(node.Condition); err != nil {
+			return err
+		}
+
+		// emit a jump with a bogus offset, it is patched once the consequence is compiled
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+		if err := c.compileBlockValue(node.Consequence); err != nil {
+			return err
+		}
+
+		jumpPos := c.emit(code.OpJump, 9999)
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+
+		if node.Alternative == nil {
+			c.emit(code.OpNull)
+		} else if err := c.compileBlockValue(node.Alternative); err != nil {
+			return err
+		}
+
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+
+	case *ast.FunctionLiteral:
+		return c.compileFunction(node, "")
+
+	case *ast.CallExpression:
+		if err := c.Compile(node.Function); err != nil {
+			return err
+		}
+
+		for _, a := range node.Arguments {
+			if err := c.Compile(a); err != nil {
+				return err
+			}
+		}
+
+		callee := ""
+		if ident, ok := node.Function.(*ast.Identifier); ok {
+			callee = ident.Value
+		}
+		c.markCall(node.Pos(), callee)
+		if node.Tail {
+			c.emit(code.OpTailCall, len(node.Arguments))
+		} else {
+			c.emit(code.OpCall, len(node.Arguments))
+		}
+
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			if err := c.Compile(el); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpArray, len(node.Elements))
+
+	case *ast.InterpolatedString:
+		for _, part := range node.Parts {
+			if err := c.Compile(part); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpInterpolate, len(node.Parts))
+
+	case *ast.HashLiteral:
+		keys := []ast.Expression{}
+		for k := range node.Pairs {
+			keys = append(keys, k)
+		}
+		// the pairs are stored in a map, sort them so the output is deterministic
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+
+		for _, k := range keys {
+			if err := c.Compile(k); err != nil {
+				return err
+			}
+			if err := c.Compile(node.Pairs[k]); err != nil {
+				return err
+			}
+		}
+
+		c.mark(node.Pos())
+		c.emit(code.OpHash, len(node.Pairs)*2)
+
+	case *ast.IndexExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+
+		c.mark(node.Token.Pos)
+		c.emit(code.OpIndex)
+
+	case *ast.WhileStatement:
+		c.emit(code.OpLoopEnter)
+		start := len(c.currentInstructions())
+
+		if err := c.Compile(node.Condition); err != nil {
+			return err
+		}
+		exitJumpPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+		if err := c.compileLoopBody(node.Body, start); err != nil {
+			return err
+		}
+		c.emit(code.OpJump, start)
+
+		c.changeOperand(exitJumpPos, len(c.currentInstructions()))
+		c.endLoop(false)
+
+	case *ast.ForInStatement:
+		if err := c.Compile(node.Iterable); err != nil {
+			return err
+		}
+		c.mark(node.Iterable.Pos())
+		c.emit(code.OpIterInit)
+		c.emit(code.OpLoopEnter)
+		start := len(c.currentInstructions())
+
+		names := []*ast.Identifier{node.Value}
+		if node.Key != nil {
+			names = []*ast.Identifier{node.Key, node.Value}
+		}
+		nextPos := c.emit(code.OpIterNext, 9999, len(names))
+
+		// the values are pushed in order so they are bound from the last one back
+		for i := len(names) - 1; i >= 0; i-- {
+			c.setSymbol(c.symbolTable.Define(names[i].Value))
+		}
+
+		if err := c.compileLoopBody(node.Body, start); err != nil {
+			return err
+		}
+		c.emit(code.OpJump, start)
+
+		c.replaceInstruction(nextPos, code.Make(code.OpIterNext, len(c.currentInstructions()), len(names)))
+		c.endLoop(true)
+
+	case *ast.BreakStatement:
+		current := c.currentLoop()
+		if current == nil {
+			return c.errorAt(node, CodeOutsideLoop, "break outside loop")
+		}
+		if err := c.leaveTryBlocks(len(c.scopes[c.scopeIndex].loops)); err != nil {
+			return err
+		}
+		current.breakJumps = append(current.breakJumps, c.emit(code.OpLoopJump, 9999))
+
+	case *ast.ContinueStatement:
+		current := c.currentLoop()
+		if current == nil {
+			return c.errorAt(node, CodeOutsideLoop, "continue outside loop")
+		}
+		if err := c.leaveTryBlocks(len(c.scopes[c.scopeIndex].loops)); err != nil {
+			return err
+		}
+		c.emit(code.OpLoopJump, current.continueTarget)
+
+	default:
+		return c.errorAt(node, CodeUnsupported, "%T is not supported by the compiler", node)
+	}
+
+	return nil
+}
+
+//compileLoopBody compiles the body of a loop whose next iteration starts at
+//continueTarget. It must be followed by endLoop once the jump back to the
+//start of the loop has been emitted.
+func (c *Compiler) compileLoopBody(body *ast.BlockStatement, continueTarget int) error {
+	scope := &c.scopes[c.scopeIndex]
+	scope.loops = append(scope.loops, &loop{continueTarget: continueTarget})
+
+	return c.Compile(body)
+}
+
+//endLoop patches the breaks of the innermost loop to jump here and emits the
+//end of the loop, dropping the iterator of a for loop. Like any statement a
+//loop leaves no value behind, but the value of the last statement run is null
+//as it is in the evaluator.
+func (c *Compiler) endLoop(dropIterator bool) {
+	scope := &c.scopes[c.scopeIndex]
+	current := scope.loops[len(scope.loops)-1]
+	scope.loops = scope.loops[:len(scope.loops)-1]
+
+	exit := len(c.currentInstructions())
+	for _, pos := range current.breakJumps {
+		c.changeOperand(pos, exit)
+	}
+
+	c.emit(code.OpLoopExit)
+	if dropIterator {
+		c.emit(code.OpPop)
+	}
+	c.emit(code.OpNull)
+	c.emit(code.OpPop)
+}
+
+func (c *Compiler) currentLoop() *loop {
+	loops := c.scopes[c.scopeIndex].loops
+	if len(loops) == 0 {
+		return nil
+	}
+	return loops[len(loops)-1]
+}
+
+//compileTry compiles a try expression. The try block runs under an OpTry
+//jumping to the catch block, which itself runs under one jumping to code that
+//runs the finally block and throws the error again. Without a catch block the
+//OpTry of the try block jumps there directly. On the way out of the try and
+//catch blocks the finally block is compiled inline.
+func (c *Compiler) compileTry(node *ast.TryExpression) error {
+	tryPos := c.emit(code.OpTry, 9999)
+	if err := c.compileTryBlock(node.Block, node.Finally, nil); err != nil {
+		return err
+	}
+	exitJumps := []int{c.emit(code.OpJump, 9999)}
+	c.changeOperand(tryPos, len(c.currentInstructions()))
+
+	if node.Catch != nil {
+		rethrowPos := -1
+		if node.Finally != nil {
+			rethrowPos = c.emit(code.OpTry, 9999)
+		}
+
+		param, restore := c.symbolTable.DefineHidden(node.Param.Value)
+		c.setSymbol(param)
+		if node.Finally == nil {
+			err := c.compileBlockValue(node.Catch)
+			restore()
+			if err != nil {
+				return err
+			}
+		} else {
+			if err := c.compileTryBlock(node.Catch, node.Finally, restore); err != nil {
+				return err
+			}
+			exitJumps = append(exitJumps, c.emit(code.OpJump, 9999))
+			c.changeOperand(rethrowPos, len(c.currentInstructions()))
+		}
+	}
+
+	if node.Finally != nil {
+		// the error being rethrown is on the stack
+		if err := c.Compile(node.Finally); err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+	}
+
+	for _, pos := range exitJumps {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+	return nil
+}
+
+//compileTryBlock compiles block, which an OpTry has just protected, so that
+//it leaves its value on the stack, then ends the protection and runs finally.
+//leave, if set, is called once block is compiled.
+func (c *Compiler) compileTryBlock(block, finally *ast.BlockStatement, leave func()) error {
+	scope := &c.scopes[c.scopeIndex]
+	scope.tries = append(scope.tries, &tryBlock{finally: finally, loops: len(scope.loops)})
+
+	err := c.compileBlockValue(block)
+	if leave != nil {
+		leave()
+	}
+	if err != nil {
+		return err
+	}
+
+	scope = &c.scopes[c.scopeIndex]
+	scope.tries = scope.tries[:len(scope.tries)-1]
+
+	c.emit(code.OpEndTry)
+	if finally != nil {
+		return c.Compile(finally)
+	}
+	return nil
+}
+
+//leaveTryBlocks emits what jumping out of the try blocks enclosed in at least
+//loops loops takes, innermost first: ending each one and running its finally
+//block, which is compiled as if outside its own try block
+func (c *Compiler) leaveTryBlocks(loops int) error {
+	tries := c.scopes[c.scopeIndex].tries
+	defer func() { c.scopes[c.scopeIndex].tries = tries }()
+
+	for i := len(tries) - 1; i >= 0 && tries[i].loops >= loops; i-- {
+		c.emit(code.OpEndTry)
+		if tries[i].finally == nil {
+			continue
+		}
+		c.scopes[c.scopeIndex].tries = tries[:i]
+		if err := c.Compile(tries[i].finally); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//compileLogical compiles && and || so that the right operand only runs when
+//the left one does not decide the result. Both produce a boolean, the right
+//operand is turned into one by negating it twice.
+func (c *Compiler) compileLogical(node *ast.LogicalExpression) error {
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	switch node.Operator {
+	case "&&":
+		if err := c.compileTruthiness(node.Right); err != nil {
+			return err
+		}
+		jumpPos := c.emit(code.OpJump, 9999)
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+		c.emit(code.OpFalse)
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+	case "||":
+		c.emit(code.OpTrue)
+		jumpPos := c.emit(code.OpJump, 9999)
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+		if err := c.compileTruthiness(node.Right); err != nil {
+			return err
+		}
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+	default:
+		return c.errorAt(node, CodeUnsupported, "unknown operator %s", node.Operator)
+	}
+	return nil
+}
+
+func (c *Compiler) compileTruthiness(node ast.Expression) error {
+	if err := c.Compile(node); err != nil {
+		return err
+	}
+	c.emit(code.OpBang)
+	c.emit(code.OpBang)
+	return nil
+}
+
+//compileBlockValue compiles the block of an if expression so that it leaves
+//exactly one value on the stack, null if the block does not produce one
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	if err := c.Compile(block); err != nil {
+		return err
+	}
+
+	if c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
+	}
+	return nil
+}
+
+func (c *Compiler) compileFunction(node *ast.FunctionLiteral, name string) error {
+	c.enterScope()
+
+	if name != "" {
+		c.symbolTable.DefineFunctionName(name)
+	}
+
+	for _, p := range node.Parameters {
+		c.symbolTable.Define(p.Value)
+	}
+	c.hoistLocals(node.Body)
+
+	if err := c.Compile(node.Body); err != nil {
+		return err
+	}
+
+	if c.lastInstructionIs(code.OpPop) {
+		c.replaceLastPopWithReturn()
+	}
+	if !c.lastInstructionIs(code.OpReturnValue) {
+		c.emit(code.OpReturn)
+	}
+
+	freeSymbols := c.symbolTable.FreeSymbols
+	numLocals := c.symbolTable.numDefinitions
+	localNames := c.symbolTable.Names()
+	sourceMap := c.scopes[c.scopeIndex].sourceMap
+	instructions := c.leaveScope()
+
+	freeNames := make([]string, len(freeSymbols))
+	for i, s := range freeSymbols {
+		c.captureSymbol(s)
+		freeNames[i] = s.Name
+	}
+
+	compiledFn := &object.CompiledFunction{
+		Instructions:  instructions,
+		SourceMap:     sourceMap,
+		NumLocals:     numLocals,
+		NumParameters: len(node.Parameters),
+		Name:          name,
+		LocalNames:    localNames,
+		FreeNames:     freeNames,
+	}
+
+	c.emit(code.OpClosure, c.addConstant(compiledFn), len(freeSymbols))
+	return nil
+}
+
+//hoistGlobals defines every top level let binding up front so that functions
+//can refer to globals defined further down, as they can in the evaluator
+func (c *Compiler) hoistGlobals(statements []ast.Statement) {
+	if c.symbolTable.Outer != nil {
+		return
+	}
+	for _, s := range statements {
+		if let, ok := s.(*ast.LetStatement); ok {
+			c.symbolTable.Define(let.Name.Value)
+		}
+	}
+}
+
+//hoistLocals gives every let binding of a function body its slot up front so
+//that local closures can refer to each other, as they can in the evaluator
+func (c *Compiler) hoistLocals(body *ast.BlockStatement) {
+	ast.Walk(body, false, func(n ast.Node) {
+		if let, ok := n.(*ast.LetStatement); ok {
+			c.symbolTable.Hoist(let.Name.Value)
+		}
+	})
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpGetLocal, s.Index)
+	case BuiltinScope:
+		c.emit(code.OpGetBuiltin, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
+	}
+}
+
+//captureSymbol pushes what a closure keeps of the free variable s. Locals and
+//free variables are shared through cells so that assignments are seen by the
+//enclosing function and every closure capturing them.
+func (c *Compiler) captureSymbol(s Symbol) {
+	switch s.Scope {
+	case LocalScope:
+		c.emit(code.OpCaptureLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpCaptureFree, s.Index)
+	default:
+		c.loadSymbol(s)
+	}
+}
+
+//setSymbol stores the value on top of the stack in the slot of s
+func (c *Compiler) setSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, s.Index)
+	case FreeScope:
+		c.emit(code.OpSetFree, s.Index)
+	default:
+		c.emit(code.OpSetLocal, s.Index)
+	}
+}
+
+//compileAssign leaves the assigned value on the stack, as an assignment is
+//an expression
+func (c *Compiler) compileAssign(node *ast.AssignExpression) error {
+	if target, ok := node.Target.(*ast.IndexExpression); ok {
+		return c.compileIndexAssign(node, target)
+	}
+	name := node.Target.(*ast.Identifier)
+
+	symbol, ok := c.symbolTable.Resolve(name.Value)
+	if !ok {
+		return c.errorAt(name, CodeUndefinedVariable, "undefined variable %s", name.Value)
+	}
+	switch symbol.Scope {
+	case BuiltinScope:
+		return c.errorAt(name, CodeAssignToBuiltin, "cannot assign to builtin %s", name.Value)
+	case FunctionScope:
+		return c.errorAt(name, CodeUnsupported, "cannot assign to %s inside its own body", name.Value)
+	}
+
+	if node.Operator != "=" {
+		if err := c.Compile(name); err != nil {
+			return err
+		}
+	}
+	if err := c.compileAssignedValue(node); err != nil {
+		return err
+	}
+
+	c.setSymbol(symbol)
+	c.loadSymbol(symbol)
+	return nil
+}
+
+func (c *Compiler) compileIndexAssign(node *ast.AssignExpression, target *ast.IndexExpression) error {
+	if err := c.Compile(target.Left); err != nil {
+		return err
+	}
+	if err := c.Compile(target.Index); err != nil {
+		return err
+	}
+
+	if node.Operator != "=" {
+		// keep the collection and index for OpSetIndex
+		c.emit(code.OpDup, 2)
+		c.mark(target.Token.Pos)
+		c.emit(code.OpIndex)
+	}
+	if err := c.compileAssignedValue(node); err != nil {
+		return err
+	}
+
+	c.mark(target.Token.Pos)
+	c.emit(code.OpSetIndex)
+	return nil
+}
+
+//compileAssignedValue compiles the value of an assignment and, for a compound
+//assignment, the operator combining it with the current value of the target,
+//which must already be on the stack
+func (c *Compiler) compileAssignedValue(node *ast.AssignExpression) error {
+	if err := c.Compile(node.Value); err != nil {
+		return err
+	}
+	if node.Operator == "=" {
+		return nil
+	}
+
+	c.mark(node.Token.Pos)
+	switch node.Operator {
+	case "+=":
+		c.emit(code.OpAdd)
+	case "-=":
+		c.emit(code.OpSub)
+	case "*=":
+		c.emit(code.OpMul)
+	case "/=":
+		c.emit(code.OpDiv)
+	default:
+		return c.errorAt(node, CodeUnsupported, "unknown operator %s", node.Operator)
+	}
+	return nil
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	global := c.symbolTable
+	for global.Outer != nil {
+		global = global.Outer
+	}
+
+	return &Bytecode{
+		Instructions: c.currentInstructions(),
+		SourceMap:    c.scopes[c.scopeIndex].sourceMap,
+		Constants:    c.constants,
+		GlobalNames:  global.Names(),
+	}
+}
+
+//errorAt returns a compile error diagnostic spanning node
+func (c *Compiler) errorAt(node ast.Node, code string, format string, a ...interface{}) error {
+	return diagnostic.Diagnostic{
+		Severity: diagnostic.Error,
+		Code:     code,
+		Pos:      node.Pos(),
+		End:      node.End(),
+		Message:  fmt.Sprintf(format, a...),
+	}
+}
+
+//defineLaterGlobal makes name, read in a function of an open program, a
+//global that a later program may define
+func (c *Compiler) defineLaterGlobal(name *ast.Identifier) Symbol {
+	globals := c.symbolTable
+	for globals.Outer != nil {
+		globals = globals.Outer
+	}
+
+	c.warnings = append(c.warnings, diagnostic.Diagnostic{
+		Severity: diagnostic.Warning,
+		Code:     CodeUndefinedVariable,
+		Pos:      name.Pos(),
+		End:      name.End(),
+		Message:  fmt.Sprintf("undefined variable %s", name.Value),
+		Hint:     resolver.LaterGlobalHint,
+	})
+	return globals.Define(name.Value)
+}
+
+//mark records that the next instruction was compiled from source at pos, so
+//the vm can report where a runtime error happened
+func (c *Compiler) mark(pos token.Position) {
+	if !pos.IsValid() {
+		return
+	}
+	scope := &c.scopes[c.scopeIndex]
+	scope.sourceMap = append(scope.sourceMap, code.SourceMapEntry{
+		Offset: len(scope.instructions),
+		Pos:    pos,
+	})
+}
+
+//markCall is mark for a call, also recording the name the function is called
+//through for the traceback of an error raised by the call
+func (c *Compiler) markCall(pos token.Position, callee string) {
+	c.mark(pos)
+	scope := &c.scopes[c.scopeIndex]
+	if n := len(scope.sourceMap); n > 0 && scope.sourceMap[n-1].Offset == len(scope.instructions) {
+		scope.sourceMap[n-1].Callee = callee
+	}
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	ins := code.Make(op, operands...)
+	pos := c.addInstruction(ins)
+
+	c.setLastInstruction(op, pos)
+	return pos
+}
+
+func (c *Compiler) addInstruction(ins []byte) int {
+	posNewInstruction := len(c.currentInstructions())
+	c.scopes[c.scopeIndex].instructions = append(c.currentInstructions(), ins...)
+	return posNewInstruction
+}
+
+func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
+	previous := c.scopes[c.scopeIndex].lastInstruction
+	last := EmittedInstruction{Opcode: op, Position: pos}
+
+	c.scopes[c.scopeIndex].previousInstruction = previous
+	c.scopes[c.scopeIndex].lastInstruction = last
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
+}
+
+func (c *Compiler) removeLastPop() {
+	last := c.scopes[c.scopeIndex].lastInstruction
+	previous := c.scopes[c.scopeIndex].previousInstruction
+
+	old := c.currentInstructions()
+	c.scopes[c.scopeIndex].instructions = old[:last.Position]
+	c.scopes[c.scopeIndex].lastInstruction = previous
+}
+
+func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	ins := c.currentInstructions()
+
+	for i := 0; i < len(newInstruction); i++ {
+		ins[pos+i] = newInstruction[i]
+	}
+}
+
+func (c *Compiler) replaceLastPopWithReturn() {
+	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
+	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
+
+	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
+}
+
+func (c *Compiler) changeOperand(opPos int, operand int) {
+	op := code.Opcode(c.currentInstructions()[opPos])
+	newInstruction := code.Make(op, operand)
+
+	c.replaceInstruction(opPos, newInstruction)
+}
+
+func (c *Compiler) enterScope() {
+	c.scopes = append(c.scopes, CompilationScope{})
+	c.scopeIndex++
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveScope() code.Instructions {
+	instructions := c.currentInstructions()
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+	c.symbolTable = c.symbolTable.Outer
+
+	return instructions
+}
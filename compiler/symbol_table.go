@@ -0,0 +1,180 @@
+package compiler
+
+type SymbolScope string
+
+const (
+	GlobalScope   SymbolScope = "GLOBAL"
+	LocalScope    SymbolScope = "LOCAL"
+	BuiltinScope  SymbolScope = "BUILTIN"
+	FreeScope     SymbolScope = "FREE"
+	FunctionScope SymbolScope = "FUNCTION"
+)
+
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+}
+
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	store          map[string]Symbol
+	numDefinitions int
+	pending        map[string]bool // hoisted locals whose let has not been compiled yet
+
+	//FreeSymbols are the outer symbols a function refers to, in the order the
+	//closure captures them
+	FreeSymbols []Symbol
+}
+
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{store: make(map[string]Symbol), pending: make(map[string]bool)}
+}
+
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+//Copy returns a table with the same bindings as this one, which a failed
+//compilation can change without touching this one
+func (s *SymbolTable) Copy() *SymbolTable {
+	copied := &SymbolTable{
+		Outer:          s.Outer,
+		store:          make(map[string]Symbol, len(s.store)),
+		numDefinitions: s.numDefinitions,
+		pending:        make(map[string]bool, len(s.pending)),
+		FreeSymbols:    append([]Symbol(nil), s.FreeSymbols...),
+	}
+	for name, symbol := range s.store {
+		copied.store[name] = symbol
+	}
+	for name := range s.pending {
+		copied.pending[name] = true
+	}
+	return copied
+}
+
+//Define binds name in this table, reusing the slot of an existing binding of
+//the same name so that let can rebind a name the way it does in the evaluator
+func (s *SymbolTable) Define(name string) Symbol {
+	delete(s.pending, name)
+	if symbol, ok := s.store[name]; ok && (symbol.Scope == GlobalScope || symbol.Scope == LocalScope) {
+		return symbol
+	}
+
+	symbol := Symbol{Name: name, Index: s.numDefinitions}
+	if s.Outer == nil {
+		symbol.Scope = GlobalScope
+	} else {
+		symbol.Scope = LocalScope
+	}
+
+	s.store[name] = symbol
+	s.numDefinitions++
+	return symbol
+}
+
+//DefineHidden binds name to a slot of its own, hiding any other binding of
+//the name until the returned function restores it, which is how a catch
+//parameter is kept to its catch block
+func (s *SymbolTable) DefineHidden(name string) (Symbol, func()) {
+	previous, ok := s.store[name]
+	pending := s.pending[name]
+
+	delete(s.store, name)
+	delete(s.pending, name)
+	symbol := s.Define(name)
+
+	return symbol, func() {
+		delete(s.store, name)
+		if ok {
+			s.store[name] = previous
+		}
+		if pending {
+			s.pending[name] = true
+		}
+	}
+}
+
+//Hoist gives name a local slot before its let is compiled. Until then only
+//the functions nested in this one see it, code of this function still
+//resolves the name in the enclosing scopes.
+func (s *SymbolTable) Hoist(name string) {
+	if _, ok := s.store[name]; !ok {
+		s.Define(name)
+		s.pending[name] = true
+	}
+}
+
+func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	symbol := Symbol{Name: name, Index: index, Scope: BuiltinScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+//DefineFunctionName makes the name a function literal was bound to resolve to
+//the running closure itself, which is what lets it call itself recursively
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	// a hoisted local keeps its slot, the outer variable it hides for now is
+	// captured without being stored under the name
+	if s.pending[original.Name] {
+		for i, free := range s.FreeSymbols {
+			if free == original {
+				return Symbol{Name: original.Name, Index: i, Scope: FreeScope}
+			}
+		}
+	}
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1, Scope: FreeScope}
+	if !s.pending[original.Name] {
+		s.store[original.Name] = symbol
+	}
+	return symbol
+}
+
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	return s.resolve(name, false)
+}
+
+//resolve finds the symbol of name, nested tells that the lookup comes from a
+//function nested in this one, which sees hoisted locals before their let
+func (s *SymbolTable) resolve(name string, nested bool) (Symbol, bool) {
+	symbol, ok := s.store[name]
+	if ok && s.pending[name] && !nested {
+		ok = false
+	}
+	if !ok && s.Outer != nil {
+		symbol, ok = s.Outer.resolve(name, true)
+		if !ok {
+			return symbol, ok
+		}
+
+		if symbol.Scope == GlobalScope || symbol.Scope == BuiltinScope {
+			return symbol, ok
+		}
+
+		return s.defineFree(symbol), true
+	}
+	return symbol, ok
+}
+
+//Names returns the names of the symbols defined in this table indexed by slot
+func (s *SymbolTable) Names() []string {
+	names := make([]string, s.numDefinitions)
+	for name, symbol := range s.store {
+		if symbol.Scope == GlobalScope || symbol.Scope == LocalScope {
+			names[symbol.Index] = name
+		}
+	}
+	return names
+}
@@ -0,0 +1,122 @@
+package diagnostic
+
+import (
+	"fmt"
+	"interpreter/token"
+	"io"
+	"strings"
+)
+
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+	Note
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	case Note:
+		return "note"
+	default:
+		return fmt.Sprintf("severity(%d)", int(s))
+	}
+}
+
+//Diagnostic is a message about a span of source code, reported by the parser or
+//any later pass that inspects the program before it runs
+type Diagnostic struct {
+	Severity Severity
+	Code     string         // stable identifier of the kind of problem, e.g. P001
+	Pos      token.Position // start of the offending span
+	End      token.Position // position immediately after the offending span
+	Message  string
+	Hint     string // optional suggestion on how to fix the problem
+}
+
+//String formats the diagnostic on a single line as file:line:col: severity[code]: message
+func (d Diagnostic) String() string {
+	var out strings.Builder
+
+	out.WriteString(d.Pos.String())
+	out.WriteString(": ")
+	out.WriteString(d.Severity.String())
+	if d.Code != "" {
+		out.WriteString("[" + d.Code + "]")
+	}
+	out.WriteString(": ")
+	out.WriteString(d.Message)
+
+	return out.String()
+}
+
+func (d Diagnostic) Error() string { return d.String() }
+
+//HasErrors reports whether any of the diagnostics has Error severity
+func HasErrors(diagnostics []Diagnostic) bool {
+	for _, d := range diagnostics {
+		if d.Severity == Error {
+			return true
+		}
+	}
+	return false
+}
+
+//Render writes every diagnostic followed by the source line it points at and
+//a caret underline beneath the offending span
+func Render(w io.Writer, source string, diagnostics []Diagnostic) {
+	lines := strings.Split(source, "\n")
+
+	for _, d := range diagnostics {
+		fmt.Fprintln(w, d.String())
+
+		if d.Pos.IsValid() && d.Pos.Line <= len(lines) {
+			line := strings.TrimRight(lines[d.Pos.Line-1], "\r")
+			gutter := fmt.Sprintf("%d", d.Pos.Line)
+			blank := strings.Repeat(" ", len(gutter))
+
+			fmt.Fprintf(w, " %s | %s\n", gutter, line)
+			fmt.Fprintf(w, " %s | %s\n", blank, underline(line, d))
+		}
+
+		if d.Hint != "" {
+			fmt.Fprintf(w, "  hint: %s\n", d.Hint)
+		}
+	}
+}
+
+//underline returns the caret marker for the span of d on line, copying tabs
+//from the line so the carets stay aligned in the terminal
+func underline(line string, d Diagnostic) string {
+	start := d.Pos.Column - 1
+	if start > len(line) {
+		start = len(line)
+	}
+
+	width := 1
+	if d.End.Line == d.Pos.Line && d.End.Column > d.Pos.Column {
+		width = d.End.Column - d.Pos.Column
+	} else if d.End.Line > d.Pos.Line {
+		width = len(line) - start
+	}
+	if width < 1 {
+		width = 1
+	}
+
+	var out strings.Builder
+	for i := 0; i < start; i++ {
+		if line[i] == '\t' {
+			out.WriteByte('\t')
+		} else {
+			out.WriteByte(' ')
+		}
+	}
+	out.WriteString(strings.Repeat("^", width))
+
+	return out.String()
+}
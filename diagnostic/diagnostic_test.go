@@ -0,0 +1,56 @@
+package diagnostic
+
+import (
+	"bytes"
+	"interpreter/token"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	source := "let x = 5;\n\tlet = 10;"
+	diagnostics := []Diagnostic{
+		{
+			Severity: Error,
+			Code:     "P001",
+			Pos:      token.Position{Filename: "main.hk", Line: 2, Column: 6},
+			End:      token.Position{Filename: "main.hk", Line: 2, Column: 7},
+			Message:  "expected token IDENT got = instead",
+			Hint:     "name the binding: let <name> = <value>;",
+		},
+	}
+
+	var out bytes.Buffer
+	Render(&out, source, diagnostics)
+
+	expected := "main.hk:2:6: error[P001]: expected token IDENT got = instead\n" +
+		" 2 | \tlet = 10;\n" +
+		"   | \t    ^\n" +
+		"  hint: name the binding: let <name> = <value>;\n"
+
+	if out.String() != expected {
+		t.Errorf("wrong rendering.\nexpected=\n%s\ngot=\n%s", expected, out.String())
+	}
+}
+
+func TestRenderSpan(t *testing.T) {
+	source := "foo + barbaz"
+	diagnostics := []Diagnostic{
+		{
+			Severity: Warning,
+			Pos:      token.Position{Line: 1, Column: 7},
+			End:      token.Position{Line: 1, Column: 13},
+			Message:  "unused",
+		},
+	}
+
+	var out bytes.Buffer
+	Render(&out, source, diagnostics)
+
+	expected := "1:7: warning: unused\n" +
+		" 1 | foo + barbaz\n" +
+		"   |       ^^^^^^\n"
+
+	if out.String() != expected {
+		t.Errorf("wrong rendering.\nexpected=\n%s\ngot=\n%s", expected, out.String())
+	}
+}
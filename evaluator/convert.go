@@ -0,0 +1,407 @@
+package evaluator
+
+import (
+	"fmt"
+	"interpreter/object"
+	"math"
+	"math/big"
+	"reflect"
+)
+
+var (
+	objectType = reflect.TypeOf((*object.Object)(nil)).Elem()
+	errorType  = reflect.TypeOf((*error)(nil)).Elem()
+	bigIntType = reflect.TypeOf((*big.Int)(nil))
+)
+
+//ToObject converts a Go value to an object. Booleans, integers, floats and
+//strings become the matching objects, slices and arrays become arrays, maps
+//become hashes, nil becomes null and functions become builtins whose
+//arguments and results are converted in turn. An object is returned as is.
+func ToObject(v interface{}) (object.Object, error) {
+	if v == nil {
+		return NULL, nil
+	}
+	if obj, ok := v.(object.Object); ok {
+		return obj, nil
+	}
+	return toObject(reflect.ValueOf(v))
+}
+
+func toObject(v reflect.Value) (object.Object, error) {
+	if v.Type().Implements(objectType) {
+		if v.IsNil() {
+			return NULL, nil
+		}
+		return v.Interface().(object.Object), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return nativeBooltoBooleanObject(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &object.Integer{Value: v.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return &object.BigInt{Value: new(big.Int).SetUint64(v.Uint())}, nil
+		}
+		return &object.Integer{Value: int64(v.Uint())}, nil
+	case reflect.Float32, reflect.Float64:
+		return &object.Float{Value: v.Float()}, nil
+	case reflect.String:
+		return &object.String{Value: v.String()}, nil
+	case reflect.Slice, reflect.Array:
+		elements := make([]object.Object, v.Len())
+		for i := range elements {
+			el, err := toObject(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = el
+		}
+		return &object.Array{Elements: elements}, nil
+	case reflect.Map:
+		return mapToHash(v)
+	case reflect.Func:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		return funcToBuiltin(v), nil
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		if v.Type() == bigIntType {
+			return object.NewBigInteger(new(big.Int).Set(v.Interface().(*big.Int))), nil
+		}
+		return toObject(v.Elem())
+	default:
+		return nil, fmt.Errorf("cannot convert %s to an object", v.Type())
+	}
+}
+
+func mapToHash(v reflect.Value) (object.Object, error) {
+	pairs := make(map[object.HashKey]object.HashPair, v.Len())
+
+	iter := v.MapRange()
+	for iter.Next() {
+		key, err := toObject(iter.Key())
+		if err != nil {
+			return nil, err
+		}
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+		}
+		value, err := toObject(iter.Value())
+		if err != nil {
+			return nil, err
+		}
+		pairs[hashKey.HashKey()] = object.HashPair{Key: key, Value: value}
+	}
+	return &object.Hash{Pairs: pairs}, nil
+}
+
+//funcToBuiltin wraps a Go function in a builtin. A non-nil error as the last
+//result becomes an error object, the other results are converted with
+//ToObject: none gives null, one its value and several an array.
+func funcToBuiltin(fn reflect.Value) *object.Builtin {
+	t := fn.Type()
+
+	return &object.Builtin{Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+		fixed := t.NumIn()
+		if t.IsVariadic() {
+			fixed--
+		}
+		if len(args) < fixed || !t.IsVariadic() && len(args) > fixed {
+			return newError("wrong number of arguments. got=%d, want=%d", len(args), fixed)
+		}
+
+		in := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			var paramType reflect.Type
+			if t.IsVariadic() && i >= fixed {
+				paramType = t.In(fixed).Elem()
+			} else {
+				paramType = t.In(i)
+			}
+			value, err := fromObject(arg, paramType)
+			if err != nil {
+				return newError("argument %d: %s", i+1, err)
+			}
+			in[i] = value
+		}
+
+		out := fn.Call(in)
+		if n := len(out); n > 0 && t.Out(n-1) == errorType {
+			if err := out[n-1].Interface(); err != nil {
+				return newError("%s", err)
+			}
+			out = out[:n-1]
+		}
+
+		results := make([]object.Object, len(out))
+		for i, value := range out {
+			result, err := toObject(value)
+			if err != nil {
+				return newError("result %d: %s", i+1, err)
+			}
+			results[i] = result
+		}
+
+		switch len(results) {
+		case 0:
+			return NULL
+		case 1:
+			return results[0]
+		default:
+			return &object.Array{Elements: results}
+		}
+	}}
+}
+
+//FromObject stores obj in the Go value target points to, converting it the
+//opposite way to ToObject. An empty interface receives int64, float64,
+//*big.Int, bool, string, nil, []interface{} or map[interface{}]interface{}
+//values, and a function type a Go function that calls obj.
+func FromObject(obj object.Object, target interface{}) error {
+	ptr := reflect.ValueOf(target)
+	if ptr.Kind() != reflect.Ptr || ptr.IsNil() {
+		return fmt.Errorf("target must be a non-nil pointer, got %T", target)
+	}
+
+	value, err := fromObject(obj, ptr.Elem().Type())
+	if err != nil {
+		return err
+	}
+	ptr.Elem().Set(value)
+	return nil
+}
+
+func fromObject(obj object.Object, t reflect.Type) (reflect.Value, error) {
+	if obj == nil {
+		obj = NULL
+	}
+	if reflect.TypeOf(obj).AssignableTo(t) && (t.Kind() != reflect.Interface || t.NumMethod() > 0) {
+		return reflect.ValueOf(obj), nil
+	}
+	if obj == NULL {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Func, reflect.Interface:
+			return reflect.Zero(t), nil
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		if t.NumMethod() == 0 {
+			return fromObjectNatural(obj, t)
+		}
+	case reflect.Bool:
+		if b, ok := obj.(*object.Boolean); ok {
+			return reflect.ValueOf(b.Value).Convert(t), nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, ok := obj.(*object.Integer); ok {
+			v := reflect.New(t).Elem()
+			if v.OverflowInt(i.Value) {
+				return v, fmt.Errorf("%d overflows %s", i.Value, t)
+			}
+			v.SetInt(i.Value)
+			return v, nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if i, ok := obj.(*object.Integer); ok {
+			v := reflect.New(t).Elem()
+			if i.Value < 0 || v.OverflowUint(uint64(i.Value)) {
+				return v, fmt.Errorf("%d overflows %s", i.Value, t)
+			}
+			v.SetUint(uint64(i.Value))
+			return v, nil
+		}
+	case reflect.Float32, reflect.Float64:
+		if f, ok := object.ToFloat(obj); ok {
+			return reflect.ValueOf(f).Convert(t), nil
+		}
+	case reflect.String:
+		if s, ok := obj.(*object.String); ok {
+			return reflect.ValueOf(s.Value).Convert(t), nil
+		}
+	case reflect.Slice:
+		if arr, ok := obj.(*object.Array); ok {
+			v := reflect.MakeSlice(t, len(arr.Elements), len(arr.Elements))
+			for i, el := range arr.Elements {
+				converted, err := fromObject(el, t.Elem())
+				if err != nil {
+					return v, err
+				}
+				v.Index(i).Set(converted)
+			}
+			return v, nil
+		}
+	case reflect.Map:
+		if hash, ok := obj.(*object.Hash); ok {
+			v := reflect.MakeMapWithSize(t, len(hash.Pairs))
+			for _, pair := range hash.Pairs {
+				key, err := fromObject(pair.Key, t.Key())
+				if err != nil {
+					return v, err
+				}
+				value, err := fromObject(pair.Value, t.Elem())
+				if err != nil {
+					return v, err
+				}
+				v.SetMapIndex(key, value)
+			}
+			return v, nil
+		}
+	case reflect.Func:
+		if obj.Type() == object.FUNCTON_OBJ || obj.Type() == object.BUILTIN_OBJ {
+			return callableToFunc(obj, t), nil
+		}
+	case reflect.Ptr:
+		if t == bigIntType {
+			switch i := obj.(type) {
+			case *object.Integer:
+				return reflect.ValueOf(big.NewInt(i.Value)), nil
+			case *object.BigInt:
+				return reflect.ValueOf(new(big.Int).Set(i.Value)), nil
+			}
+		}
+	}
+
+	return reflect.Value{}, fmt.Errorf("cannot convert %s to %s", obj.Type(), t)
+}
+
+//fromObjectNatural converts obj to the Go value closest to it, for storing
+//in an empty interface of type t
+func fromObjectNatural(obj object.Object, t reflect.Type) (reflect.Value, error) {
+	var natural interface{}
+
+	switch obj := obj.(type) {
+	case *object.Null:
+		return reflect.Zero(t), nil
+	case *object.Integer:
+		natural = obj.Value
+	case *object.BigInt:
+		natural = new(big.Int).Set(obj.Value)
+	case *object.Float:
+		natural = obj.Value
+	case *object.Boolean:
+		natural = obj.Value
+	case *object.String:
+		natural = obj.Value
+	case *object.Array:
+		elements := make([]interface{}, len(obj.Elements))
+		for i, el := range obj.Elements {
+			if err := FromObject(el, &elements[i]); err != nil {
+				return reflect.Value{}, err
+			}
+		}
+		natural = elements
+	case *object.Hash:
+		pairs := make(map[interface{}]interface{}, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			var key, value interface{}
+			if err := FromObject(pair.Key, &key); err != nil {
+				return reflect.Value{}, err
+			}
+			if err := FromObject(pair.Value, &value); err != nil {
+				return reflect.Value{}, err
+			}
+			pairs[key] = value
+		}
+		natural = pairs
+	default:
+		natural = obj
+	}
+
+	v := reflect.New(t).Elem()
+	v.Set(reflect.ValueOf(natural))
+	return v, nil
+}
+
+//callableToFunc returns a Go function of type t that calls a function or
+//builtin object. An error raised by the call is returned if the last result
+//of t is an error and panics otherwise.
+func callableToFunc(fn object.Object, t reflect.Type) reflect.Value {
+	return reflect.MakeFunc(t, func(in []reflect.Value) []reflect.Value {
+		values := in
+		if t.IsVariadic() {
+			last := in[len(in)-1]
+			values = in[: len(in)-1 : len(in)-1]
+			for i := 0; i < last.Len(); i++ {
+				values = append(values, last.Index(i))
+			}
+		}
+
+		args := make([]object.Object, len(values))
+		var err error
+		for i, value := range values {
+			if args[i], err = toObject(value); err != nil {
+				break
+			}
+		}
+
+		var result object.Object
+		if err == nil {
+			result = callFromGo(fn, args)
+			if errObj, ok := result.(*object.Error); ok {
+				err = errObj
+			}
+		}
+
+		return funcResults(t, result, err)
+	})
+}
+
+//callFromGo applies fn for a Go caller, turning a panic into an error
+func callFromGo(fn object.Object, args []object.Object) (result object.Object) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = newError("internal error: %v", r)
+		}
+	}()
+	return applyFunction(contextOf(fn), fn, args, callSite{})
+}
+
+//contextOf returns the context a function object runs in when called from Go,
+//that of the environment it was defined in
+func contextOf(fn object.Object) *object.Context {
+	if fn, ok := fn.(*object.Function); ok {
+		return fn.Env.Context()
+	}
+	return object.DefaultContext
+}
+
+//funcResults converts the result of a call to the results of a Go function
+//of type t, which may end with an error. Only the first result receives the
+//value, any others are left zero.
+func funcResults(t reflect.Type, result object.Object, err error) []reflect.Value {
+	out := make([]reflect.Value, t.NumOut())
+	for i := range out {
+		out[i] = reflect.Zero(t.Out(i))
+	}
+
+	returnsError := len(out) > 0 && t.Out(len(out)-1) == errorType
+	values := len(out)
+	if returnsError {
+		values--
+	}
+
+	if err == nil && values > 0 {
+		var converted reflect.Value
+		converted, err = fromObject(result, t.Out(0))
+		if err == nil {
+			out[0] = converted
+		}
+	}
+
+	if err != nil {
+		if !returnsError {
+			panic(err)
+		}
+		out[len(out)-1] = reflect.ValueOf(&err).Elem()
+	}
+	return out
+}
@@ -1,18 +1,29 @@
 package evaluator
 
 import (
+	"context"
 	"fmt"
 	"interpreter/ast"
 	"interpreter/object"
+	"interpreter/resolver"
+	"interpreter/token"
+	"strings"
 )
 
 var (
-	NULL  = &object.Null{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	NULL  = object.NULL
+	TRUE  = object.TRUE
+	FALSE = object.FALSE
+
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	if err := env.Budget().Step(); err != nil {
+		return err
+	}
+
 	switch node := node.(type) {
 
 	//Statements
@@ -22,7 +33,12 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return Eval(node.Expression, env)
 	// Expressions
 	case *ast.IntegerLiteral:
+		if node.Big != nil {
+			return &object.BigInt{Value: node.Big}
+		}
 		return &object.Integer{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 	case *ast.Boolean:
 		return nativeBooltoBooleanObject(node.Value)
 	case *ast.PrefixExpression:
@@ -30,7 +46,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(right) {
 			return right
 		}
-		return evalPrefixExpression(node.Operator, right)
+		return errorAt(node.Pos(), evalPrefixExpression(node.Operator, right))
 	case *ast.InfixExpression:
 		left := Eval(node.Left, env)
 		if isError(left) {
@@ -40,7 +56,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		return errorAt(node.Token.Pos, track(env, evalInfixExpression(node.Operator, left, right)))
 	case *ast.BlockStatement:
 		return evalBlockStatement(node, env)
 	case *ast.IfExpression:
@@ -56,9 +72,13 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(val) {
 			return val
 		}
-		env.Set(node.Name.Value, val)
+		bind(env, node.Name, val)
 	case *ast.Identifier:
-		return evalIdentifier(node, env)
+		return errorAt(node.Pos(), evalIdentifier(node, env))
+	case *ast.AssignExpression:
+		return evalAssignExpression(node, env)
+	case *ast.LogicalExpression:
+		return evalLogicalExpression(node, env)
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
@@ -67,6 +87,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			Parameters: params,
 			Body:       body,
 			Env:        env,
+			Slots:      node.Slots,
 		}
 	case *ast.CallExpression:
 		function := Eval(node.Function, env)
@@ -79,15 +100,24 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return args[0]
 		}
 
-		return applyFunction(function, args)
+		if fn, ok := function.(*object.Function); ok && node.Tail {
+			return &tailCall{fn: fn, args: args, site: callSiteOf(node)}
+		}
+		return errorAt(node.Pos(), applyFunction(env.Context(), function, args, callSiteOf(node)))
 	case *ast.StringLiteral:
-		return &object.String{Value: node.Value}
+		return track(env, &object.String{Value: node.Value})
+	case *ast.InterpolatedString:
+		parts := evalExpressions(node.Parts, env)
+		if len(parts) == 1 && isError(parts[0]) {
+			return parts[0]
+		}
+		return track(env, object.Interpolate(parts))
 	case *ast.ArrayLiteral:
 		elements := evalExpressions(node.Elements,env)
 		if len(elements) == 1 && isError(elements[0]) {
 			return elements[0]
 		}
-		return &object.Array{Elements: elements}
+		return track(env, &object.Array{Elements: elements})
 	case *ast.IndexExpression:
 		left := Eval(node.Left,env)
 		if isError(left) {
@@ -97,9 +127,29 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(index){
 			return index
 		}
-		return evalIndexExpression(left, index)
+		result := evalIndexExpression(left, index)
+		if left.Type() == object.STRING_OBJ {
+			result = track(env, result)
+		}
+		return errorAt(node.Token.Pos, result)
 	case *ast.HashLiteral:
-		return evalHashLiteral(node, env)
+		return track(env, evalHashLiteral(node, env))
+	case *ast.WhileStatement:
+		return evalWhileStatement(node, env)
+	case *ast.ForInStatement:
+		return evalForInStatement(node, env)
+	case *ast.ThrowStatement:
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		return errorAt(node.Pos(), object.Throw(val))
+	case *ast.TryExpression:
+		return evalTryExpression(node, env)
+	case *ast.BreakStatement:
+		return BREAK
+	case *ast.ContinueStatement:
+		return CONTINUE
 	}
 	return nil
 }
@@ -109,14 +159,39 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 	case "!":
 		return evalBangOperatorExpression(right)
 	case "-":
-		return evalMinusOperatorExpression(right)
+		return object.Negate(right)
 	default:
 		return newError("unknown operator: %s%s", operator, right.Type())
 	}
 }
 
-func evalProgram(program *ast.Program, env *object.Environment) object.Object {
-	var result object.Object
+//EvalContext evaluates node like Eval under a fresh budget, stopping with an
+//error of a limit kind once ctx is done or the program exceeds limits
+func EvalContext(ctx context.Context, node ast.Node, env *object.Environment, limits object.Limits) object.Object {
+	previous := env.SetBudget(object.NewBudget(ctx, limits))
+	defer env.SetBudget(previous)
+
+	return Eval(node, env)
+}
+
+//evalProgram resolves the program unless the caller already has, the first
+//diagnostic becoming the error it returns, and runs it. It turns a Go panic,
+//for instance in a builtin, into
+//an error object so that a host embedding the interpreter keeps running.
+func evalProgram(program *ast.Program, env *object.Environment) (result object.Object) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = newError("internal error: %v", r)
+		}
+	}()
+
+	if !program.Resolved {
+		if diagnostics := resolver.Resolve(program, env); len(diagnostics) != 0 {
+			d := diagnostics[0]
+			return &object.Error{Message: d.Message, Pos: d.Pos}
+		}
+	}
+	env.ReserveSlots(program.Slots)
 
 	for _, s := range program.Statements {
 		result = Eval(s, env)
@@ -150,59 +225,27 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 	}
 }
 
-func evalMinusOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.INTEGER_OBJ {
-		return newError("unknown operator: -%s", right.Type())
-	}
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
-}
-
 func evalInfixExpression(operator string, left, right object.Object) object.Object {
 
 	switch {
-	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
-		return evalIntegerInfixExpression(operator, left, right)
+	case object.IsInteger(left) && object.IsInteger(right):
+		return object.IntegerInfix(operator, left, right)
+	case object.IsNumber(left) && object.IsNumber(right):
+		return object.FloatInfix(operator, left, right)
 	case operator == "==":
-		return nativeBooltoBooleanObject(left == right)
+		return nativeBooltoBooleanObject(object.Equal(left, right))
 	case operator == "!=":
-		return nativeBooltoBooleanObject(left != right)
+		return nativeBooltoBooleanObject(!object.Equal(left, right))
 	case left.Type() != right.Type():
 		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
-		return evalStringInfixExpression(operator, left, right)
+		return object.StringInfix(operator, left, right)
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 
 	}
 }
 
-func evalIntegerInfixExpression(operator string, left object.Object, right object.Object) object.Object {
-	leftVal := left.(*object.Integer).Value
-	rightVal := right.(*object.Integer).Value
-
-	switch operator {
-	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
-	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
-	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
-	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
-	case "<":
-		return nativeBooltoBooleanObject(leftVal < rightVal)
-	case ">":
-		return nativeBooltoBooleanObject(leftVal > rightVal)
-	case "==":
-		return nativeBooltoBooleanObject(leftVal == rightVal)
-	case "!=":
-		return nativeBooltoBooleanObject(leftVal != rightVal)
-	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
-	}
-}
-
 func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
 
 	condition := Eval(ie.Condition, env)
@@ -219,6 +262,36 @@ func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Obje
 	}
 }
 
+//evalTryExpression runs the catch block on an error, then the finally block
+func evalTryExpression(te *ast.TryExpression, env *object.Environment) object.Object {
+	result := Eval(te.Block, env)
+
+	if err, ok := result.(*object.Error); ok && !err.IsLimit() && te.Catch != nil {
+		bind(env, te.Param, &object.ErrorValue{Err: err})
+		result = Eval(te.Catch, env)
+	}
+
+	// a limit error skips finally so the program stops at once
+	if err, ok := result.(*object.Error); ok && err.IsLimit() {
+		return result
+	}
+
+	// finally replaces the result only by leaving with an error, return, break or continue
+	if te.Finally != nil {
+		if final := Eval(te.Finally, env); final != nil {
+			switch final.Type() {
+			case object.ERROR_OBJ, object.RETURN_VALUE_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
+				return final
+			}
+		}
+	}
+
+	if result == nil {
+		return NULL
+	}
+	return result
+}
+
 func isTruthy(obj object.Object) bool {
 
 	switch obj {
@@ -242,7 +315,7 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 
 		if result != nil {
 			rt := result.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ || rt == object.BREAK_OBJ || rt == object.CONTINUE_OBJ {
 				return result
 			}
 		}
@@ -251,24 +324,182 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 }
 
 func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
-	if val, ok := env.Get(node.Value); ok {
+	if val, ok := lookup(env, node); ok {
 		return val
 	}
 
-	if builtin, ok := builtins[node.Value]; ok {
+	if builtin, ok := env.Builtin(node.Value); ok && !node.Local {
 		return builtin
 	}
 	return newError("identifier not found:" + node.Value)
 
 }
 
+//lookup finds the variable name refers to, in the slot the resolver gave it
+//or, for a global, by name. It reports false for a variable not set yet.
+func lookup(env *object.Environment, name *ast.Identifier) (object.Object, bool) {
+	if !name.Local {
+		return env.Get(name.Value)
+	}
+	val := env.Slot(name.Depth, name.Slot)
+	return val, val != nil
+}
+
+//bind sets the variable name declares or assigns to, in its slot or, for a
+//global, by name
+func bind(env *object.Environment, name *ast.Identifier, val object.Object) {
+	if !name.Local {
+		env.Set(name.Value, val)
+		return
+	}
+	env.SetSlot(name.Depth, name.Slot, val)
+}
+
+//evalLogicalExpression only evaluates the right operand of && and || when the
+//left one does not decide the result, which is always a boolean
+func evalLogicalExpression(node *ast.LogicalExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+
+	switch node.Operator {
+	case "&&":
+		if !isTruthy(left) {
+			return FALSE
+		}
+	case "||":
+		if isTruthy(left) {
+			return TRUE
+		}
+	default:
+		return errorAt(node.Token.Pos, newError("unknown operator: %s", node.Operator))
+	}
+
+	right := Eval(node.Right, env)
+	if isError(right) {
+		return right
+	}
+	return nativeBooltoBooleanObject(isTruthy(right))
+}
+
+func evalAssignExpression(node *ast.AssignExpression, env *object.Environment) object.Object {
+	if target, ok := node.Target.(*ast.IndexExpression); ok {
+		return evalIndexAssignment(node, target, env)
+	}
+	return evalVariableAssignment(node, node.Target.(*ast.Identifier), env)
+}
+
+//evalVariableAssignment rebinds an existing variable wherever it was defined,
+//which is what lets a closure update a variable of an enclosing function
+func evalVariableAssignment(node *ast.AssignExpression, name *ast.Identifier, env *object.Environment) object.Object {
+	current, ok := lookup(env, name)
+	if !ok {
+		if _, ok := env.Builtin(name.Value); ok && !name.Local {
+			return errorAt(name.Pos(), newError("cannot assign to builtin %s", name.Value))
+		}
+		return errorAt(name.Pos(), newError("identifier not found:%s", name.Value))
+	}
+
+	val := Eval(node.Value, env)
+	if isError(val) {
+		return val
+	}
+
+	val = evalCompoundOperator(node, current, val, env)
+	if isError(val) {
+		return val
+	}
+
+	if !name.Local {
+		env.Assign(name.Value, val)
+	} else {
+		env.SetSlot(name.Depth, name.Slot, val)
+	}
+	return val
+}
+
+//evalIndexAssignment changes an element of an array or hash in place
+func evalIndexAssignment(node *ast.AssignExpression, target *ast.IndexExpression, env *object.Environment) object.Object {
+	left := Eval(target.Left, env)
+	if isError(left) {
+		return left
+	}
+	index := Eval(target.Index, env)
+	if isError(index) {
+		return index
+	}
+
+	var current object.Object
+	if node.Operator != "=" {
+		current = errorAt(target.Token.Pos, evalIndexExpression(left, index))
+		if isError(current) {
+			return current
+		}
+	}
+
+	val := Eval(node.Value, env)
+	if isError(val) {
+		return val
+	}
+
+	val = evalCompoundOperator(node, current, val, env)
+	if isError(val) {
+		return val
+	}
+
+	size := object.SizeOf(left)
+	if result := object.SetIndex(left, index, val); isError(result) {
+		return errorAt(target.Token.Pos, result)
+	}
+	if err := env.Budget().Charge(object.SizeOf(left) - size); err != nil {
+		return errorAt(target.Token.Pos, err)
+	}
+	return val
+}
+
+//evalCompoundOperator returns the value a compound assignment such as += stores,
+//which for a plain = is val itself
+func evalCompoundOperator(node *ast.AssignExpression, current, val object.Object, env *object.Environment) object.Object {
+	if node.Operator == "=" {
+		return val
+	}
+	operator := strings.TrimSuffix(node.Operator, "=")
+	return errorAt(node.Token.Pos, track(env, evalInfixExpression(operator, current, val)))
+}
+
 func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
 
+//errorAt records pos on obj if it is an error that has no position yet, so the
+//innermost node that raised an error determines where it is reported
+func errorAt(pos token.Position, obj object.Object) object.Object {
+	if err, ok := obj.(*object.Error); ok && !err.Pos.IsValid() {
+		err.Pos = pos
+	}
+	return obj
+}
+
+//track charges obj, a value the evaluator has just built, to the budget of
+//env and returns it, or the error to return instead once the memory limit is
+//exceeded
+func track(env *object.Environment, obj object.Object) object.Object {
+	if err := env.Budget().Charge(object.SizeOf(obj)); err != nil {
+		return err
+	}
+	return obj
+}
+
+//isError reports whether obj is an error, or a return, break or continue
+//from an if used as an expression, all of which are passed up unchanged to
+//the function or loop they leave
 func isError(obj object.Object) bool {
 	if obj != nil {
-		return obj.Type() == object.ERROR_OBJ
+		switch obj.Type() {
+		case object.ERROR_OBJ, object.RETURN_VALUE_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
+			return true
+		}
 	}
 	return false
 }
@@ -287,25 +518,90 @@ func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Ob
 	return result
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+//callSite is where a function was called from, for tracebacks
+type callSite struct {
+	name string // the name the function was called through, if any
+	pos  token.Position
+}
+
+func callSiteOf(node *ast.CallExpression) callSite {
+	site := callSite{pos: node.Pos()}
+	if ident, ok := node.Function.(*ast.Identifier); ok {
+		site.name = ident.Value
+	}
+	return site
+}
+
+func applyFunction(ctx *object.Context, fn object.Object, args []object.Object, site callSite) object.Object {
 	switch fn := fn.(type) {
 
 	case *object.Function:
-		extendedEnv := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv)
-		return unwrapReturnValue(evaluated)
+		if len(args) != len(fn.Parameters) {
+			return newError("wrong number of arguments: want=%d, got=%d", len(fn.Parameters), len(args))
+		}
+		budget := fn.Env.Budget()
+		if err := budget.Enter(); err != nil {
+			return err
+		}
+		defer budget.Leave()
+
+		// a tail call of the body replaces the call being applied, so
+		// recursion through tail calls runs in constant Go stack space
+		for {
+			extendedEnv := extendFunctionEnv(fn, args)
+			evaluated := unwrapReturnValue(Eval(fn.Body, extendedEnv))
+			tail, ok := evaluated.(*tailCall)
+			if !ok {
+				if err, ok := evaluated.(*object.Error); ok {
+					err.Frames = append(err.Frames, newFrame(site, args))
+				}
+				// a body ending in a let has no value
+				if evaluated == nil {
+					return NULL
+				}
+				return evaluated
+			}
+			if len(tail.args) != len(tail.fn.Parameters) {
+				err := newError("wrong number of arguments: want=%d, got=%d", len(tail.fn.Parameters), len(tail.args))
+				err.Pos = tail.site.pos
+				err.Frames = append(err.Frames, newFrame(site, args))
+				return err
+			}
+			fn, args, site = tail.fn, tail.args, tail.site
+		}
 	case *object.Builtin:
-		return fn.Fn(args...)
+		if result := fn.Fn(ctx, args...); result != nil {
+			return result
+		}
+		return NULL
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
 }
 
+//tailCall is a call in tail position, left for applyFunction to make
+type tailCall struct {
+	fn   *object.Function
+	args []object.Object
+	site callSite
+}
+
+func (tc *tailCall) Type() object.ObjectType { return "TAIL_CALL" }
+func (tc *tailCall) Inspect() string         { return "tail call" }
+
+func newFrame(site callSite, args []object.Object) object.Frame {
+	types := make([]object.ObjectType, len(args))
+	for i, arg := range args {
+		types[i] = arg.Type()
+	}
+	return object.Frame{Function: site.name, Pos: site.pos, Args: types}
+}
+
 func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
-	env := object.NewEnclosedEnvironment(fn.Env)
+	env := object.NewFunctionEnvironment(fn.Env, fn.Slots)
 
 	for paramIdx, param := range fn.Parameters {
-		env.Set(param.Value, args[paramIdx])
+		env.SetSlot(0, param.Slot, args[paramIdx])
 	}
 	return env
 }
@@ -317,25 +613,18 @@ func unwrapReturnValue(obj object.Object) object.Object {
 	return obj
 }
 
-func evalStringInfixExpression(operator string, left, right object.Object) object.Object {
-	if operator != "+" {
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
-	}
-
-	leftVal := left.(*object.String).Value
-	rightVal := right.(*object.String).Value
-
-	return &object.String{Value: leftVal + rightVal}
-}
-
 
 func evalIndexExpression(left, index object.Object) object.Object {
 
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		return object.StringIndex(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
+	case left.Type() == object.ERROR_VALUE_OBJ:
+		return left.(*object.ErrorValue).Field(index)
 	default:
 		return newError("index operator not supported: %s", left.Type())
 	}
@@ -364,7 +653,7 @@ func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Obje
 
 		hashKey, ok := key.(object.Hashable)
 		if !ok {
-			return newError("unusable as hash key: %s",key.Type())
+			return errorAt(keyNode.Pos(), newError("unusable as hash key: %s",key.Type()))
 		}
 
 		value := Eval(valueNode, env)
@@ -394,3 +683,84 @@ func evalHashIndexExpression(hash, index object.Object) object.Object {
 	return pair.Value
 }
 
+func evalWhileStatement(ws *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		condition := Eval(ws.Condition, env)
+		if isError(condition) {
+			return condition
+		}
+		if !isTruthy(condition) {
+			return NULL
+		}
+
+		if result, done := evalLoopBody(ws.Body, env); done {
+			return result
+		}
+	}
+}
+
+func evalForInStatement(fs *ast.ForInStatement, env *object.Environment) object.Object {
+	iterable := Eval(fs.Iterable, env)
+	if isError(iterable) {
+		return iterable
+	}
+
+	// each iteration binds the names in env and runs the body, stopping the loop when done is set
+	iterate := func(key, value object.Object) (object.Object, bool) {
+		if fs.Key != nil {
+			bind(env, fs.Key, key)
+		}
+		bind(env, fs.Value, value)
+		return evalLoopBody(fs.Body, env)
+	}
+
+	switch iterable := iterable.(type) {
+	case *object.Array:
+		for i, el := range iterable.Elements {
+			if result, done := iterate(&object.Integer{Value: int64(i)}, el); done {
+				return result
+			}
+		}
+	case *object.String:
+		for i, r := range []rune(iterable.Value) {
+			char := track(env, &object.String{Value: string(r)})
+			if isError(char) {
+				return char
+			}
+			if result, done := iterate(&object.Integer{Value: int64(i)}, char); done {
+				return result
+			}
+		}
+	case *object.Hash:
+		for _, pair := range iterable.OrderedPairs() {
+			value := pair.Value
+			if fs.Key == nil {
+				value = pair.Key
+			}
+			if result, done := iterate(pair.Key, value); done {
+				return result
+			}
+		}
+	default:
+		return errorAt(fs.Iterable.Pos(), newError("cannot iterate over %s", iterable.Type()))
+	}
+	return NULL
+}
+
+//evalLoopBody runs one iteration of a loop. It reports whether the loop has to
+//stop and, if so, the value the loop statement evaluates to: NULL after a
+//break, or the return value or error that is unwinding through the loop.
+func evalLoopBody(body *ast.BlockStatement, env *object.Environment) (object.Object, bool) {
+	result := Eval(body, env)
+	if result == nil {
+		return nil, false
+	}
+
+	switch result.Type() {
+	case object.BREAK_OBJ:
+		return NULL, true
+	case object.RETURN_VALUE_OBJ, object.ERROR_OBJ:
+		return result, true
+	}
+	return nil, false
+}
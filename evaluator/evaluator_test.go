@@ -1,10 +1,16 @@
 package evaluator
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"interpreter/lexer"
 	"interpreter/object"
 	"interpreter/parser"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func testEval(input string) object.Object {
@@ -194,7 +200,9 @@ func TestErrorHandling(t *testing.T) {
 				`,
 			"unknown operator: BOOLEAN + BOOLEAN",
 		},
-		{"foobar", "identifier not found:foobar"},
+		{"foobar", "undefined variable foobar"},
+		{"foobar; let foobar = 1", "identifier not found:foobar"},
+		{"let f = fn(c) { if (c) { let y = 1 }; y }; f(false)", "identifier not found:y"},
 		{
 			`{"name": "Monkey"}[fn(x) { x }];`,
 			"unusable as hash key: FUNCTION",
@@ -230,6 +238,14 @@ func TestLetStatement(t *testing.T) {
 	}
 }
 
+func TestEvalUnresolvedNode(t *testing.T) {
+	program := parser.New(lexer.New("x + 1")).ParseProgram()
+	env := object.NewEnvironment()
+	env.Set("x", &object.Integer{Value: 1})
+
+	testIntegerObj(t, Eval(program.Statements[0], env), 2)
+}
+
 func TestFunctionObject(t *testing.T) {
 	input := "fn(x) { x + 2; };"
 
@@ -299,6 +315,57 @@ func TestStringLiteral(t *testing.T) {
 	}
 }
 
+func TestStringEscapes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"line\nbreak"`, "line\nbreak"},
+		{`"tab\tquote\"\u{263A}"`, "tab\tquote\"\u263a"},
+		{"`raw\n\\n`", "raw\n\\n"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		str, ok := evaluated.(*object.String)
+		if !ok {
+			t.Fatalf("object is not String. got=%T(%+v)", evaluated, evaluated)
+		}
+		if str.Value != tt.expected {
+			t.Errorf("input %s: wrong value. expected=%q, got=%q", tt.input, tt.expected, str.Value)
+		}
+	}
+}
+
+func TestInterpolatedStrings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let n = 41; "count: ${n + 1}"`, "count: 42"},
+		{`"${1.5} ${true} ${[1, "a"]} ${if (false) { 1 }}"`, "1.5 true [1, a] null"},
+		{`let name = "x"; "<${"${name}!"}>"`, "<x!>"},
+		{`"${len("abc")}${"d"}"`, "3d"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		str, ok := evaluated.(*object.String)
+		if !ok {
+			t.Fatalf("object is not String. got=%T(%+v)", evaluated, evaluated)
+		}
+		if str.Value != tt.expected {
+			t.Errorf("input %s: wrong value. expected=%q, got=%q", tt.input, tt.expected, str.Value)
+		}
+	}
+
+	evaluated := testEval(`"a ${1 + true} b"`)
+	errObj, ok := evaluated.(*object.Error)
+	if !ok || errObj.Message != "type mismatch: INTEGER + BOOLEAN" {
+		t.Errorf("expected the error of the embedded expression. got=%T(%+v)", evaluated, evaluated)
+	}
+}
+
 func TestStringConcatenation(t *testing.T) {
 	input := `"Hello" + " " + "World!"`
 
@@ -344,6 +411,40 @@ func TestBuiltinFunctions(t *testing.T) {
 	}
 }
 
+func TestUnicodeStrings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`len("héllo")`, 5},
+		{`len("日本語")`, 3},
+		{`"héllo"[1]`, "é"},
+		{`"日本語"[2]`, "語"},
+		{`"日本語"[3]`, nil},
+		{`"abc"[-1]`, nil},
+		{`let s = ""; for (i, c in "añb") { let s = s + c + "${i}"; }; s`, "a0ñ1b2"},
+		{`let größe = 3; größe * 2`, 6},
+		{`bytes("é")`, "[195, 169]"},
+		{`runes("é!")`, "[233, 33]"},
+		{`len(bytes("日本語"))`, 9},
+		{`bytes(1)`, "ERROR 1:1: argument to `bytes` must be STRING, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObj(t, evaluated, int64(expected))
+		case string:
+			if evaluated.Inspect() != expected {
+				t.Errorf("input %q: expected %q, got=%s", tt.input, expected, evaluated.Inspect())
+			}
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
 func TestArrayLiterals(t *testing.T) {
 	input := "[1, 2 * 2, 3 + 3]"
 
@@ -490,3 +591,792 @@ func TestHashIndexExpressions(t *testing.T) {
 
 	}
 }
+
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input        string
+		expectedLine int
+		expectedCol  int
+	}{
+		{"5 + true", 1, 3},
+		{"let a = 1;\n  -true", 2, 3},
+		{"let f = fn(x) {\n  x + foobar\n};\nf(1)", 2, 7},
+		{"len(1)", 1, 1},
+		{"[1, 2][fn(x){x}]", 1, 7},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got= %T(%+v)", evaluated, evaluated)
+			continue
+		}
+		if errObj.Pos.Line != tt.expectedLine || errObj.Pos.Column != tt.expectedCol {
+			t.Errorf("wrong error position for %q. expected=%d:%d, got=%s", tt.input, tt.expectedLine, tt.expectedCol, errObj.Pos)
+		}
+	}
+}
+
+func TestInterpreterCallTraceback(t *testing.T) {
+	in := NewInterpreter()
+	if _, err := in.Eval("let half = fn(n) { n / 0 };"); err != nil {
+		t.Fatalf("Eval failed: %s", err)
+	}
+
+	_, err := in.Call("half", 4)
+	errObj, ok := err.(*object.Error)
+	if !ok {
+		t.Fatalf("expected a runtime error. got=%v", err)
+	}
+	expected := []object.Frame{{Function: "half", Args: []object.ObjectType{object.INTEGER_OBJ}}}
+	if !reflect.DeepEqual(errObj.Frames, expected) {
+		t.Errorf("wrong frames. expected=%+v, got=%+v", expected, errObj.Frames)
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let i = 0; while (i < 10) { let i = i + 1; }; i", 10},
+		{"while (false) { 1 }", nil},
+		{"let s = 0; for (x in [1, 2, 3]) { let s = s + x; }; s", 6},
+		{"let s = 0; for (i, x in [5, 6, 7]) { let s = s + i; }; s", 3},
+		{`let s = ""; for (c in "abc") { let s = c + s; }; s`, "cba"},
+		{`let s = ""; for (k in {"b": 2, "a": 1}) { let s = s + k; }; s`, "ab"},
+		{`let s = 0; for (k, v in {"b": 2, "a": 1}) { let s = s * 10 + v; }; s`, 12},
+		{"let i = 0; while (true) { let i = i + 1; if (i == 5) { break; } }; i", 5},
+		{"let s = 0; for (x in [1, 2, 3, 4]) { if (x == 2) { continue; } let s = s + x; }; s", 8},
+		{"let f = fn() { for (x in [1, 2, 3]) { if (x == 2) { return x * 10; } } }; f()", 20},
+		{"let s = 0; for (x in [1, 2]) { for (y in [10, 20]) { if (y == 20) { break; } let s = s + x * y; } }; s", 30},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObj(t, evaluated, int64(expected))
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok || str.Value != expected {
+				t.Errorf("input %q: expected %q, got=%s", tt.input, expected, evaluated.Inspect())
+			}
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestLimitsCannotBeCaught(t *testing.T) {
+	input := `let out = ""; try { while (true) { } } catch (e) { out += "caught" } finally { out += "finally" }; out`
+	program := parser.New(lexer.New(input)).ParseProgram()
+	env := object.NewEnvironment()
+
+	evaluated := EvalContext(context.Background(), program, env, object.Limits{MaxSteps: 1000})
+	errObj, ok := evaluated.(*object.Error)
+	if !ok || errObj.Kind != object.StepLimitExceeded {
+		t.Fatalf("expected a step limit error. got=%T(%+v)", evaluated, evaluated)
+	}
+	if out, _ := env.Get("out"); out.Inspect() != "" {
+		t.Errorf("the program went on after the limit was hit: out=%q", out.Inspect())
+	}
+}
+
+func TestIterateOverUnsupported(t *testing.T) {
+	evaluated := testEval("for (x in 5) { x }")
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got= %T(%+v)", evaluated, evaluated)
+	}
+	if errObj.Message != "cannot iterate over INTEGER" {
+		t.Errorf("wrong message. got= %q", errObj.Message)
+	}
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let x = 1; x = 2; x", 2},
+		{"let x = 1; x = 5", 5},
+		{"let x = 1; let y = 1; x = y = 3; x + y", 6},
+		{"let x = 10; x += 5; x -= 3; x *= 2; x /= 4; x", 6},
+		{"let i = 0; let s = 0; while (i < 5) { s += i; i += 1; }; s", 10},
+		{"let x = 1; let f = fn() { x = 7 }; f(); x", 7},
+		{"let f = fn() { let x = 1; let g = fn() { x += 1 }; g(); g(); x }; f()", 3},
+		{
+			`let counter = fn() { let n = 0; fn() { n += 1 } };
+			let c = counter(); c(); c(); let d = counter(); d(); c()`,
+			3,
+		},
+		{"let f = fn() { let x = 1; fn() { fn() { x *= 10 } } }; let inc = f()(); inc(); inc()", 100},
+		{"let f = fn(x) { let g = fn() { x }; x = 4; g() }; f(1)", 4},
+	}
+
+	for _, tt := range tests {
+		testIntegerObj(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestAssignErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"y = 1", "undefined variable y"},
+		{"len = 1", "cannot assign to builtin len"},
+		{`let x = 1; x += "a"`, "type mismatch: INTEGER + STRING"},
+		{"let f = fn() { let z = 1 }; f(); z = 2", "undefined variable z"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got= %T(%+v)", evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("wrong message. expected= %q,got= %q", tt.expectedMessage, errObj.Message)
+		}
+	}
+}
+
+func TestIndexAssignment(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let a = [1, 2, 3]; a[1] = 5; a", "[1, 5, 3]"},
+		{"let a = [1, 2, 3]; a[0] += 10", "11"},
+		{"let a = [1, 2, 3]; let b = a; b[2] *= 3; a", "[1, 2, 9]"},
+		{`let h = {}; h["a"] = 1; h["b"] = 2; h["a"] += 5; h`, "{a: 6, b: 2}"},
+		{"let m = [[1, 2], [3, 4]]; m[1][0] = 0; m", "[[1, 2], [0, 4]]"},
+		{"let h = {}; let i = 0; while (i < 3) { h[i] = i * i; i += 1 }; h", "{0: 0, 1: 1, 2: 4}"},
+		{"let f = fn(a) { a[0] = 99 }; let xs = [1]; f(xs); xs", "[99]"},
+		{"let a = [1]; a[1] = 2", "ERROR: index out of range: 1 with length 1"},
+		{"let a = [1]; a[-1] = 2", "ERROR: index out of range: -1 with length 1"},
+		{`let a = [1]; a["x"] = 2`, "ERROR: array index must be INTEGER, got STRING"},
+		{`let s = "abc"; s[0] = "x"`, "ERROR: index assignment not supported: STRING"},
+		{"let h = {}; h[fn() {}] = 1", "ERROR: unusable as hash key: FUNCTION"},
+		{`let h = {}; h["a"] += 1`, "ERROR: type mismatch: NULL + INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		actual := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			actual = "ERROR: " + errObj.Message
+		}
+		if actual != tt.expected {
+			t.Errorf("input %q: expected=%q, got=%q", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestHashBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let h = {}; set(h, "a", 1); h`, "{a: 1}"},
+		{`set({"a": 1}, "a", 2)`, "{a: 2}"},
+		{`let h = {"a": 1, "b": 2}; delete(h, "a"); h`, "{b: 2}"},
+		{`delete({"a": 1}, "z")`, "{a: 1}"},
+		{`keys({"b": 1, "a": 2})`, "[a, b]"},
+		{`values({"b": 1, "a": 2})`, `[2, 1]`},
+		{`has({"a": 1}, "a")`, "true"},
+		{`has({"a": 1}, "b")`, "false"},
+		{`keys({})`, "[]"},
+		{`set([], 0, 1)`, "ERROR: argument to `set` must be HASH, got ARRAY"},
+		{`has({}, [])`, "ERROR: unusable as hash key: ARRAY"},
+		{`delete({})`, "ERROR: wrong number of arguments. got=1, want=2"},
+		{`values(1)`, "ERROR: argument to `values` must be HASH, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		actual := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			actual = "ERROR: " + errObj.Message
+		}
+		if actual != tt.expected {
+			t.Errorf("input %q: expected=%q, got=%q", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestFloatExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"3.5", "3.5"},
+		{"-2.5", "-2.5"},
+		{"1.5 + 1.5", "3.0"},
+		{"1 + 0.5", "1.5"},
+		{"0.5 * 4", "2.0"},
+		{"7 / 2.0", "3.5"},
+		{"7 / 2", "3"},
+		{"10 - 0.25", "9.75"},
+		{"1.0 / 0", "+Inf"},
+		{"(1 + 2 + 3) / 3.0", "2.0"},
+		{"1 == 1.0", "true"},
+		{"0.1 + 0.2 == 0.3", "false"},
+		{"2.5 > 2", "true"},
+		{"1 < 0.5", "false"},
+		{"1.5 != 1.5", "false"},
+		{`let h = {1: "one"}; h[1.0]`, "one"},
+		{"1.5 + true", "ERROR: type mismatch: FLOAT + BOOLEAN"},
+		{`"a" + 1.5`, "ERROR: type mismatch: STRING + FLOAT"},
+		{"let x = 1; x += 0.5; x", "1.5"},
+		{"int(3.9)", "3"},
+		{"int(-3.9)", "-3"},
+		{`int(" 42 ")`, "42"},
+		{`int("4.2")`, `ERROR: could not parse "4.2" as INTEGER`},
+		{"int(1.0 / 0)", "ERROR: cannot convert +Inf to INTEGER"},
+		{"float(2)", "2.0"},
+		{`float("1e3")`, "1000.0"},
+		{"float(true)", "ERROR: argument to `float` is not supported, got BOOLEAN"},
+		{"round(2.5)", "3"},
+		{"round(-2.5)", "-3"},
+		{"round(7)", "7"},
+		{"round(3.14159, 2)", "3.14"},
+		{"round(2.675, 2)", "2.67"},
+		{"round(1, 2)", "1.0"},
+		{"round(1.5, -1)", "ERROR: number of digits to `round` must be a non-negative INTEGER, got -1"},
+		{`round("1")`, "ERROR: argument to `round` must be INTEGER or FLOAT, got STRING"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		actual := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			actual = "ERROR: " + errObj.Message
+		}
+		if actual != tt.expected {
+			t.Errorf("input %q: expected=%q, got=%q", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestBigIntegers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"-9223372036854775807 - 2", "-9223372036854775809"},
+		{"9223372036854775807 * 2", "18446744073709551614"},
+		{"-(-9223372036854775807 - 1)", "9223372036854775808"},
+		{"(-9223372036854775807 - 1) / -1", "9223372036854775808"},
+		{"99999999999999999999", "99999999999999999999"},
+		{"99999999999999999999 - 99999999999999999998", "1"},
+		{"let f = fn(n) { if (n < 2) { 1 } else { n * f(n - 1) } }; f(25)", "15511210043330985984000000"},
+		{"let f = fn(n) { if (n < 2) { 1 } else { n * f(n - 1) } }; f(25) / f(24)", "25"},
+		{"99999999999999999999 > 1", "true"},
+		{"99999999999999999999 == 99999999999999999999", "true"},
+		{"99999999999999999999 + 0.5", "1e+20"},
+		{`99999999999999999999 + "a"`, "ERROR: type mismatch: BIGINT + STRING"},
+		{"int(1e20)", "100000000000000000000"},
+		{`int("123456789012345678901234567890")`, "123456789012345678901234567890"},
+		{"float(99999999999999999999)", "1e+20"},
+		{`let h = {}; h[9223372036854775808 - 1] = "max"; h[9223372036854775807]`, "max"},
+		{`let h = {99999999999999999999: "big"}; h[99999999999999999998 + 1]`, "big"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		actual := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			actual = "ERROR: " + errObj.Message
+		}
+		if actual != tt.expected {
+			t.Errorf("input %q: expected=%q, got=%q", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestDivisionAndModulo(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"7 % 3", "1"},
+		{"-7 % 3", "-1"},
+		{"7 % -3", "1"},
+		{"-7 / 2", "-3"},
+		{"2 + 7 % 4 * 2", "8"},
+		{"7.5 % 2", "1.5"},
+		{"99999999999999999999 % 7", "1"},
+		{"1 / 0", "ERROR: division by zero"},
+		{"1 % 0", "ERROR: modulo by zero"},
+		{"99999999999999999999 / 0", "ERROR: division by zero"},
+		{"1.5 % 0", "ERROR: modulo by zero"},
+		{"1 / 0.0", "+Inf"},
+		{"let f = fn(a, b) { a / b }; f(1, 0); 5", "ERROR: division by zero"},
+		{"true % 2", "ERROR: type mismatch: BOOLEAN % INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		actual := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			actual = "ERROR: " + errObj.Message
+		}
+		if actual != tt.expected {
+			t.Errorf("input %q: expected=%q, got=%q", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestPanicsBecomeErrors(t *testing.T) {
+	builtins := object.StandardBuiltins()
+	builtins["explode"] = &object.Builtin{Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+		panic("boom")
+	}}
+	env := object.NewEnvironmentWithBuiltins(builtins)
+
+	evaluated := Eval(parser.New(lexer.New("let x = 1; explode(); x")).ParseProgram(), env)
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got= %T(%+v)", evaluated, evaluated)
+	}
+	if errObj.Message != "internal error: boom" {
+		t.Errorf("wrong message. got= %q", errObj.Message)
+	}
+}
+
+func TestLogicalExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"true && true", "true"},
+		{"true && false", "false"},
+		{"false || true", "true"},
+		{"false || false", "false"},
+		{"1 && 2", "true"},
+		{"let f = fn(x) { x > 0 && x < 10 }; f(5) && !f(10)", "true"},
+		{"if (false) { 1 } || 0", "true"},
+		{"if (false) { 1 } && 1", "false"},
+		{"false && 1 / 0", "false"},
+		{"true || 1 / 0", "true"},
+		{"true && undefined", "ERROR: undefined variable undefined"},
+		{"let n = 0; let f = fn() { n += 1; true }; false && f(); true || f(); n", "0"},
+		{"let n = 0; let f = fn() { n += 1; true }; true && f(); false || f(); n", "2"},
+		{"1 < 2 && 2 < 3 || false", "true"},
+		{"1 <= 1", "true"},
+		{"2 <= 1", "false"},
+		{"1 >= 1", "true"},
+		{"1 >= 2", "false"},
+		{"1.5 <= 2", "true"},
+		{"99999999999999999999 >= 1", "true"},
+		{`"a" <= "b"`, "true"},
+		{`"b" >= "c"`, "false"},
+		{`"a" >= "a"`, "true"},
+		{`"a" <= 1`, "ERROR: type mismatch: STRING <= INTEGER"},
+		{"true >= false", "ERROR: unknown operator: BOOLEAN >= BOOLEAN"},
+		{`"a" == "a"`, "true"},
+		{`"a" != "a"`, "false"},
+		{`"a" == "b"`, "false"},
+		{"[1, 2] == [1, 2]", "true"},
+		{"[1, 2] != [1, 3]", "true"},
+		{"[1, 2] == [1]", "false"},
+		{"[1, [2, 3]] == [1.0, [2, 3]]", "true"},
+		{"{1: 2} == {1: 2}", "true"},
+		{"{1: 2} == {1: 3}", "false"},
+		{"{1: 2} == {2: 2}", "false"},
+		{`{"a": [1, {"b": 2}]} == {"a": [1, {"b": 2}]}`, "true"},
+		{"[if (false) { 1 }] == [if (false) { 2 }]", "true"},
+		{`[1] == {1: 1}`, "false"},
+		{`"1" == 1`, "false"},
+		{"let f = fn() { 1 }; f == f", "true"},
+		{"fn() { 1 } == fn() { 1 }", "false"},
+		{"let a = [1]; a[0] = a; let b = [1]; b[0] = b; a == b", "true"},
+		{"let a = [1, 2]; a[0] = a; let b = [1, 3]; b[0] = b; a == b", "false"},
+		{"let h = {}; h[0] = h; let g = {}; g[0] = g; h == g", "true"},
+		{`"a" < "b"`, "true"},
+		{`"b" < "a"`, "false"},
+		{`"b" > "a"`, "true"},
+		{`"a" > "a"`, "false"},
+		{`"Z" < "a"`, "true"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		actual := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			actual = "ERROR: " + errObj.Message
+		}
+		if actual != tt.expected {
+			t.Errorf("input %q: expected=%q, got=%q", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestInterpreter(t *testing.T) {
+	in := NewInterpreter()
+	in.Register("double", func(ctx *object.Context, args ...object.Object) object.Object {
+		return &object.Integer{Value: args[0].(*object.Integer).Value * 2}
+	})
+	if err := in.SetGlobal("limit", 10); err != nil {
+		t.Fatalf("SetGlobal failed: %s", err)
+	}
+
+	if _, err := in.Eval("let add = fn(a, b) { a + b };"); err != nil {
+		t.Fatalf("Eval failed: %s", err)
+	}
+	result, err := in.Eval("double(limit) + add(1, 2)")
+	if err != nil {
+		t.Fatalf("Eval failed: %s", err)
+	}
+	testIntegerObj(t, result, 23)
+
+	result, err = in.Call("add", 40, 2)
+	if err != nil {
+		t.Fatalf("Call failed: %s", err)
+	}
+	testIntegerObj(t, result, 42)
+
+	if _, err := in.Call("add", 1); err == nil || err.Error() != "wrong number of arguments: want=2, got=1" {
+		t.Errorf("expected an arity error. got=%v", err)
+	}
+	if _, err := in.Call("missing"); err == nil || err.Error() != "undefined variable missing" {
+		t.Errorf("expected an undefined variable error. got=%v", err)
+	}
+	if _, err := in.Eval("let x = ;"); err == nil {
+		t.Errorf("expected a parse error")
+	} else if _, ok := err.(*ParseError); !ok {
+		t.Errorf("expected a *ParseError. got=%T", err)
+	}
+	if _, err := in.Eval("undefined + 1"); err == nil || err.Error() != "1:1: error[R001]: undefined variable undefined" {
+		t.Errorf("expected an undefined variable diagnostic. got=%v", err)
+	}
+	if _, err := in.Eval("limit + true"); err == nil || err.Error() != "1:7: type mismatch: INTEGER + BOOLEAN" {
+		t.Errorf("expected a runtime error. got=%v", err)
+	}
+
+	other := NewInterpreter()
+	if _, err := other.Eval("double(1)"); err == nil {
+		t.Errorf("builtin registered on one interpreter is visible to another")
+	}
+	if _, ok := testEval("double(1)").(*object.Error); !ok {
+		t.Errorf("builtin registered on an interpreter is visible to Eval")
+	}
+}
+
+func TestToObject(t *testing.T) {
+	tests := []struct {
+		input    interface{}
+		expected string
+	}{
+		{42, "42"},
+		{uint8(7), "7"},
+		{uint64(1 << 63), "9223372036854775808"},
+		{2.5, "2.5"},
+		{true, "true"},
+		{"hi", "hi"},
+		{nil, "null"},
+		{[]int{1, 2}, "[1, 2]"},
+		{[]interface{}{1, "a", nil}, "[1, a, null]"},
+		{map[string]int{"a": 1}, "{a: 1}"},
+		{&object.Integer{Value: 3}, "3"},
+	}
+
+	for _, tt := range tests {
+		obj, err := ToObject(tt.input)
+		if err != nil {
+			t.Errorf("input %#v: unexpected error %s", tt.input, err)
+			continue
+		}
+		if obj.Inspect() != tt.expected {
+			t.Errorf("input %#v: expected=%q, got=%q", tt.input, tt.expected, obj.Inspect())
+		}
+	}
+
+	if _, err := ToObject(struct{}{}); err == nil {
+		t.Errorf("expected an error converting a struct")
+	}
+	if _, err := ToObject(map[[1]int]int{{1}: 1}); err == nil {
+		t.Errorf("expected an error converting a map with unhashable keys")
+	}
+}
+
+func TestFromObject(t *testing.T) {
+	in := NewInterpreter()
+	result, err := in.Eval(`{"xs": [1, 2, 3], "name": "hk", "ratio": 0.5}`)
+	if err != nil {
+		t.Fatalf("Eval failed: %s", err)
+	}
+
+	var natural interface{}
+	if err := FromObject(result, &natural); err != nil {
+		t.Fatalf("FromObject failed: %s", err)
+	}
+	hash := natural.(map[interface{}]interface{})
+	if hash["name"] != "hk" || hash["ratio"] != 0.5 || len(hash["xs"].([]interface{})) != 3 {
+		t.Errorf("wrong natural conversion. got=%#v", natural)
+	}
+
+	var xs []int
+	arr, _ := in.Eval(`[1, 2, 3]`)
+	if err := FromObject(arr, &xs); err != nil || len(xs) != 3 || xs[2] != 3 {
+		t.Errorf("wrong slice conversion. got=%v (%v)", xs, err)
+	}
+
+	var small int8
+	big, _ := in.Eval(`1000`)
+	if err := FromObject(big, &small); err == nil || err.Error() != "1000 overflows int8" {
+		t.Errorf("expected an overflow error. got=%v", err)
+	}
+
+	var s string
+	if err := FromObject(big, &s); err == nil || err.Error() != "cannot convert INTEGER to string" {
+		t.Errorf("expected a conversion error. got=%v", err)
+	}
+}
+
+func TestConvertFunctions(t *testing.T) {
+	in := NewInterpreter()
+
+	in.SetGlobal("repeat", strings.Repeat)
+	in.SetGlobal("sum", func(xs ...int) int {
+		total := 0
+		for _, x := range xs {
+			total += x
+		}
+		return total
+	})
+	in.SetGlobal("check", func(n int) (int, error) {
+		if n < 0 {
+			return 0, fmt.Errorf("negative: %d", n)
+		}
+		return n, nil
+	})
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`repeat("ab", 3)`, "ababab"},
+		{`sum()`, "0"},
+		{`sum(1, 2, 3)`, "6"},
+		{`check(5)`, "5"},
+		{`check(-1)`, "ERROR 1:1: negative: -1"},
+		{`repeat("ab")`, "ERROR 1:1: wrong number of arguments. got=1, want=2"},
+		{`repeat(1, 2)`, "ERROR 1:1: argument 1: cannot convert INTEGER to string"},
+	}
+
+	for _, tt := range tests {
+		result, err := in.Eval(tt.input)
+		actual := ""
+		if err != nil {
+			actual = err.(*object.Error).Inspect()
+		} else {
+			actual = result.Inspect()
+		}
+		if actual != tt.expected {
+			t.Errorf("input %s: expected=%q, got=%q", tt.input, tt.expected, actual)
+		}
+	}
+
+	fnObj, _ := in.Eval(`fn(a, b) { if (b == 0) { return 1 / 0 } a * b }`)
+	var mul func(int, int) (int, error)
+	if err := FromObject(fnObj, &mul); err != nil {
+		t.Fatalf("FromObject failed: %s", err)
+	}
+	if product, err := mul(6, 7); err != nil || product != 42 {
+		t.Errorf("wrong result. got=%d (%v)", product, err)
+	}
+	if _, err := mul(1, 0); err == nil || !strings.Contains(err.Error(), "division by zero") {
+		t.Errorf("expected the runtime error. got=%v", err)
+	}
+
+	// a body ending in a let has no value, which converts like null
+	noValue, _ := in.Eval(`fn() { let x = 1 }`)
+	var value func() (interface{}, error)
+	if err := FromObject(noValue, &value); err != nil {
+		t.Fatalf("FromObject failed: %s", err)
+	}
+	if v, err := value(); v != nil || err != nil {
+		t.Errorf("expected nil, nil. got=%v, %v", v, err)
+	}
+	var number func() (int, error)
+	FromObject(noValue, &number)
+	if _, err := number(); err == nil || !strings.Contains(err.Error(), "cannot convert NULL") {
+		t.Errorf("expected a conversion error. got=%v", err)
+	}
+
+	in.Register("explode", func(ctx *object.Context, args ...object.Object) object.Object {
+		panic("boom")
+	})
+	exploding, _ := in.Eval(`fn() { explode() }`)
+	var run func() error
+	FromObject(exploding, &run)
+	if err := run(); err == nil || !strings.Contains(err.Error(), "internal error: boom") {
+		t.Errorf("expected the panic as an error. got=%v", err)
+	}
+}
+
+func TestInterpreterWithoutValue(t *testing.T) {
+	in := NewInterpreter()
+	result, err := in.Eval("let setup = fn() { let x = 1 };")
+	if err != nil || result != NULL {
+		t.Fatalf("expected null. got=%v (%v)", result, err)
+	}
+
+	result, err = in.Call("setup")
+	if err != nil || result != NULL {
+		t.Errorf("expected null. got=%v (%v)", result, err)
+	}
+}
+
+func TestIOBuiltins(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	in := NewInterpreter()
+	in.SetContext(object.NewContext(strings.NewReader("alice\r\nbob\nrest\n"), &stdout, &stderr))
+
+	result, err := in.Eval(`let name = readline(); print("hi ", name, "!"); puts(1, 2); eprint("warn"); [readline(), read_all(), readline()]`)
+	if err != nil {
+		t.Fatalf("Eval failed: %s", err)
+	}
+
+	if stdout.String() != "hi alice!1\n2\n" {
+		t.Errorf("wrong stdout. got=%q", stdout.String())
+	}
+	if stderr.String() != "warn" {
+		t.Errorf("wrong stderr. got=%q", stderr.String())
+	}
+	if result.Inspect() != "[bob, rest\n, null]" {
+		t.Errorf("wrong result. got=%q", result.Inspect())
+	}
+
+	if _, err := in.Eval("readline(1)"); err == nil || err.(*object.Error).Message != "wrong number of arguments. got=1, want=0" {
+		t.Errorf("expected an arity error. got=%v", err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancelExpired := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancelExpired()
+	time.Sleep(time.Millisecond)
+
+	tests := []struct {
+		ctx          context.Context
+		limits       object.Limits
+		input        string
+		expectedKind object.ErrorKind
+		expected     string
+	}{
+		{cancelled, object.Limits{}, "while (true) { }", object.Cancelled, "execution cancelled"},
+		{expired, object.Limits{}, "while (true) { }", object.Timeout, "execution timed out"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := EvalContext(tt.ctx, program, object.NewEnvironment(), tt.limits)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("input %q: expected an error. got=%T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Kind != tt.expectedKind || errObj.Message != tt.expected {
+			t.Errorf("input %q: wrong error. expected=%s %q, got=%s %q", tt.input, tt.expectedKind, tt.expected, errObj.Kind, errObj.Message)
+		}
+		if errObj.IsLimit() != (tt.expectedKind != object.RuntimeError) {
+			t.Errorf("input %q: IsLimit is wrong for %s", tt.input, errObj.Kind)
+		}
+	}
+}
+
+func TestLimitsAllowWorkWithinThem(t *testing.T) {
+	program := parser.New(lexer.New("let f = fn(n) { if (n > 0) { f(n - 1) } else { 42 } }; f(9)")).ParseProgram()
+	env := object.NewEnvironment()
+
+	evaluated := EvalContext(context.Background(), program, env, object.Limits{MaxCallDepth: 10, MaxSteps: 10000})
+	testIntegerObj(t, evaluated, 42)
+
+	// the budget only applies to the one evaluation
+	evaluated = Eval(parser.New(lexer.New("let i = 0; while (i < 5000) { i += 1 }; i")).ParseProgram(), env)
+	testIntegerObj(t, evaluated, 5000)
+
+	// and without limits recursion can still go well past a thousand calls
+	evaluated = Eval(parser.New(lexer.New("let g = fn(n) { if (n == 0) { 0 } else { 1 + g(n - 1) } }; g(5000)")).ParseProgram(), env)
+	testIntegerObj(t, evaluated, 5000)
+}
+
+func TestAllocatedBytes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"1 + 2", 0},
+		{`"ab"`, 32 + 2},
+		{`"ab" + "c"`, (32 + 2) + (32 + 1) + (32 + 3)},
+		{"[1, 2, 3]", 32 + 3*16},
+		{"{1: 2, 3: 4}", 56 + 2*64},
+		{"let h = {}; h[1] = 2; h[1] = 3", 56 + 64},
+		{"push([1], 2)", (32 + 16) + (32 + 2*16)},
+		{`"abc"[1]`, (32 + 3) + (32 + 1)},
+	}
+
+	for _, tt := range tests {
+		in := NewInterpreter()
+		if _, err := in.Eval(tt.input); err != nil {
+			t.Fatalf("input %q: Eval failed: %s", tt.input, err)
+		}
+		if allocated := in.AllocatedBytes(); allocated != tt.expected {
+			t.Errorf("input %q: wrong allocated bytes. expected=%d, got=%d", tt.input, tt.expected, allocated)
+		}
+	}
+}
+
+func TestAllocatedBytesSizesLimits(t *testing.T) {
+	input := `let words = []; for (i in [1, 2, 3]) { words = push(words, "word ${i}") }; words`
+
+	in := NewInterpreter()
+	if _, err := in.Eval(input); err != nil {
+		t.Fatalf("Eval failed: %s", err)
+	}
+	allocated := in.AllocatedBytes()
+
+	// each run is accounted for on its own, so what one run allocated is enough for the next
+	in.SetLimits(object.Limits{MaxMemory: allocated})
+	if _, err := in.Eval(input); err != nil {
+		t.Errorf("expected the program to fit in %d bytes. got=%s", allocated, err)
+	}
+
+	in.SetLimits(object.Limits{MaxMemory: allocated - 1})
+	_, err := in.Eval(input)
+	if errObj, ok := err.(*object.Error); !ok || errObj.Kind != object.MemoryLimitExceeded {
+		t.Errorf("expected a memory limit error. got=%v", err)
+	}
+}
+
+func TestInterpreterLimits(t *testing.T) {
+	in := NewInterpreter()
+	in.SetLimits(object.Limits{MaxSteps: 500})
+
+	if _, err := in.Eval("let spin = fn() { while (true) { } };"); err != nil {
+		t.Fatalf("Eval failed: %s", err)
+	}
+	_, err := in.Call("spin")
+	if errObj, ok := err.(*object.Error); !ok || errObj.Kind != object.StepLimitExceeded {
+		t.Errorf("expected a step limit error from Call. got=%v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	in.SetLimits(object.Limits{})
+	_, err = in.EvalContext(ctx, "spin()")
+	if errObj, ok := err.(*object.Error); !ok || errObj.Kind != object.Cancelled {
+		t.Errorf("expected a cancellation error from EvalContext. got=%v", err)
+	}
+}
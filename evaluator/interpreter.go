@@ -0,0 +1,167 @@
+package evaluator
+
+import (
+	"context"
+	"fmt"
+	"interpreter/diagnostic"
+	"interpreter/lexer"
+	"interpreter/object"
+	"interpreter/parser"
+	"interpreter/resolver"
+	"strings"
+)
+
+//Interpreter runs programs on behalf of a Go program embedding the language.
+//It owns its builtins and its global environment, so functions and values
+//given to one interpreter are invisible to every other, and globals defined by
+//one Eval stay visible to the next.
+type Interpreter struct {
+	builtins map[string]*object.Builtin
+	env      *object.Environment
+	limits   object.Limits
+	budget   *object.Budget // of the last Eval or Call
+}
+
+//NewInterpreter returns an interpreter knowing the standard builtins only
+func NewInterpreter() *Interpreter {
+	builtins := object.StandardBuiltins()
+	return &Interpreter{
+		builtins: builtins,
+		env:      object.NewEnvironmentWithBuiltins(builtins),
+	}
+}
+
+//Register makes fn callable from programs under name, replacing a builtin of
+//the same name. A global binding of the name still takes precedence.
+func (in *Interpreter) Register(name string, fn object.BuiltinFunction) {
+	in.builtins[name] = &object.Builtin{Fn: fn}
+}
+
+//SetGlobal binds name in the global environment to value, which is converted
+//with ToObject unless it already is an object
+func (in *Interpreter) SetGlobal(name string, value interface{}) error {
+	obj, err := ToObject(value)
+	if err != nil {
+		return err
+	}
+	in.env.Set(name, obj)
+	return nil
+}
+
+//SetContext sets the streams programs run by the interpreter use for input
+//and output, which are the standard streams of the process by default
+func (in *Interpreter) SetContext(ctx *object.Context) {
+	in.env.SetContext(ctx)
+}
+
+//SetLimits bounds the resources of every later Eval and Call, each of which
+//gets a budget of its own
+func (in *Interpreter) SetLimits(limits object.Limits) {
+	in.limits = limits
+}
+
+//AllocatedBytes returns the approximate bytes the last Eval or Call allocated
+//in total, which is what Limits.MaxMemory bounds. Values that became garbage
+//are included, as the interpreter cannot tell when they are freed, so this is
+//an upper bound on the peak memory of the run rather than the peak itself.
+func (in *Interpreter) AllocatedBytes() int64 {
+	if in.budget == nil {
+		return 0
+	}
+	return in.budget.AllocatedBytes()
+}
+
+//Global returns the value bound to name in the global environment
+func (in *Interpreter) Global(name string) (object.Object, bool) {
+	return in.env.Get(name)
+}
+
+//Eval runs src and returns the value it produced, NULL for a program without
+//one such as a program ending in let. A program that fails to parse or refers
+//to undefined variables gives a *ParseError and one that fails while running
+//an *object.Error, whose Kind tells whether it exceeded a limit.
+func (in *Interpreter) Eval(src string) (object.Object, error) {
+	return in.EvalContext(context.Background(), src)
+}
+
+//EvalContext is Eval stopping the program once ctx is done
+func (in *Interpreter) EvalContext(ctx context.Context, src string) (object.Object, error) {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if len(p.Diagnostics()) != 0 {
+		return nil, &ParseError{Diagnostics: p.Diagnostics()}
+	}
+	if diagnostics := resolver.Resolve(program, in.env); len(diagnostics) != 0 {
+		return nil, &ParseError{Diagnostics: diagnostics}
+	}
+
+	previous := in.startBudget(ctx)
+	result := Eval(program, in.env)
+	in.env.SetBudget(previous)
+
+	if err, ok := result.(*object.Error); ok {
+		return nil, err
+	}
+	if result == nil {
+		return NULL, nil
+	}
+	return result, nil
+}
+
+//Call calls the global function or builtin named fnName with args, which are
+//converted with ToObject
+func (in *Interpreter) Call(fnName string, args ...interface{}) (object.Object, error) {
+	return in.CallContext(context.Background(), fnName, args...)
+}
+
+//CallContext is Call stopping the function once ctx is done
+func (in *Interpreter) CallContext(ctx context.Context, fnName string, args ...interface{}) (result object.Object, err error) {
+	fn, ok := in.env.Get(fnName)
+	if !ok {
+		if fn, ok = in.env.Builtin(fnName); !ok {
+			return nil, fmt.Errorf("undefined variable %s", fnName)
+		}
+	}
+
+	objects := make([]object.Object, len(args))
+	for i, arg := range args {
+		if objects[i], err = ToObject(arg); err != nil {
+			return nil, fmt.Errorf("argument %d: %s", i+1, err)
+		}
+	}
+
+	previous := in.startBudget(ctx)
+	defer func() {
+		in.env.SetBudget(previous)
+		if r := recover(); r != nil {
+			result, err = nil, newError("internal error: %v", r)
+		}
+	}()
+
+	result = applyFunction(in.env.Context(), fn, objects, callSite{name: fnName})
+	if errObj, ok := result.(*object.Error); ok {
+		return nil, errObj
+	}
+	return result, nil
+}
+
+//startBudget gives the global environment a fresh budget for one Eval or Call
+//and returns the budget to restore afterwards
+func (in *Interpreter) startBudget(ctx context.Context) *object.Budget {
+	in.budget = object.NewBudget(ctx, in.limits)
+	return in.env.SetBudget(in.budget)
+}
+
+//ParseError is returned by Interpreter.Eval for a program with syntax errors
+//or undefined variables
+type ParseError struct {
+	Diagnostics []diagnostic.Diagnostic
+}
+
+func (e *ParseError) Error() string {
+	messages := make([]string, len(e.Diagnostics))
+	for i, d := range e.Diagnostics {
+		messages[i] = d.String()
+	}
+	return strings.Join(messages, "\n")
+}
@@ -2,37 +2,90 @@ package lexer
 
 import (
 	"interpreter/token"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 type Lexer struct {
 	input        string
+	filename     string
 	position     int  // current position in input (points to current char)
 	readPosition int  // current reading position in input (after current char)
-	ch           byte // character under examination
+	ch           rune // character under examination, utf8.RuneError for an invalid byte
+	line         int  // line of the current char
+	column       int  // column of the current char, counted in characters rather than bytes
+	keepTrivia   bool // whether comments are attached to tokens as trivia
+	//brace depth within each ${...} of an interpolated string being read,
+	//innermost last. The } that closes an interpolation resumes the string.
+	interpolations []int
 }
 
 func New(input string) *Lexer {
+	return NewFile("", input)
+}
+
+//NewFile returns a lexer whose token positions refer to the given file name
+func NewFile(filename, input string) *Lexer {
 
-	l := &Lexer{input: input}
+	l := &Lexer{input: input, filename: filename, line: 1}
 	l.readChar()
 	return l
 }
 
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+	size := 0
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
-		l.ch = l.input[l.readPosition]
+		l.ch, size = utf8.DecodeRuneInString(l.input[l.readPosition:])
 	}
 	l.position = l.readPosition
-	l.readPosition++
+	l.readPosition += size
+	if size == 0 {
+		l.readPosition++
+	}
+	l.column++
 
 }
 
+//KeepTrivia makes the lexer attach the comments preceding each token to its
+//Leading field instead of dropping them
+func (l *Lexer) KeepTrivia() {
+	l.keepTrivia = true
+}
+
+//currentPosition returns the source position of the character under examination
+func (l *Lexer) currentPosition() token.Position {
+	return token.Position{
+		Filename: l.filename,
+		Offset:   l.position,
+		Line:     l.line,
+		Column:   l.column,
+	}
+}
+
 func (l *Lexer) NextToken() token.Token {
+	leading, unterminated := l.skipTrivia()
+	if unterminated != nil {
+		unterminated.Leading = leading
+		return *unterminated
+	}
+
+	t := l.readToken()
+	t.Leading = leading
+	return t
+}
+
+//readToken reads the token starting at the current character
+func (l *Lexer) readToken() token.Token {
 	var t token.Token
 
-	l.skipWhiteSpaces()
+	start := l.currentPosition()
 
 	switch l.ch {
 	case '=':
@@ -55,32 +108,49 @@ func (l *Lexer) NextToken() token.Token {
 			t = newToken(token.BANG, l.ch)
 		}
 	case '+':
-		t = newToken(token.PLUS, l.ch)
+		t = l.withEquals(token.PLUS, token.PLUS_ASSIGN)
 	case '-':
-		t = newToken(token.MINUS, l.ch)
+		t = l.withEquals(token.MINUS, token.MINUS_ASSIGN)
 	case '*':
-		t = newToken(token.ASTERISK, l.ch)
+		t = l.withEquals(token.ASTERISK, token.ASTERISK_ASSIGN)
 	case '/':
-		t = newToken(token.SLASH, l.ch)
+		t = l.withEquals(token.SLASH, token.SLASH_ASSIGN)
+	case '%':
+		t = newToken(token.PERCENT, l.ch)
 	case '(':
 		t = newToken(token.LPAREN, l.ch)
 	case ')':
 		t = newToken(token.RPAREN, l.ch)
 	case '{':
+		if n := len(l.interpolations); n > 0 {
+			l.interpolations[n-1]++
+		}
 		t = newToken(token.LBRACE, l.ch)
 	case '}':
+		if n := len(l.interpolations); n > 0 {
+			if l.interpolations[n-1] == 0 {
+				l.interpolations = l.interpolations[:n-1]
+				return l.readString(start, true)
+			}
+			l.interpolations[n-1]--
+		}
 		t = newToken(token.RBRACE, l.ch)
 	case '<':
-		t = newToken(token.LT, l.ch)
+		t = l.withEquals(token.LT, token.LT_EQ)
 	case '>':
-		t = newToken(token.GT, l.ch)
+		t = l.withEquals(token.GT, token.GT_EQ)
+	case '&':
+		t = l.doubled(token.AND)
+	case '|':
+		t = l.doubled(token.OR)
 	case ';':
 		t = newToken(token.SEMICOLON, l.ch)
 	case ',':
 		t = newToken(token.COMMA, l.ch)
 	case '"':
-		t.Type = token.STRING
-		t.Literal = l.readString()
+		return l.readString(start, false)
+	case '`':
+		return l.readRawString(start)
 	case '[':
 		t = newToken(token.LBRACKET, l.ch)
 	case ']':
@@ -95,18 +165,23 @@ func (l *Lexer) NextToken() token.Token {
 
 			t.Literal = l.readIdentifier()
 			t.Type = token.LookupIdentifier(t.Literal)
+			t.Pos, t.End = start, l.currentPosition()
 			return t
 
 		} else if isDigit(l.ch) {
-			t.Type = token.INT
-			t.Literal = l.readNumber()
+			t.Literal, t.Type = l.readNumber()
+			t.Pos, t.End = start, l.currentPosition()
 			return t
 		} else {
-			t = newToken(token.ILLEGAL, l.ch)
+			// the source bytes rather than l.ch, which is utf8.RuneError for invalid UTF-8
+			t = token.Token{Type: token.ILLEGAL, Literal: l.input[l.position:l.readPosition]}
 		}
 
 	}
-	l.readChar()
+	if t.Type != token.EOF {
+		l.readChar()
+	}
+	t.Pos, t.End = start, l.currentPosition()
 	return t
 }
 
@@ -116,7 +191,7 @@ func (l *Lexer) NextToken() token.Token {
  */
 
 //newToken is a helper function to initialize the token.Token
-func newToken(tokenType token.TokenType, ch byte) token.Token {
+func newToken(tokenType token.TokenType, ch rune) token.Token {
 	t := token.Token{
 		Type:    tokenType,
 		Literal: string(ch),
@@ -124,6 +199,28 @@ func newToken(tokenType token.TokenType, ch byte) token.Token {
 	return t
 }
 
+//withEquals returns a token of type withEquals when the current character is
+//followed by =, as in += or <=, and a token of type single otherwise
+func (l *Lexer) withEquals(single, withEquals token.TokenType) token.Token {
+	if l.peakChar() == '=' {
+		ch := l.ch
+		l.readChar()
+		return token.Token{Type: withEquals, Literal: string(ch) + string(l.ch)}
+	}
+	return newToken(single, l.ch)
+}
+
+//doubled returns a token of type double when the current character is
+//repeated, as in &&, and an ILLEGAL token otherwise
+func (l *Lexer) doubled(double token.TokenType) token.Token {
+	if l.peakChar() == l.ch {
+		ch := l.ch
+		l.readChar()
+		return token.Token{Type: double, Literal: string(ch) + string(l.ch)}
+	}
+	return newToken(token.ILLEGAL, l.ch)
+}
+
 //readIdentifier reads the input and advances the position until a non letter is encountered
 //it returns the identifier with input[position]
 func (l *Lexer) readIdentifier() string {
@@ -136,25 +233,96 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[position:l.position]
 }
 
-//isLetter returns true if the passed byte is a permitted letter, we can include special characters *,?, etc just like "_"
-func isLetter(ch byte) bool {
-	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch == '_'
+//isLetter returns true if the passed rune is a permitted letter, any Unicode letter is, and we can include special characters *,?, etc just like "_"
+func isLetter(ch rune) bool {
+	return unicode.IsLetter(ch) || ch == '_'
 }
 
-func (l *Lexer) readNumber() string {
+//readNumber reads an integer, or a float if the digits are followed by a
+//fraction such as .5 or an exponent such as e-9. A dot or an e that is not
+//followed by digits ends the number.
+func (l *Lexer) readNumber() (string, token.TokenType) {
 	position := l.position
+	var tokenType token.TokenType = token.INT
+
+	l.readDigits()
+	if l.ch == '.' && isDigit(l.peakChar()) {
+		tokenType = token.FLOAT
+		l.readChar()
+		l.readDigits()
+	}
+	if (l.ch == 'e' || l.ch == 'E') && l.exponentFollows() {
+		tokenType = token.FLOAT
+		l.readChar()
+		if l.ch == '+' || l.ch == '-' {
+			l.readChar()
+		}
+		l.readDigits()
+	}
 
+	return l.input[position:l.position], tokenType
+}
+
+func (l *Lexer) readDigits() {
 	for isDigit(l.ch) {
 		l.readChar()
 	}
+}
 
-	return l.input[position:l.position]
+//exponentFollows reports whether the e under examination starts an exponent,
+//that is whether it is followed by digits with an optional sign
+func (l *Lexer) exponentFollows() bool {
+	next := l.peakChar()
+	if next == '+' || next == '-' {
+		return l.readPosition+1 < len(l.input) && isDigit(rune(l.input[l.readPosition+1]))
+	}
+	return isDigit(next)
 }
 
-func isDigit(ch byte) bool {
+func isDigit(ch rune) bool {
 	return ch >= '0' && ch <= '9'
 }
 
+//skipTrivia skips white space and comments, returning the comments if the
+//lexer keeps trivia. A block comment that is never closed is returned as an
+//ILLEGAL token.
+func (l *Lexer) skipTrivia() ([]token.Trivia, *token.Token) {
+	var trivia []token.Trivia
+
+	for {
+		l.skipWhiteSpaces()
+		if l.ch != '/' || (l.peakChar() != '/' && l.peakChar() != '*') {
+			return trivia, nil
+		}
+
+		start := l.currentPosition()
+		comment := token.Trivia{Type: token.LINE_COMMENT, Pos: start}
+		if l.peakChar() == '/' {
+			for l.ch != '\n' && l.ch != 0 {
+				l.readChar()
+			}
+		} else {
+			comment.Type = token.BLOCK_COMMENT
+			l.readChar()
+			l.readChar()
+			for !(l.ch == '*' && l.peakChar() == '/') {
+				if l.ch == 0 {
+					return trivia, &token.Token{Type: token.ILLEGAL, Literal: "/*", Pos: start, End: l.currentPosition()}
+				}
+				l.readChar()
+			}
+			l.readChar()
+			l.readChar()
+		}
+
+		if l.keepTrivia {
+			comment.Literal = l.input[start.Offset:l.position]
+			comment.End = l.currentPosition()
+			trivia = append(trivia, comment)
+		}
+	}
+}
+
 func (l *Lexer) skipWhiteSpaces() {
 
 	for l.ch == ' ' || l.ch == '\n' || l.ch == '\t' || l.ch == '\r' {
@@ -162,23 +330,169 @@ func (l *Lexer) skipWhiteSpaces() {
 	}
 }
 
-func (l *Lexer) peakChar() byte {
+func (l *Lexer) peakChar() rune {
 	if l.readPosition >= len(l.input) {
 		return 0
 	} else {
-		return l.input[l.readPosition]
+		ch, _ := utf8.DecodeRuneInString(l.input[l.readPosition:])
+		return ch
 	}
 
 }
 
-func (l *Lexer) readString() string {
+//readString reads a double quoted string, decoding its escape sequences into
+//the literal. An unknown escape is returned as an ILLEGAL token spanning just
+//the escape, and a string that is never closed as an ILLEGAL token holding
+//the source from the opening quote on.
+//
+//A string containing ${...} is split into parts: the text up to the first ${
+//is a STRING_START token, the text between two interpolations a STRING_MIDDLE
+//and the text after the last one a STRING_END, with the tokens of each
+//embedded expression in between. resumed is set when reading on from the }
+//that closes an interpolation.
+func (l *Lexer) readString(start token.Position, resumed bool) token.Token {
+	var out strings.Builder
+	var invalid *token.Token
 
-	position := l.position + 1
 	for {
 		l.readChar()
-		if l.ch == '"' || l.ch == 0 {
-			break
+		switch l.ch {
+		case 0:
+			return token.Token{Type: token.ILLEGAL, Literal: l.input[start.Offset:l.position], Pos: start, End: l.currentPosition()}
+		case '"':
+			l.readChar()
+			if invalid != nil {
+				return *invalid
+			}
+			tokenType := token.TokenType(token.STRING)
+			if resumed {
+				tokenType = token.STRING_END
+			}
+			return token.Token{Type: tokenType, Literal: out.String(), Pos: start, End: l.currentPosition()}
+		case '$':
+			if l.peakChar() != '{' {
+				out.WriteRune(l.ch)
+				continue
+			}
+			l.readChar()
+			l.readChar()
+			l.interpolations = append(l.interpolations, 0)
+			if invalid != nil {
+				return *invalid
+			}
+			tokenType := token.TokenType(token.STRING_START)
+			if resumed {
+				tokenType = token.STRING_MIDDLE
+			}
+			return token.Token{Type: tokenType, Literal: out.String(), Pos: start, End: l.currentPosition()}
+		case '\\':
+			escape := l.currentPosition()
+			r, ok := l.readEscape()
+			if !ok && invalid == nil {
+				invalid = &token.Token{Type: token.ILLEGAL, Literal: l.input[escape.Offset:l.readPosition], Pos: escape, End: l.nextPosition()}
+			}
+			out.WriteRune(r)
+		default:
+			out.WriteRune(l.ch)
 		}
 	}
-	return l.input[position:l.position]
+}
+
+//readEscape reads the escape sequence starting at the backslash under
+//examination, leaving the lexer on its last character
+func (l *Lexer) readEscape() (rune, bool) {
+	switch l.peakChar() {
+	case 'n':
+		l.readChar()
+		return '\n', true
+	case 't':
+		l.readChar()
+		return '\t', true
+	case '"':
+		l.readChar()
+		return '"', true
+	case '$':
+		l.readChar()
+		return '$', true
+	case '\\':
+		l.readChar()
+		return '\\', true
+	case 'u':
+		l.readChar()
+		return l.readUnicodeEscape()
+	default:
+		if l.peakChar() != 0 {
+			l.readChar()
+		}
+		return utf8.RuneError, false
+	}
+}
+
+//readUnicodeEscape reads the {...} part of a \u{...} escape holding one to
+//six hex digits that name a valid code point
+func (l *Lexer) readUnicodeEscape() (rune, bool) {
+	if l.peakChar() != '{' {
+		return utf8.RuneError, false
+	}
+	l.readChar()
+
+	var r rune
+	digits := 0
+	for isHexDigit(l.peakChar()) {
+		l.readChar()
+		r = r*16 + hexValue(l.ch)
+		digits++
+		if digits > 6 {
+			return utf8.RuneError, false
+		}
+	}
+	if l.peakChar() != '}' || digits == 0 || !utf8.ValidRune(r) {
+		return utf8.RuneError, false
+	}
+	l.readChar()
+	return r, true
+}
+
+//readRawString reads a backtick string, which has no escape sequences and may
+//span several lines
+func (l *Lexer) readRawString(start token.Position) token.Token {
+	for {
+		l.readChar()
+		switch l.ch {
+		case 0:
+			return token.Token{Type: token.ILLEGAL, Literal: l.input[start.Offset:l.position], Pos: start, End: l.currentPosition()}
+		case '`':
+			literal := l.input[start.Offset+1 : l.position]
+			l.readChar()
+			return token.Token{Type: token.STRING, Literal: literal, Pos: start, End: l.currentPosition()}
+		}
+	}
+}
+
+//nextPosition returns the source position of the character after the one
+//under examination
+func (l *Lexer) nextPosition() token.Position {
+	pos := l.currentPosition()
+	pos.Offset = l.readPosition
+	pos.Column++
+	if l.ch == '\n' {
+		pos.Line++
+		pos.Column = 1
+	}
+	return pos
+}
+
+func isHexDigit(ch rune) bool {
+	return isDigit(ch) || ch >= 'a' && ch <= 'f' || ch >= 'A' && ch <= 'F'
+}
+
+func hexValue(ch rune) rune {
+	switch {
+	case isDigit(ch):
+		return ch - '0'
+	case ch >= 'a' && ch <= 'f':
+		return ch - 'a' + 10
+	default:
+		return ch - 'A' + 10
+	}
 }
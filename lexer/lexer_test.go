@@ -12,7 +12,7 @@ func TestNextToken(t *testing.T) {
 		x+y;
 	}
 	let result = add(five,ten);
-    !-/*5<>;
+    !-/ *5<>;
 	if 5 < 10 {
 	return true;
 	} else {
@@ -24,6 +24,8 @@ func TestNextToken(t *testing.T) {
 	"foo bar"
 	[1, 2];
 	{"foo": "bar"}
+	x += 1 -= 2 *= 3 /= 4 % 5
+	a <= b >= c && d || e
 `
 
 	tests := []struct {
@@ -109,6 +111,26 @@ func TestNextToken(t *testing.T) {
 		{token.COLON, ":"},
 		{token.STRING, "bar"},
 		{token.RBRACE, "}"},
+		{token.IDENT, "x"},
+		{token.PLUS_ASSIGN, "+="},
+		{token.INT, "1"},
+		{token.MINUS_ASSIGN, "-="},
+		{token.INT, "2"},
+		{token.ASTERISK_ASSIGN, "*="},
+		{token.INT, "3"},
+		{token.SLASH_ASSIGN, "/="},
+		{token.INT, "4"},
+		{token.PERCENT, "%"},
+		{token.INT, "5"},
+		{token.IDENT, "a"},
+		{token.LT_EQ, "<="},
+		{token.IDENT, "b"},
+		{token.GT_EQ, ">="},
+		{token.IDENT, "c"},
+		{token.AND, "&&"},
+		{token.IDENT, "d"},
+		{token.OR, "||"},
+		{token.IDENT, "e"},
 		{token.EOF, ""},
 	}
 
@@ -137,4 +159,310 @@ func TestNextToken(t *testing.T) {
 {token.STRING, "bar"},
 {token.RBRACE, "}"},
 
-*/
\ No newline at end of file
+*/
+func TestTokenPositions(t *testing.T) {
+	input := "let x = 5;\n  x + \"ab\";"
+
+	tests := []struct {
+		expectedType   token.TokenType
+		expectedPos    token.Position
+		expectedEndCol int
+	}{
+		{token.LET, token.Position{Filename: "test.hk", Offset: 0, Line: 1, Column: 1}, 4},
+		{token.IDENT, token.Position{Filename: "test.hk", Offset: 4, Line: 1, Column: 5}, 6},
+		{token.ASSIGN, token.Position{Filename: "test.hk", Offset: 6, Line: 1, Column: 7}, 8},
+		{token.INT, token.Position{Filename: "test.hk", Offset: 8, Line: 1, Column: 9}, 10},
+		{token.SEMICOLON, token.Position{Filename: "test.hk", Offset: 9, Line: 1, Column: 10}, 11},
+		{token.IDENT, token.Position{Filename: "test.hk", Offset: 13, Line: 2, Column: 3}, 4},
+		{token.PLUS, token.Position{Filename: "test.hk", Offset: 15, Line: 2, Column: 5}, 6},
+		{token.STRING, token.Position{Filename: "test.hk", Offset: 17, Line: 2, Column: 7}, 11},
+		{token.SEMICOLON, token.Position{Filename: "test.hk", Offset: 21, Line: 2, Column: 11}, 12},
+		{token.EOF, token.Position{Filename: "test.hk", Offset: 22, Line: 2, Column: 12}, 12},
+	}
+
+	l := NewFile("test.hk", input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Pos != tt.expectedPos {
+			t.Errorf("tests[%d] - position wrong. expected=%+v, got=%+v", i, tt.expectedPos, tok.Pos)
+		}
+		if tok.End.Column != tt.expectedEndCol {
+			t.Errorf("tests[%d] - end column wrong. expected=%d, got=%d", i, tt.expectedEndCol, tok.End.Column)
+		}
+	}
+}
+
+func TestNumberTokens(t *testing.T) {
+	input := "3.14 1e-9 2E+3 1.5e3 7 1.x 1e 0.5"
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.FLOAT, "3.14"},
+		{token.FLOAT, "1e-9"},
+		{token.FLOAT, "2E+3"},
+		{token.FLOAT, "1.5e3"},
+		{token.INT, "7"},
+		{token.INT, "1"},
+		{token.ILLEGAL, "."},
+		{token.IDENT, "x"},
+		{token.INT, "1"},
+		{token.IDENT, "e"},
+		{token.FLOAT, "0.5"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. expected=%s %q, got=%s %q", i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestSingleAmpersandAndPipeAreIllegal(t *testing.T) {
+	l := New("a & b | c")
+
+	expected := []token.TokenType{token.IDENT, token.ILLEGAL, token.IDENT, token.ILLEGAL, token.IDENT, token.EOF}
+	for i, tt := range expected {
+		tok := l.NextToken()
+		if tok.Type != tt {
+			t.Fatalf("tests[%d] - wrong token type. expected=%q, got=%q", i, tt, tok.Type)
+		}
+	}
+}
+
+func TestCommentsAreSkipped(t *testing.T) {
+	input := `// leading comment
+let x = 10 / 2; // trailing comment
+/* block
+   comment */ x /* inline */ * 2
+//`
+
+	expected := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.LET, "let"},
+		{token.IDENT, "x"},
+		{token.ASSIGN, "="},
+		{token.INT, "10"},
+		{token.SLASH, "/"},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.ASTERISK, "*"},
+		{token.INT, "2"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range expected {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. expected=%s %q, got=%s %q", i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+		if tok.Leading != nil {
+			t.Errorf("tests[%d] - trivia kept without KeepTrivia: %+v", i, tok.Leading)
+		}
+	}
+}
+
+func TestTrivia(t *testing.T) {
+	input := "// doc\n/* more */ let x; // end"
+
+	l := New(input)
+	l.KeepTrivia()
+
+	let := l.NextToken()
+	if len(let.Leading) != 2 {
+		t.Fatalf("wrong number of trivia. expected=2, got=%d", len(let.Leading))
+	}
+	doc, more := let.Leading[0], let.Leading[1]
+	if doc.Type != token.LINE_COMMENT || doc.Literal != "// doc" || doc.Pos.Line != 1 || doc.End.Column != 7 {
+		t.Errorf("wrong line comment trivia. got=%+v", doc)
+	}
+	if more.Type != token.BLOCK_COMMENT || more.Literal != "/* more */" || more.Pos.Line != 2 || more.Pos.Column != 1 {
+		t.Errorf("wrong block comment trivia. got=%+v", more)
+	}
+	if let.Pos.Line != 2 || let.Pos.Column != 12 {
+		t.Errorf("comments moved the token. got=%s", let.Pos)
+	}
+
+	l.NextToken()
+	if semicolon := l.NextToken(); len(semicolon.Leading) != 0 {
+		t.Errorf("unexpected trivia on ;. got=%+v", semicolon.Leading)
+	}
+	eof := l.NextToken()
+	if eof.Type != token.EOF || len(eof.Leading) != 1 || eof.Leading[0].Literal != "// end" {
+		t.Errorf("trailing comment not attached to EOF. got=%+v", eof)
+	}
+}
+
+func TestUnterminatedBlockComment(t *testing.T) {
+	l := New("x /* never closed")
+
+	l.NextToken()
+	tok := l.NextToken()
+	if tok.Type != token.ILLEGAL || tok.Literal != "/*" || tok.Pos.Column != 3 {
+		t.Fatalf("expected ILLEGAL /* at column 3. got=%+v", tok)
+	}
+	if tok := l.NextToken(); tok.Type != token.EOF {
+		t.Errorf("expected EOF after the unterminated comment. got=%+v", tok)
+	}
+}
+
+func TestStringLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"plain"`, "plain"},
+		{`"a\nb\tc"`, "a\nb\tc"},
+		{`"say \"hi\""`, `say "hi"`},
+		{`"back\\slash"`, `back\slash`},
+		{`"\u{41}\u{e9}\u{1F600}"`, "Aé😀"},
+		{"`raw \\n \"quotes\"`", `raw \n "quotes"`},
+		{"`two\nlines`", "two\nlines"},
+	}
+
+	for _, tt := range tests {
+		tok := New(tt.input).NextToken()
+		if tok.Type != token.STRING || tok.Literal != tt.expected {
+			t.Errorf("input %s: expected STRING %q, got=%s %q", tt.input, tt.expected, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestMultiLineStringPositions(t *testing.T) {
+	l := New("`one\ntwo` x")
+
+	str := l.NextToken()
+	if str.Pos.Line != 1 || str.Pos.Column != 1 || str.End.Line != 2 || str.End.Column != 5 {
+		t.Errorf("wrong string span. got=%s-%s", str.Pos, str.End)
+	}
+	if x := l.NextToken(); x.Pos.Line != 2 || x.Pos.Column != 6 {
+		t.Errorf("wrong position after the string. got=%s", x.Pos)
+	}
+}
+
+func TestInvalidStringLiterals(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedLiteral string
+		expectedColumn  int
+		expectedEnd     int
+	}{
+		{`"never closed`, `"never closed`, 1, 14},
+		{"x `raw", "`raw", 3, 7},
+		{`"bad \q escape" + 1`, `\q`, 6, 8},
+		{`"\u{110000}"`, `\u{110000`, 2, 11},
+		{`"\u{zz}"`, `\u{`, 2, 5},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+		tok := l.NextToken()
+		if tok.Type != token.ILLEGAL {
+			tok = l.NextToken()
+		}
+		if tok.Type != token.ILLEGAL || tok.Literal != tt.expectedLiteral {
+			t.Errorf("input %s: expected ILLEGAL %q, got=%s %q", tt.input, tt.expectedLiteral, tok.Type, tok.Literal)
+			continue
+		}
+		if tok.Pos.Column != tt.expectedColumn || tok.End.Column != tt.expectedEnd {
+			t.Errorf("input %s: wrong span. expected=%d-%d, got=%d-%d", tt.input, tt.expectedColumn, tt.expectedEnd, tok.Pos.Column, tok.End.Column)
+		}
+	}
+
+	l := New(`"bad \q escape" + 1`)
+	l.NextToken()
+	if tok := l.NextToken(); tok.Type != token.PLUS {
+		t.Errorf("lexing did not resume after the bad string. got=%s %q", tok.Type, tok.Literal)
+	}
+}
+
+func TestInterpolatedStrings(t *testing.T) {
+	input := `"count: ${n + 1}, ${ {"a": "${x}"}["a"] }!" "\${no}"`
+
+	expected := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.STRING_START, "count: "},
+		{token.IDENT, "n"},
+		{token.PLUS, "+"},
+		{token.INT, "1"},
+		{token.STRING_MIDDLE, ", "},
+		{token.LBRACE, "{"},
+		{token.STRING, "a"},
+		{token.COLON, ":"},
+		{token.STRING_START, ""},
+		{token.IDENT, "x"},
+		{token.STRING_END, ""},
+		{token.RBRACE, "}"},
+		{token.LBRACKET, "["},
+		{token.STRING, "a"},
+		{token.RBRACKET, "]"},
+		{token.STRING_END, "!"},
+		{token.STRING, "${no}"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range expected {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. expected=%s %q, got=%s %q", i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestUnicodeIdentifiers(t *testing.T) {
+	l := New("let größe = \"ü\"; 日本 + _x")
+
+	expected := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedColumn  int
+	}{
+		{token.LET, "let", 1},
+		{token.IDENT, "größe", 5},
+		{token.ASSIGN, "=", 11},
+		{token.STRING, "ü", 13},
+		{token.SEMICOLON, ";", 16},
+		{token.IDENT, "日本", 18},
+		{token.PLUS, "+", 21},
+		{token.IDENT, "_x", 23},
+		{token.EOF, "", 25},
+	}
+
+	for i, tt := range expected {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. expected=%s %q, got=%s %q", i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+		if tok.Pos.Column != tt.expectedColumn {
+			t.Errorf("tests[%d] - wrong column. expected=%d, got=%d", i, tt.expectedColumn, tok.Pos.Column)
+		}
+	}
+}
+
+func TestInvalidUTF8IsIllegal(t *testing.T) {
+	l := New("a \xff b")
+
+	l.NextToken()
+	tok := l.NextToken()
+	if tok.Type != token.ILLEGAL || tok.Literal != "\xff" {
+		t.Fatalf("expected ILLEGAL for the invalid byte. got=%s %q", tok.Type, tok.Literal)
+	}
+	if tok := l.NextToken(); tok.Type != token.IDENT || tok.Literal != "b" {
+		t.Errorf("lexing did not resume after the invalid byte. got=%s %q", tok.Type, tok.Literal)
+	}
+}
@@ -1,21 +1,187 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"interpreter/diagnostic"
+	"interpreter/lexer"
+	"interpreter/object"
+	"interpreter/optimizer"
+	"interpreter/parser"
 	"interpreter/repl"
+	"io"
 	"os"
 	"os/user"
+	"strings"
 )
 
+//exit codes follow sysexits(3)
+const (
+	exitOK      = 0
+	exitUsage   = 64 // bad command line
+	exitParse   = 65 // the program failed to parse or compile
+	exitNoInput = 66 // the script file could not be read
+	exitRuntime = 70 // the program failed while running
+)
+
+const usage = `Usage:
+  interpreter [flags]                       start the interactive REPL
+  interpreter [flags] run FILE [ARGS...]    run a script
+  interpreter [flags] -e CODE [ARGS...]     run inline code and print its result
+
+The script arguments are available to the program as the array args.
+
+Flags:
+`
+
 func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(arguments []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("interpreter", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+
+	engine := flags.String("engine", string(repl.EngineEval), "execution engine, eval or vm")
+	inline := flags.String("e", "", "run `code` given on the command line")
+	dumpAST := flags.Bool("dump-ast", false, "print the program as optimized instead of running it")
+	maxDepth := flags.Int("max-depth", object.DefaultMaxCallDepth, "maximum `depth` of nested function calls")
+	maxSteps := flags.Int64("max-steps", 0, "stop the program after `n` evaluation steps, 0 for no limit")
+	timeout := flags.Duration("timeout", 0, "stop the program after `duration`, 0 for no limit")
+	maxMemory := flags.Int64("max-memory", 0, "stop the program once it has allocated about `bytes` in total, 0 for no limit")
+	reportMemory := flags.Bool("report-memory", false, "print the approximate bytes the program allocated in total to stderr after it runs")
+
+	if err := flags.Parse(arguments); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+		return exitUsage
+	}
+
+	if *engine != string(repl.EngineEval) && *engine != string(repl.EngineVM) {
+		fmt.Fprintf(stderr, "unknown engine %q, want eval or vm\n", *engine)
+		return exitUsage
+	}
+
+	if *maxDepth <= 0 || *maxSteps < 0 || *timeout < 0 || *maxMemory < 0 {
+		fmt.Fprintln(stderr, "limits must not be negative, and -max-depth must be positive")
+		return exitUsage
+	}
+	limits := object.Limits{MaxSteps: *maxSteps, MaxCallDepth: *maxDepth, Timeout: *timeout, MaxMemory: *maxMemory}
+
+	rest := flags.Args()
+	inlineSet := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "e" {
+			inlineSet = true
+		}
+	})
+
+	switch {
+	case inlineSet:
+		return runSource(repl.Engine(*engine), "-e", *inline, rest, true, *dumpAST, *reportMemory, limits, stdin, stdout, stderr)
+
+	case len(rest) > 0 && rest[0] == "run":
+		if len(rest) < 2 {
+			fmt.Fprintln(stderr, "run: missing script file")
+			flags.Usage()
+			return exitUsage
+		}
+
+		filename := rest[1]
+		source, err := os.ReadFile(filename)
+		if err != nil {
+			fmt.Fprintf(stderr, "run: %s\n", err)
+			return exitNoInput
+		}
+		return runSource(repl.Engine(*engine), filename, string(source), rest[2:], false, *dumpAST, *reportMemory, limits, stdin, stdout, stderr)
+
+	case len(rest) > 0:
+		fmt.Fprintf(stderr, "unknown command %q\n", rest[0])
+		flags.Usage()
+		return exitUsage
+	}
 
 	user, err := user.Current()
 	if err != nil {
 		panic(err)
 	}
 
-	fmt.Printf("Hello %s! This is HubbyKing programming language\n ", user.Username)
-	fmt.Printf("Feel free to type commands\n")
+	fmt.Fprintf(stdout, "Hello %s! This is HubbyKing programming language\n ", user.Username)
+	fmt.Fprintf(stdout, "Feel free to type commands\n")
 
-	repl.Start(os.Stdin, os.Stdout)
+	repl.Start(stdin, stdout, repl.Engine(*engine), limits)
+	return exitOK
+}
+
+//runSource optimizes and runs a whole program non-interactively. Diagnostics
+//and runtime errors go to stderr, and the program's value to stdout when
+//printResult is set. With dumpAST the optimized program goes to stdout, one
+//statement per line, instead of being run. The program runs under limits and
+//its own input and output use stdin, stdout and stderr. With reportMemory the
+//bytes it allocated go to stderr once it ends.
+func runSource(engine repl.Engine, filename, source string, args []string, printResult, dumpAST, reportMemory bool, limits object.Limits, stdin io.Reader, stdout, stderr io.Writer) int {
+	source = stripShebang(source)
+
+	l := lexer.NewFile(filename, source)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		diagnostic.Render(stderr, source, p.Diagnostics())
+		return exitParse
+	}
+
+	program = optimizer.Optimize(program)
+	if dumpAST {
+		for _, s := range program.Statements {
+			fmt.Fprintln(stdout, s.String())
+		}
+		return exitOK
+	}
+
+	scriptArgs := &object.Array{Elements: []object.Object{}}
+	for _, arg := range args {
+		scriptArgs.Elements = append(scriptArgs.Elements, &object.String{Value: arg})
+	}
+
+	session := repl.NewSession(engine)
+	session.SetContext(object.NewContext(stdin, stdout, stderr))
+	session.SetLimits(limits)
+	session.Define("args", scriptArgs)
+
+	result, err := session.Run(program)
+	if reportMemory {
+		fmt.Fprintf(stderr, "allocated about %d bytes\n", session.AllocatedBytes())
+	}
+	if err != nil {
+		repl.PrintError(stderr, source, err)
+		if _, ok := err.(diagnostic.Diagnostic); ok {
+			return exitParse
+		}
+		return exitRuntime
+	}
+
+	if printResult && result != nil && result != object.NULL {
+		fmt.Fprintln(stdout, result.Inspect())
+	}
+	return exitOK
+}
+
+//stripShebang blanks out a leading #! line so scripts can be made executable.
+//The line is replaced by spaces rather than removed to keep positions intact.
+func stripShebang(source string) string {
+	if !strings.HasPrefix(source, "#!") {
+		return source
+	}
+
+	end := strings.IndexByte(source, '\n')
+	if end < 0 {
+		end = len(source)
+	}
+	return strings.Repeat(" ", end) + source[end:]
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+
+	script := filepath.Join(dir, "script.hk")
+	os.WriteFile(script, []byte("#!/usr/bin/env -S interpreter run\nlet x = len(args);\nx;\n"), 0644)
+
+	broken := filepath.Join(dir, "broken.hk")
+	os.WriteFile(broken, []byte("let x = 1;\nlet = 2;\n"), 0644)
+
+	failing := filepath.Join(dir, "failing.hk")
+	os.WriteFile(failing, []byte("let x = 1;\nx + true;\n"), 0644)
+
+	tests := []struct {
+		args           []string
+		expectedCode   int
+		expectedStdout string
+		expectedStderr string
+	}{
+		{[]string{"-e", "1 + 2"}, exitOK, "3\n", ""},
+		{[]string{"-engine=vm", "-e", "1 + 2"}, exitOK, "3\n", ""},
+		{[]string{"-dump-ast", "-e", "let day = 60 * 60 * 24; day"}, exitOK, "let day = 86400;\nday\n", ""},
+		{[]string{"-dump-ast", "-e", "1 +"}, exitParse, "", "-e:1:4: error[P002]"},
+		{[]string{"-e", "args[1]", "a", "b"}, exitOK, "b\n", ""},
+		{[]string{"-e", "let a = 1;"}, exitOK, "", ""},
+		{[]string{"-e", "1 +"}, exitParse, "", "-e:1:4: error[P002]"},
+		{[]string{"-e", "-true"}, exitRuntime, "", "ERROR -e:1:1: unknown operator: -BOOLEAN"},
+		{[]string{"-engine=vm", "-e", "nope"}, exitParse, "", "-e:1:1: error[R001]: undefined variable nope"},
+		{[]string{"-e", "nope"}, exitParse, "", "-e:1:1: error[R001]: undefined variable nope"},
+		{[]string{"-e", "len = 3"}, exitParse, "", "-e:1:1: error[R002]: cannot assign to builtin len"},
+		{[]string{"-engine=vm", "-e", "len = 3"}, exitParse, "", "-e:1:1: error[R002]: cannot assign to builtin len"},
+		{[]string{"run", script, "one", "two"}, exitOK, "", ""},
+		{[]string{"-engine=vm", "run", script}, exitOK, "", ""},
+		{[]string{"run", broken}, exitParse, "", "broken.hk:2:5: error[P001]"},
+		{[]string{"-engine=vm", "run", failing}, exitRuntime, "", "failing.hk:2:3: type mismatch"},
+		{[]string{"run", filepath.Join(dir, "missing.hk")}, exitNoInput, "", "no such file"},
+		{[]string{"run"}, exitUsage, "", "missing script file"},
+		{[]string{"frobnicate"}, exitUsage, "", "unknown command"},
+		{[]string{"-engine=jit", "-e", "1"}, exitUsage, "", "unknown engine"},
+		{[]string{"-max-depth=5", "-e", "let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(10)"}, exitRuntime, "", "stack overflow"},
+		{[]string{"-engine=vm", "-max-depth=5000", "-e", "let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(2000)"}, exitOK, "2000\n", ""},
+		{[]string{"-timeout=10ms", "-e", "let spin = fn(n) { spin(n + 1) }; spin(0)"}, exitRuntime, "", "execution timed out after 10ms"},
+		{[]string{"-engine=vm", "-max-steps=100", "-e", "while (true) { }"}, exitRuntime, "", "step limit of 100 exceeded"},
+		{[]string{"-max-depth=0", "-e", "1"}, exitUsage, "", "-max-depth must be positive"},
+		{[]string{"-max-memory=1000", "-e", "let s = \"a\"; while (true) { s = s + s }"}, exitRuntime, "", "memory limit of 1000 bytes exceeded"},
+		{[]string{"-engine=vm", "-max-memory=1000", "-e", "let s = \"a\"; while (true) { s = s + s }"}, exitRuntime, "", "memory limit of 1000 bytes exceeded"},
+		{[]string{"-report-memory", "-e", "[1, 2]"}, exitOK, "[1, 2]\n", "allocated about 64 bytes"},
+		{[]string{"-engine=vm", "-report-memory", "-e", "[1, 2]"}, exitOK, "[1, 2]\n", "allocated about 64 bytes"},
+		{[]string{"-max-memory=-1", "-e", "1"}, exitUsage, "", "limits must not be negative"},
+	}
+
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+
+		code := run(tt.args, strings.NewReader(""), &stdout, &stderr)
+
+		if code != tt.expectedCode {
+			t.Errorf("%v: wrong exit code. expected=%d, got=%d (stderr=%q)", tt.args, tt.expectedCode, code, stderr.String())
+		}
+		if stdout.String() != tt.expectedStdout {
+			t.Errorf("%v: wrong stdout. expected=%q, got=%q", tt.args, tt.expectedStdout, stdout.String())
+		}
+		if !strings.Contains(stderr.String(), tt.expectedStderr) {
+			t.Errorf("%v: stderr does not contain %q. got=%q", tt.args, tt.expectedStderr, stderr.String())
+		}
+	}
+}
+
+func TestRunUsesStreams(t *testing.T) {
+	for _, engine := range []string{"eval", "vm"} {
+		var stdout, stderr bytes.Buffer
+		stdin := strings.NewReader("alice\nrest")
+
+		code := run([]string{"-engine=" + engine, "-e", `puts("hi " + readline()); eprint("warn"); read_all()`}, stdin, &stdout, &stderr)
+
+		if code != exitOK {
+			t.Errorf("%s: wrong exit code. got=%d (stderr=%q)", engine, code, stderr.String())
+		}
+		if stdout.String() != "hi alice\nrest\n" {
+			t.Errorf("%s: wrong stdout. got=%q", engine, stdout.String())
+		}
+		if stderr.String() != "warn" {
+			t.Errorf("%s: wrong stderr. got=%q", engine, stderr.String())
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package object
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+//DefaultMaxCallDepth bounds the nesting of function calls when Limits leaves
+//MaxCallDepth unset, so that runaway recursion fails with an error instead of
+//exhausting the Go stack
+const DefaultMaxCallDepth = 10000
+
+//checkInterval is the number of steps between two checks of the context and
+//the clock, which are too costly to do on every step
+const checkInterval = 1024
+
+//Limits bounds the resources a program may use. A zero MaxSteps, Timeout or
+//MaxMemory means no limit, a zero MaxCallDepth means DefaultMaxCallDepth.
+type Limits struct {
+	MaxSteps     int64         // evaluation steps, one per node evaluated or instruction executed
+	MaxCallDepth int           // function calls in progress at the same time
+	Timeout      time.Duration // wall-clock time from the start of the program
+	MaxMemory    int64         // quota of approximate bytes of strings, arrays and hashes built in total, garbage included
+}
+
+//Budget tracks a running program against its limits and the context it was
+//started with. Once a limit is hit every further step fails with the same
+//error, so that the program unwinds.
+type Budget struct {
+	ctx       context.Context
+	limits    Limits
+	deadline  time.Time
+	steps     int64
+	depth     int
+	allocated int64
+	err       *Error
+}
+
+//NewBudget starts tracking a program run under ctx with limits
+func NewBudget(ctx context.Context, limits Limits) *Budget {
+	if limits.MaxCallDepth == 0 {
+		limits.MaxCallDepth = DefaultMaxCallDepth
+	}
+
+	b := &Budget{ctx: ctx, limits: limits}
+	if limits.Timeout > 0 {
+		b.deadline = time.Now().Add(limits.Timeout)
+	}
+	return b
+}
+
+//Step counts one evaluation step, returning an error once a limit is exceeded
+//or the context is done
+func (b *Budget) Step() *Error {
+	if b.err != nil {
+		return b.err
+	}
+
+	b.steps++
+	if b.limits.MaxSteps > 0 && b.steps > b.limits.MaxSteps {
+		return b.fail(StepLimitExceeded, "step limit of %d exceeded", b.limits.MaxSteps)
+	}
+	if b.steps%checkInterval == 0 {
+		return b.check()
+	}
+	return nil
+}
+
+//Steps returns the number of steps taken so far
+func (b *Budget) Steps() int64 { return b.steps }
+
+//Enter records the start of a function call, returning an error if that
+//exceeds the maximum call depth. Every successful Enter must be paired with a
+//Leave.
+func (b *Budget) Enter() *Error {
+	if b.depth >= b.limits.MaxCallDepth {
+		return &Error{Message: "stack overflow", Kind: CallDepthExceeded}
+	}
+	b.depth++
+	return nil
+}
+
+//Leave records the end of a function call
+func (b *Budget) Leave() { b.depth-- }
+
+//Charge counts bytes of memory allocated by the program, returning an error
+//once that exceeds the memory limit
+func (b *Budget) Charge(bytes int64) *Error {
+	if b.err != nil {
+		return b.err
+	}
+
+	b.allocated += bytes
+	if b.limits.MaxMemory > 0 && b.allocated > b.limits.MaxMemory {
+		return b.fail(MemoryLimitExceeded, "memory limit of %d bytes exceeded", b.limits.MaxMemory)
+	}
+	return nil
+}
+
+//AllocatedBytes returns the bytes charged so far. Nothing is ever given back,
+//as the interpreter cannot tell when a value becomes garbage, so this is an
+//upper bound of the memory the program held at any one time and the figure to
+//size MaxMemory with.
+func (b *Budget) AllocatedBytes() int64 { return b.allocated }
+
+func (b *Budget) check() *Error {
+	switch b.ctx.Err() {
+	case nil:
+	case context.DeadlineExceeded:
+		return b.fail(Timeout, "execution timed out")
+	default:
+		return b.fail(Cancelled, "execution cancelled")
+	}
+
+	if !b.deadline.IsZero() && time.Now().After(b.deadline) {
+		return b.fail(Timeout, "execution timed out after %s", b.limits.Timeout)
+	}
+	return nil
+}
+
+func (b *Budget) fail(kind ErrorKind, format string, a ...interface{}) *Error {
+	b.err = &Error{Message: fmt.Sprintf(format, a...), Kind: kind}
+	return b.err
+}
+
+//approximate sizes on a 64-bit platform of the parts of strings, arrays and
+//hashes, counting the object itself and the Go values it points to
+const (
+	stringSize  = 32 // the object and the string header
+	arraySize   = 32 // the object and the slice header
+	elementSize = 16 // an interface value in the slice
+	hashSize    = 56 // the object and the map header
+	pairSize    = 64 // a HashKey and a HashPair in the map, with its share of the buckets
+)
+
+//SizeOf approximates the memory taken by a string, array or hash, without
+//the elements and pairs it holds, which were charged when they were built. It
+//returns 0 for any other object.
+func SizeOf(obj Object) int64 {
+	switch obj := obj.(type) {
+	case *String:
+		return stringSize + int64(len(obj.Value))
+	case *Array:
+		return arraySize + elementSize*int64(len(obj.Elements))
+	case *Hash:
+		return hashSize + pairSize*int64(len(obj.Pairs))
+	default:
+		return 0
+	}
+}
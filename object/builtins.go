@@ -0,0 +1,451 @@
+package object
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+//Builtins is shared by the evaluator and the compiler, the vm refers to a
+//builtin by its index in this slice so new entries must be appended
+var Builtins = []struct {
+	Name    string
+	Builtin *Builtin
+}{
+	{
+		"len",
+		&Builtin{Fn: func(ctx *Context, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d,want =1", len(args))
+			}
+			switch arg := args[0].(type) {
+			case *Array:
+				return &Integer{Value: int64(len(arg.Elements))}
+			case *String:
+				return &Integer{Value: int64(utf8.RuneCountInString(arg.Value))}
+			default:
+				return newError("argument to `len` is not supported, got %s", args[0].Type())
+			}
+		},
+		},
+	},
+	{
+		"first",
+		&Builtin{Fn: func(ctx *Context, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want =1", len(args))
+			}
+			if args[0].Type() != ARRAY_OBJ {
+				return newError("argument to `first` must be ARRAY, got%s", args[0].Type())
+			}
+
+			arr := args[0].(*Array)
+			if len(arr.Elements) > 0 {
+				return arr.Elements[0]
+			}
+			return NULL
+		},
+		},
+	},
+	{
+		"last",
+		&Builtin{Fn: func(ctx *Context, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want =1", len(args))
+			}
+			if args[0].Type() != ARRAY_OBJ {
+				return newError("argument to `first` must be ARRAY, got%s", args[0].Type())
+			}
+
+			arr := args[0].(*Array)
+			length := len(arr.Elements)
+			if len(arr.Elements) > 0 {
+				return arr.Elements[length-1]
+			}
+			return NULL
+		},
+		},
+	},
+	{
+		"rest",
+		&Builtin{Fn: func(ctx *Context, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments.got= %d, want=1", len(args))
+			}
+			if args[0].Type() != ARRAY_OBJ {
+				return newError("argument to `rest` must be ARRAY, got=%s", args[0].Type())
+			}
+
+			arr := args[0].(*Array)
+			length := len(arr.Elements)
+
+			if length > 0 {
+				newElements := make([]Object, length-1, length-1)
+				copy(newElements, arr.Elements[1:length])
+				return ctx.Allocate(&Array{Elements: newElements})
+			}
+			return NULL
+		},
+		},
+	},
+	{
+		"push",
+		&Builtin{Fn: func(ctx *Context, args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+
+			if args[0].Type() != ARRAY_OBJ {
+				return newError("argument to `push` must be ARRAY, got %s", args[0].Type())
+			}
+
+			arr := args[0].(*Array)
+			length := len(arr.Elements)
+
+			newElements := make([]Object, length+1, length+1)
+			copy(newElements, arr.Elements)
+			newElements[length] = args[1]
+
+			return ctx.Allocate(&Array{Elements: newElements})
+		},
+		},
+	},
+	{
+		"puts",
+		&Builtin{Fn: func(ctx *Context, args ...Object) Object {
+			for _, arg := range args {
+				if _, err := fmt.Fprintln(ctx.Stdout, arg.Inspect()); err != nil {
+					return newError("puts: %s", err)
+				}
+			}
+			return NULL
+		},
+		},
+	},
+	{
+		"set",
+		&Builtin{Fn: func(ctx *Context, args ...Object) Object {
+			if len(args) != 3 {
+				return newError("wrong number of arguments. got=%d, want=3", len(args))
+			}
+			if args[0].Type() != HASH_OBJ {
+				return newError("argument to `set` must be HASH, got %s", args[0].Type())
+			}
+
+			size := SizeOf(args[0])
+			if result := SetIndex(args[0], args[1], args[2]); result.Type() == ERROR_OBJ {
+				return result
+			}
+			if err := ctx.charge(SizeOf(args[0]) - size); err != nil {
+				return err
+			}
+			return args[0]
+		},
+		},
+	},
+	{
+		"delete",
+		&Builtin{Fn: func(ctx *Context, args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			if args[0].Type() != HASH_OBJ {
+				return newError("argument to `delete` must be HASH, got %s", args[0].Type())
+			}
+
+			hash := args[0].(*Hash)
+			key, ok := args[1].(Hashable)
+			if !ok {
+				return newError("unusable as hash key: %s", args[1].Type())
+			}
+
+			delete(hash.Pairs, key.HashKey())
+			return hash
+		},
+		},
+	},
+	{
+		"keys",
+		&Builtin{Fn: func(ctx *Context, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			if args[0].Type() != HASH_OBJ {
+				return newError("argument to `keys` must be HASH, got %s", args[0].Type())
+			}
+
+			pairs := args[0].(*Hash).OrderedPairs()
+			keys := make([]Object, len(pairs))
+			for i, pair := range pairs {
+				keys[i] = pair.Key
+			}
+			return ctx.Allocate(&Array{Elements: keys})
+		},
+		},
+	},
+	{
+		"values",
+		&Builtin{Fn: func(ctx *Context, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			if args[0].Type() != HASH_OBJ {
+				return newError("argument to `values` must be HASH, got %s", args[0].Type())
+			}
+
+			pairs := args[0].(*Hash).OrderedPairs()
+			values := make([]Object, len(pairs))
+			for i, pair := range pairs {
+				values[i] = pair.Value
+			}
+			return ctx.Allocate(&Array{Elements: values})
+		},
+		},
+	},
+	{
+		"has",
+		&Builtin{Fn: func(ctx *Context, args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			if args[0].Type() != HASH_OBJ {
+				return newError("argument to `has` must be HASH, got %s", args[0].Type())
+			}
+
+			key, ok := args[1].(Hashable)
+			if !ok {
+				return newError("unusable as hash key: %s", args[1].Type())
+			}
+
+			if _, ok := args[0].(*Hash).Pairs[key.HashKey()]; ok {
+				return TRUE
+			}
+			return FALSE
+		},
+		},
+	},
+	{
+		"int",
+		&Builtin{Fn: func(ctx *Context, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *Integer, *BigInt:
+				return arg
+			case *Float:
+				if integer, ok := FloatToInteger(arg.Value); ok {
+					return integer
+				}
+				return newError("cannot convert %s to INTEGER", arg.Inspect())
+			case *String:
+				value, ok := new(big.Int).SetString(strings.TrimSpace(arg.Value), 10)
+				if !ok {
+					return newError("could not parse %q as INTEGER", arg.Value)
+				}
+				return NewBigInteger(value)
+			default:
+				return newError("argument to `int` is not supported, got %s", args[0].Type())
+			}
+		},
+		},
+	},
+	{
+		"float",
+		&Builtin{Fn: func(ctx *Context, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *Integer, *BigInt:
+				value, _ := ToFloat(arg)
+				return &Float{Value: value}
+			case *Float:
+				return arg
+			case *String:
+				value, err := strconv.ParseFloat(strings.TrimSpace(arg.Value), 64)
+				if err != nil {
+					return newError("could not parse %q as FLOAT", arg.Value)
+				}
+				return &Float{Value: value}
+			default:
+				return newError("argument to `float` is not supported, got %s", args[0].Type())
+			}
+		},
+		},
+	},
+	{
+		// round(x) rounds half away from zero to an integer, round(x, n)
+		// rounds to a float with n digits after the decimal point
+		"round",
+		&Builtin{Fn: func(ctx *Context, args ...Object) Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=1 or 2", len(args))
+			}
+
+			value, ok := ToFloat(args[0])
+			if !ok {
+				return newError("argument to `round` must be INTEGER or FLOAT, got %s", args[0].Type())
+			}
+
+			if len(args) == 1 {
+				if IsInteger(args[0]) {
+					return args[0]
+				}
+				if integer, ok := FloatToInteger(math.Round(value)); ok {
+					return integer
+				}
+				return newError("cannot convert %s to INTEGER", args[0].Inspect())
+			}
+
+			digits, ok := args[1].(*Integer)
+			if !ok || digits.Value < 0 {
+				return newError("number of digits to `round` must be a non-negative INTEGER, got %s", args[1].Inspect())
+			}
+			// formatting rounds the exact binary value, which avoids the error
+			// scaling by a power of ten would add
+			rounded, _ := strconv.ParseFloat(strconv.FormatFloat(value, 'f', int(digits.Value), 64), 64)
+			return &Float{Value: rounded}
+		},
+		},
+	},
+	{
+		// bytes(s) returns the UTF-8 encoding of s as an array of integers
+		"bytes",
+		&Builtin{Fn: func(ctx *Context, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			str, ok := args[0].(*String)
+			if !ok {
+				return newError("argument to `bytes` must be STRING, got %s", args[0].Type())
+			}
+
+			elements := make([]Object, len(str.Value))
+			for i := 0; i < len(str.Value); i++ {
+				elements[i] = &Integer{Value: int64(str.Value[i])}
+			}
+			return ctx.Allocate(&Array{Elements: elements})
+		},
+		},
+	},
+	{
+		// runes(s) returns the code points of s as an array of integers
+		"runes",
+		&Builtin{Fn: func(ctx *Context, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			str, ok := args[0].(*String)
+			if !ok {
+				return newError("argument to `runes` must be STRING, got %s", args[0].Type())
+			}
+
+			elements := []Object{}
+			for _, r := range str.Value {
+				elements = append(elements, &Integer{Value: int64(r)})
+			}
+			return ctx.Allocate(&Array{Elements: elements})
+		},
+		},
+	},
+	{
+		// print writes its arguments without a newline, puts writes each on its own line
+		"print",
+		&Builtin{Fn: func(ctx *Context, args ...Object) Object {
+			return write(ctx.Stdout, "print", args)
+		},
+		},
+	},
+	{
+		"eprint",
+		&Builtin{Fn: func(ctx *Context, args ...Object) Object {
+			return write(ctx.Stderr, "eprint", args)
+		},
+		},
+	},
+	{
+		// readline returns the next line of input without its line ending,
+		// or null once the input is exhausted
+		"readline",
+		&Builtin{Fn: func(ctx *Context, args ...Object) Object {
+			if len(args) != 0 {
+				return newError("wrong number of arguments. got=%d, want=0", len(args))
+			}
+
+			line, err := ctx.Stdin.ReadString('\n')
+			if err != nil && err != io.EOF {
+				return newError("readline: %s", err)
+			}
+			if err == io.EOF && line == "" {
+				return NULL
+			}
+			line = strings.TrimSuffix(line, "\n")
+			return ctx.Allocate(&String{Value: strings.TrimSuffix(line, "\r")})
+		},
+		},
+	},
+	{
+		"read_all",
+		&Builtin{Fn: func(ctx *Context, args ...Object) Object {
+			if len(args) != 0 {
+				return newError("wrong number of arguments. got=%d, want=0", len(args))
+			}
+
+			input, err := ioutil.ReadAll(ctx.Stdin)
+			if err != nil {
+				return newError("read_all: %s", err)
+			}
+			return ctx.Allocate(&String{Value: string(input)})
+		},
+		},
+	},
+}
+
+//write writes the Inspect output of args to w for the builtin called name
+func write(w io.Writer, name string, args []Object) Object {
+	for _, arg := range args {
+		if _, err := io.WriteString(w, arg.Inspect()); err != nil {
+			return newError("%s: %s", name, err)
+		}
+	}
+	return NULL
+}
+
+var standardBuiltins = map[string]*Builtin{}
+
+func init() {
+	for _, def := range Builtins {
+		standardBuiltins[def.Name] = def.Builtin
+	}
+}
+
+//StandardBuiltins returns a new table of the standard builtins by name, for a
+//host to extend with its own functions
+func StandardBuiltins() map[string]*Builtin {
+	builtins := make(map[string]*Builtin, len(Builtins))
+	for _, def := range Builtins {
+		builtins[def.Name] = def.Builtin
+	}
+	return builtins
+}
+
+func GetBuiltinByName(name string) *Builtin {
+	for _, def := range Builtins {
+		if def.Name == name {
+			return def.Builtin
+		}
+	}
+	return nil
+}
+
+func newError(format string, a ...interface{}) *Error {
+	return &Error{Message: fmt.Sprintf(format, a...)}
+}
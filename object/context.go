@@ -0,0 +1,55 @@
+package object
+
+import (
+	"bufio"
+	"io"
+	"os"
+)
+
+//Context is what a builtin can reach of the host running the program: the
+//streams the program writes its output to and reads its input from, and the
+//budget the memory it allocates is charged to
+type Context struct {
+	Stdout io.Writer
+	Stderr io.Writer
+	Stdin  *bufio.Reader // buffered so readline can read one line at a time without losing the rest
+
+	budget *Budget // nil when allocations are not accounted for
+}
+
+//NewContext returns a context using the given streams
+func NewContext(stdin io.Reader, stdout, stderr io.Writer) *Context {
+	reader, ok := stdin.(*bufio.Reader)
+	if !ok {
+		reader = bufio.NewReader(stdin)
+	}
+	return &Context{Stdout: stdout, Stderr: stderr, Stdin: reader}
+}
+
+//WithBudget returns a copy of c whose builtins charge what they allocate to budget
+func (c *Context) WithBudget(budget *Budget) *Context {
+	bound := *c
+	bound.budget = budget
+	return &bound
+}
+
+//Allocate charges obj, a value a builtin has just built, to the budget of the
+//program calling it. It returns obj, or the error to return instead once the
+//memory limit is exceeded.
+func (c *Context) Allocate(obj Object) Object {
+	if err := c.charge(SizeOf(obj)); err != nil {
+		return err
+	}
+	return obj
+}
+
+func (c *Context) charge(bytes int64) *Error {
+	if c.budget == nil {
+		return nil
+	}
+	return c.budget.Charge(bytes)
+}
+
+//DefaultContext is used by programs whose host did not set a context, it
+//uses the standard streams of the process
+var DefaultContext = NewContext(os.Stdin, os.Stdout, os.Stderr)
@@ -1,21 +1,78 @@
 package object
 
+import "context"
+
 type Environment struct {
-	store map[string]Object
-	outer *Environment
+	store  map[string]Object
+	slots  []Object // the variables of a function call, which have no store
+	outer  *Environment
+	global *globalState // shared by a global environment and every environment enclosed in it
+}
+
+//globalState is what a program sees of its host, whichever environment it runs in
+type globalState struct {
+	builtins map[string]*Builtin // nil means the standard builtins
+	ctx      *Context            // nil means DefaultContext
+	budget   *Budget             // created on first use if the host set none
+	bound    *Context            // ctx bound to budget, created on first use
 }
 
 func NewEnvironment() *Environment {
 	s := make(map[string]Object)
-	return &Environment{store: s, outer: nil}
+	return &Environment{store: s, outer: nil, global: &globalState{}}
 }
 
-func NewEnclosedEnvironment(outer *Environment) *Environment {
+//NewEnvironmentWithBuiltins returns a global environment whose programs see
+//the given builtins instead of the standard ones
+func NewEnvironmentWithBuiltins(builtins map[string]*Builtin) *Environment {
 	env := NewEnvironment()
-	env.outer = outer
+	env.global.builtins = builtins
 	return env
 }
 
+func NewEnclosedEnvironment(outer *Environment) *Environment {
+	s := make(map[string]Object)
+	return &Environment{store: s, outer: outer, global: outer.global}
+}
+
+//NewFunctionEnvironment returns the environment of a function call enclosed
+//in outer, whose variables live in the given number of slots assigned by the
+//resolver rather than by name
+func NewFunctionEnvironment(outer *Environment, slots int) *Environment {
+	return &Environment{slots: make([]Object, slots), outer: outer, global: outer.global}
+}
+
+//Slot returns the variable in the given slot of the function environment
+//depth levels out from e, nil if it is not set yet
+func (e *Environment) Slot(depth, slot int) Object {
+	for ; depth > 0; depth-- {
+		e = e.outer
+	}
+	return e.slots[slot]
+}
+
+//NumSlots returns the number of slots of e
+func (e *Environment) NumSlots() int { return len(e.slots) }
+
+//ReserveSlots gives e at least n slots, keeping the variables in those it has
+func (e *Environment) ReserveSlots(n int) {
+	if n > len(e.slots) {
+		e.slots = append(e.slots, make([]Object, n-len(e.slots))...)
+	}
+}
+
+//SetSlot sets the variable in the given slot of the function environment
+//depth levels out from e
+func (e *Environment) SetSlot(depth, slot int, val Object) Object {
+	for ; depth > 0; depth-- {
+		e = e.outer
+	}
+	e.slots[slot] = val
+	return val
+}
+
+//Get looks name up by name, skipping function environments, whose variables
+//are only reachable through their slots
 func (e *Environment) Get(name string) (Object, bool) {
 	obj, ok := e.store[name]
 	if !ok && e.outer != nil {
@@ -28,3 +85,63 @@ func (e *Environment) Set(name string, val Object) Object {
 	e.store[name] = val
 	return val
 }
+
+//Assign rebinds name in the innermost environment that already binds it and
+//reports false, leaving every environment unchanged, if none does
+func (e *Environment) Assign(name string, val Object) (Object, bool) {
+	for env := e; env != nil; env = env.outer {
+		if _, ok := env.store[name]; ok {
+			env.store[name] = val
+			return val, true
+		}
+	}
+	return nil, false
+}
+
+//Builtin looks name up in the builtins of the global environment e belongs to
+func (e *Environment) Builtin(name string) (*Builtin, bool) {
+	if e.global.builtins == nil {
+		builtin, ok := standardBuiltins[name]
+		return builtin, ok
+	}
+	builtin, ok := e.global.builtins[name]
+	return builtin, ok
+}
+
+//Context returns the context of the global environment e belongs to, bound
+//to its budget so that builtins charge what they allocate to the program
+func (e *Environment) Context() *Context {
+	if e.global.bound == nil {
+		ctx := e.global.ctx
+		if ctx == nil {
+			ctx = DefaultContext
+		}
+		e.global.bound = ctx.WithBudget(e.Budget())
+	}
+	return e.global.bound
+}
+
+//SetContext sets the context of the global environment e belongs to
+func (e *Environment) SetContext(ctx *Context) {
+	e.global.ctx = ctx
+	e.global.bound = nil
+}
+
+//Budget returns the budget programs running in the global environment e
+//belongs to are charged to. Without one set by the host, programs only have
+//the default call depth limit.
+func (e *Environment) Budget() *Budget {
+	if e.global.budget == nil {
+		e.global.budget = NewBudget(context.Background(), Limits{})
+	}
+	return e.global.budget
+}
+
+//SetBudget sets the budget of the global environment e belongs to and returns
+//the previous one, nil if there was none
+func (e *Environment) SetBudget(budget *Budget) *Budget {
+	previous := e.global.budget
+	e.global.budget = budget
+	e.global.bound = nil
+	return previous
+}
@@ -0,0 +1,66 @@
+package object
+
+//Equal reports whether two values are equal under ==. Numbers compare by
+//value across integers and floats, strings, booleans and null by value, and
+//arrays and hashes element by element. Any other value, such as a function,
+//is only equal to itself. Arrays and hashes that contain themselves compare
+//without recursing forever.
+func Equal(left, right Object) bool {
+	return equal(left, right, make(map[[2]Object]bool))
+}
+
+//equal compares left and right, seen holding the arrays and hashes already
+//being compared further up; meeting such a pair again is taken as equal, any
+//difference is found where they were first compared
+func equal(left, right Object, seen map[[2]Object]bool) bool {
+	switch {
+	case IsInteger(left) && IsInteger(right):
+		return IntegerInfix("==", left, right) == TRUE
+	case IsNumber(left) && IsNumber(right):
+		return FloatInfix("==", left, right) == TRUE
+	}
+
+	switch l := left.(type) {
+	case *String:
+		r, ok := right.(*String)
+		return ok && l.Value == r.Value
+	case *Boolean:
+		r, ok := right.(*Boolean)
+		return ok && l.Value == r.Value
+	case *Null:
+		_, ok := right.(*Null)
+		return ok
+	case *Array:
+		r, ok := right.(*Array)
+		if !ok || len(l.Elements) != len(r.Elements) {
+			return false
+		}
+		if l == r || seen[[2]Object{l, r}] {
+			return true
+		}
+		seen[[2]Object{l, r}] = true
+		for i := range l.Elements {
+			if !equal(l.Elements[i], r.Elements[i], seen) {
+				return false
+			}
+		}
+		return true
+	case *Hash:
+		r, ok := right.(*Hash)
+		if !ok || len(l.Pairs) != len(r.Pairs) {
+			return false
+		}
+		if l == r || seen[[2]Object{l, r}] {
+			return true
+		}
+		seen[[2]Object{l, r}] = true
+		for key, pair := range l.Pairs {
+			other, ok := r.Pairs[key]
+			if !ok || !equal(pair.Value, other.Value, seen) {
+				return false
+			}
+		}
+		return true
+	}
+	return left == right
+}
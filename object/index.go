@@ -0,0 +1,29 @@
+package object
+
+//SetIndex stores value at index in an array or under the key index in a hash,
+//changing the collection in place. It returns value, or an error if the
+//collection cannot be assigned to or the index is invalid. Both the evaluator
+//and the vm use it so that index assignment behaves the same in each.
+func SetIndex(collection, index, value Object) Object {
+	switch collection := collection.(type) {
+	case *Array:
+		idx, ok := index.(*Integer)
+		if !ok {
+			return newError("array index must be INTEGER, got %s", index.Type())
+		}
+		if idx.Value < 0 || idx.Value >= int64(len(collection.Elements)) {
+			return newError("index out of range: %d with length %d", idx.Value, len(collection.Elements))
+		}
+		collection.Elements[idx.Value] = value
+		return value
+	case *Hash:
+		key, ok := index.(Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+		collection.Pairs[key.HashKey()] = HashPair{Key: index, Value: value}
+		return value
+	default:
+		return newError("index assignment not supported: %s", collection.Type())
+	}
+}
@@ -0,0 +1,269 @@
+package object
+
+import (
+	"math"
+	"math/big"
+)
+
+//IsNumber reports whether obj is an integer, a big integer or a float
+func IsNumber(obj Object) bool {
+	switch obj.(type) {
+	case *Integer, *BigInt, *Float:
+		return true
+	default:
+		return false
+	}
+}
+
+//IsInteger reports whether obj is an integer or a big integer
+func IsInteger(obj Object) bool {
+	switch obj.(type) {
+	case *Integer, *BigInt:
+		return true
+	default:
+		return false
+	}
+}
+
+//NewBigInteger returns an Integer if v fits in int64 and a BigInt otherwise
+func NewBigInteger(v *big.Int) Object {
+	if v.IsInt64() {
+		return &Integer{Value: v.Int64()}
+	}
+	return &BigInt{Value: v}
+}
+
+func toBig(obj Object) *big.Int {
+	switch obj := obj.(type) {
+	case *Integer:
+		return big.NewInt(obj.Value)
+	case *BigInt:
+		return obj.Value
+	default:
+		return nil
+	}
+}
+
+//ToFloat returns the value of a number as a float64
+func ToFloat(obj Object) (float64, bool) {
+	switch obj := obj.(type) {
+	case *Integer:
+		return float64(obj.Value), true
+	case *BigInt:
+		f, _ := new(big.Float).SetInt(obj.Value).Float64()
+		return f, true
+	case *Float:
+		return obj.Value, true
+	default:
+		return 0, false
+	}
+}
+
+//IntegerInfix applies an arithmetic or comparison operator to two integers.
+//Arithmetic that overflows int64 is redone with math/big and gives a BigInt.
+//Division truncates towards zero and the remainder has the sign of the
+//dividend, as in Go.
+func IntegerInfix(operator string, left, right Object) Object {
+	if err := checkDivisor(operator, right); err != nil {
+		return err
+	}
+
+	if l, ok := left.(*Integer); ok {
+		if r, ok := right.(*Integer); ok {
+			if result, ok := smallIntegerInfix(operator, l.Value, r.Value); ok {
+				return result
+			}
+		}
+	}
+
+	leftVal, rightVal := toBig(left), toBig(right)
+	if leftVal == nil || rightVal == nil {
+		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
+	}
+
+	switch operator {
+	case "+":
+		return NewBigInteger(new(big.Int).Add(leftVal, rightVal))
+	case "-":
+		return NewBigInteger(new(big.Int).Sub(leftVal, rightVal))
+	case "*":
+		return NewBigInteger(new(big.Int).Mul(leftVal, rightVal))
+	case "/":
+		return NewBigInteger(new(big.Int).Quo(leftVal, rightVal))
+	case "%":
+		return NewBigInteger(new(big.Int).Rem(leftVal, rightVal))
+	case "<":
+		return nativeBool(leftVal.Cmp(rightVal) < 0)
+	case ">":
+		return nativeBool(leftVal.Cmp(rightVal) > 0)
+	case "<=":
+		return nativeBool(leftVal.Cmp(rightVal) <= 0)
+	case ">=":
+		return nativeBool(leftVal.Cmp(rightVal) >= 0)
+	case "==":
+		return nativeBool(leftVal.Cmp(rightVal) == 0)
+	case "!=":
+		return nativeBool(leftVal.Cmp(rightVal) != 0)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+//smallIntegerInfix is the int64 fast path of IntegerInfix. It reports false if
+//the result overflows or the operator is not handled.
+func smallIntegerInfix(operator string, l, r int64) (Object, bool) {
+	switch operator {
+	case "+":
+		result := l + r
+		if (l > 0 && r > 0 && result < 0) || (l < 0 && r < 0 && result >= 0) {
+			return nil, false
+		}
+		return &Integer{Value: result}, true
+	case "-":
+		result := l - r
+		if (l >= 0 && r < 0 && result < 0) || (l < 0 && r > 0 && result >= 0) {
+			return nil, false
+		}
+		return &Integer{Value: result}, true
+	case "*":
+		if l == 0 || r == 0 {
+			return &Integer{Value: 0}, true
+		}
+		result := l * r
+		if result/r != l || (l == -1 && r == math.MinInt64) || (r == -1 && l == math.MinInt64) {
+			return nil, false
+		}
+		return &Integer{Value: result}, true
+	case "/":
+		if l == math.MinInt64 && r == -1 {
+			return nil, false
+		}
+		return &Integer{Value: l / r}, true
+	case "%":
+		return &Integer{Value: l % r}, true
+	case "<":
+		return nativeBool(l < r), true
+	case ">":
+		return nativeBool(l > r), true
+	case "<=":
+		return nativeBool(l <= r), true
+	case ">=":
+		return nativeBool(l >= r), true
+	case "==":
+		return nativeBool(l == r), true
+	case "!=":
+		return nativeBool(l != r), true
+	default:
+		return nil, false
+	}
+}
+
+//Negate returns -obj for a number, promoting the negation of the smallest
+//int64 to a BigInt
+func Negate(obj Object) Object {
+	switch obj := obj.(type) {
+	case *Integer:
+		if obj.Value == math.MinInt64 {
+			return NewBigInteger(new(big.Int).Neg(big.NewInt(obj.Value)))
+		}
+		return &Integer{Value: -obj.Value}
+	case *BigInt:
+		return NewBigInteger(new(big.Int).Neg(obj.Value))
+	case *Float:
+		return &Float{Value: -obj.Value}
+	default:
+		return newError("unknown operator: -%s", obj.Type())
+	}
+}
+
+//FloatInfix applies an arithmetic or comparison operator to two numbers of
+//which at least one is a float, promoting the other one to float. Dividing a
+//float by zero follows IEEE 754 and gives an infinity or NaN.
+func FloatInfix(operator string, left, right Object) Object {
+	if operator == "%" {
+		if err := checkDivisor(operator, right); err != nil {
+			return err
+		}
+	}
+
+	leftVal, ok := ToFloat(left)
+	if !ok {
+		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
+	}
+	rightVal, ok := ToFloat(right)
+	if !ok {
+		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
+	}
+
+	switch operator {
+	case "+":
+		return &Float{Value: leftVal + rightVal}
+	case "-":
+		return &Float{Value: leftVal - rightVal}
+	case "*":
+		return &Float{Value: leftVal * rightVal}
+	case "/":
+		return &Float{Value: leftVal / rightVal}
+	case "%":
+		return &Float{Value: math.Mod(leftVal, rightVal)}
+	case "<":
+		return nativeBool(leftVal < rightVal)
+	case ">":
+		return nativeBool(leftVal > rightVal)
+	case "<=":
+		return nativeBool(leftVal <= rightVal)
+	case ">=":
+		return nativeBool(leftVal >= rightVal)
+	case "==":
+		return nativeBool(leftVal == rightVal)
+	case "!=":
+		return nativeBool(leftVal != rightVal)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+//FloatToInteger truncates f towards zero, giving a BigInt beyond the int64
+//range. It fails for NaN and infinities, which have no integer equivalent.
+func FloatToInteger(f float64) (Object, bool) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, false
+	}
+	if f >= math.MinInt64 && f < math.MaxInt64 {
+		return &Integer{Value: int64(f)}, true
+	}
+	v, _ := big.NewFloat(f).Int(nil)
+	return NewBigInteger(v), true
+}
+
+//checkDivisor returns an error if operator divides by a divisor of zero
+func checkDivisor(operator string, divisor Object) *Error {
+	if operator != "/" && operator != "%" {
+		return nil
+	}
+
+	var zero bool
+	switch divisor := divisor.(type) {
+	case *Integer:
+		zero = divisor.Value == 0
+	case *BigInt:
+		zero = divisor.Value.Sign() == 0
+	case *Float:
+		zero = divisor.Value == 0
+	}
+	if !zero {
+		return nil
+	}
+
+	if operator == "%" {
+		return newError("modulo by zero")
+	}
+	return newError("division by zero")
+}
+
+func nativeBool(b bool) *Boolean {
+	if b {
+		return TRUE
+	}
+	return FALSE
+}
@@ -5,6 +5,12 @@ import (
 	"fmt"
 	"hash/fnv"
 	"interpreter/ast"
+	"interpreter/code"
+	"interpreter/token"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -17,15 +23,29 @@ type Object interface {
 
 const (
 	INTEGER_OBJ      = "INTEGER"
+	BIGINT_OBJ       = "BIGINT"
+	FLOAT_OBJ        = "FLOAT"
 	BOOLEAN_OBJ      = "BOOLEAN"
 	NUL_OBJ          = "NULL"
 	RETURN_VALUE_OBJ = "RETURN_VALUE"
+	BREAK_OBJ        = "BREAK"
+	CONTINUE_OBJ     = "CONTINUE"
 	ERROR_OBJ        = "ERROR"
+	ERROR_VALUE_OBJ  = "ERROR_VALUE"
 	FUNCTON_OBJ      = "FUNCTION"
 	STRING_OBJ       = "STRING"
 	BUILTIN_OBJ      = "BUILTIN"
 	ARRAY_OBJ        = "ARRAY"
 	HASH_OBJ	 	 = "HASH"
+
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
+)
+
+//there is only ever one null, true and false object so they can be compared by identity
+var (
+	NULL  = &Null{}
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
 )
 
 type Integer struct {
@@ -35,6 +55,30 @@ type Integer struct {
 func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) }
 func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 
+//BigInt holds the result of integer arithmetic that overflows int64. Values
+//that fit in int64 are always represented by Integer instead.
+type BigInt struct {
+	Value *big.Int
+}
+
+func (b *BigInt) Inspect() string  { return b.Value.String() }
+func (b *BigInt) Type() ObjectType { return BIGINT_OBJ }
+
+type Float struct {
+	Value float64
+}
+
+//Inspect returns the shortest representation that parses back to the same
+//float, keeping a fraction or exponent so that it also parses back as a float
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
 type Boolean struct {
 	Value bool
 }
@@ -54,17 +98,168 @@ type ReturnValue struct {
 func (r *ReturnValue) Inspect() string  { return r.Value.Inspect() }
 func (r *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 
+//Break and Continue are produced by break and continue statements and unwind
+//the enclosing blocks up to the innermost loop, the way ReturnValue unwinds
+//up to the function call
+type Break struct{}
+
+func (b *Break) Inspect() string  { return "break" }
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+
+type Continue struct{}
+
+func (c *Continue) Inspect() string  { return "continue" }
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+
 type Error struct {
 	Message string
+	Pos     token.Position // where the error was raised, unknown for errors built outside the evaluator
+	Kind    ErrorKind
+	Frames  []Frame // the function calls in progress when the error was raised, innermost first
+}
+
+//Frame is a function call that was in progress when an error was raised
+type Frame struct {
+	Function string         // the name the function was called through, empty if it was not called by name
+	Pos      token.Position // where it was called, unknown for a call made by the host
+	Args     []ObjectType   // the types of the arguments
+}
+
+//String renders the frame as a line of a traceback
+func (f Frame) String() string {
+	name := f.Function
+	if name == "" {
+		name = "fn"
+	}
+
+	args := make([]string, len(f.Args))
+	for i, arg := range f.Args {
+		args[i] = string(arg)
+	}
+
+	s := name + "(" + strings.Join(args, ", ") + ")"
+	if f.Pos.IsValid() {
+		s += " called at " + f.Pos.String()
+	}
+	return s
+}
+
+//traceEnds is how many frames are shown at either end of a long traceback,
+//leaving out the middle of for instance a runaway recursion
+const traceEnds = 10
+
+//ErrorKind tells errors that stop a program for exceeding its budget apart
+//from errors in the program itself
+type ErrorKind string
+
+const (
+	RuntimeError        ErrorKind = ""            // an error raised by an operation of the program or a builtin
+	ThrownError         ErrorKind = "ThrownError" // an error raised by a throw statement
+	StepLimitExceeded   ErrorKind = "StepLimitExceeded"
+	CallDepthExceeded   ErrorKind = "CallDepthExceeded"
+	Timeout             ErrorKind = "Timeout"
+	Cancelled           ErrorKind = "Cancelled"
+	MemoryLimitExceeded ErrorKind = "MemoryLimitExceeded"
+)
+
+//String returns the name of the kind, which programs see as the kind of a
+//caught error
+func (k ErrorKind) String() string {
+	if k == RuntimeError {
+		return "RuntimeError"
+	}
+	return string(k)
+}
+
+//IsLimit reports whether the error was raised because the program exceeded
+//one of its limits rather than by the program itself. Such errors cannot be
+//caught by the program.
+func (e *Error) IsLimit() bool { return e.Kind != RuntimeError && e.Kind != ThrownError }
+
+//Inspect returns the message followed by a traceback of the calls that were
+//in progress, one per line
+func (e *Error) Inspect() string {
+	var out bytes.Buffer
+
+	if e.Pos.IsValid() {
+		out.WriteString("ERROR " + e.Pos.String() + ": " + e.Message)
+	} else {
+		out.WriteString("ERROR: " + e.Message)
+	}
+
+	for i, frame := range e.Frames {
+		if len(e.Frames) > 2*traceEnds && i >= traceEnds && i < len(e.Frames)-traceEnds {
+			if i == traceEnds {
+				fmt.Fprintf(&out, "\n  ... %d more calls", len(e.Frames)-2*traceEnds)
+			}
+			continue
+		}
+		out.WriteString("\n  in " + frame.String())
+	}
+	return out.String()
 }
 
-func (e *Error) Inspect() string  { return "ERROR" + e.Message }
+//Error makes runtime errors usable as Go errors by hosts and by the vm
+func (e *Error) Error() string {
+	if e.Pos.IsValid() {
+		return e.Pos.String() + ": " + e.Message
+	}
+	return e.Message
+}
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
 
+//ErrorValue is an error caught by a try expression. Unlike an Error, which
+//unwinds the program, it is an ordinary value the program can store, inspect
+//and throw again.
+type ErrorValue struct {
+	Err *Error
+}
+
+func (ev *ErrorValue) Type() ObjectType { return ERROR_VALUE_OBJ }
+func (ev *ErrorValue) Inspect() string  { return ev.Err.Inspect() }
+
+//Field returns the field of the error called name: its message, its kind, or
+//its trace as an array of lines. Any other name gives null.
+func (ev *ErrorValue) Field(name Object) Object {
+	str, ok := name.(*String)
+	if !ok {
+		return NULL
+	}
+
+	switch str.Value {
+	case "message":
+		return &String{Value: ev.Err.Message}
+	case "kind":
+		return &String{Value: ev.Err.Kind.String()}
+	case "trace":
+		lines := make([]Object, len(ev.Err.Frames))
+		for i, frame := range ev.Err.Frames {
+			lines[i] = &String{Value: frame.String()}
+		}
+		return &Array{Elements: lines}
+	default:
+		return NULL
+	}
+}
+
+//Throw returns the error a throw statement raises for value. A caught error
+//is raised again as it was, keeping its kind and trace, anything else becomes
+//a ThrownError whose message is value, or its Inspect output if it is not a
+//string.
+func Throw(value Object) *Error {
+	if caught, ok := value.(*ErrorValue); ok {
+		err := *caught.Err
+		err.Frames = append([]Frame(nil), caught.Err.Frames...)
+		return &err
+	}
+	return &Error{Message: value.Inspect(), Kind: ThrownError}
+}
+
 type Function struct {
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
+	Slots      int // the size of the environment of a call
 }
 
 func (f *Function) Type() ObjectType { return FUNCTON_OBJ }
@@ -93,7 +288,8 @@ type String struct {
 func (s *String) Type() ObjectType { return STRING_OBJ }
 func (s *String) Inspect() string  { return s.Value }
 
-type BuiltinFunction func(args ...Object) Object
+//BuiltinFunction implements a builtin, ctx gives it the streams of the program calling it
+type BuiltinFunction func(ctx *Context, args ...Object) Object
 
 type Builtin struct {
 	Fn BuiltinFunction
@@ -107,13 +303,21 @@ type Array struct {
 }
 
 func (a *Array) Type() ObjectType {return ARRAY_OBJ}
-func (a *Array) Inspect() string {
+func (a *Array) Inspect() string  { return a.inspect(make(map[Object]bool)) }
+
+func (a *Array) inspect(seen map[Object]bool) string {
+	if seen[a] {
+		return "[...]"
+	}
+	seen[a] = true
+	defer delete(seen, a)
+
 	var out bytes.Buffer
 
 	elements := []string{}
 
 	for _, e := range a.Elements {
-		elements = append(elements,e.Inspect())
+		elements = append(elements, inspect(e, seen))
 	}
 	out.WriteString("[")
 	out.WriteString(strings.Join(elements, ", "))
@@ -122,6 +326,46 @@ func (a *Array) Inspect() string {
 }
 
 
+//inspect prints an element of an array or hash, seen holding the arrays and
+//hashes being printed further up so that one containing itself prints as
+//[...] or {...} there rather than recursing forever
+func inspect(obj Object, seen map[Object]bool) string {
+	switch obj := obj.(type) {
+	case *Array:
+		return obj.inspect(seen)
+	case *Hash:
+		return obj.inspect(seen)
+	}
+	return obj.Inspect()
+}
+
+type CompiledFunction struct {
+	Instructions  code.Instructions
+	SourceMap     code.SourceMap
+	NumLocals     int
+	NumParameters int
+	Name          string   // the name the function was bound to with let, if any
+	LocalNames    []string // names of the local slots, used in runtime error messages
+	FreeNames     []string // names of the free variables, used in runtime error messages
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+//Closure is the vm's function value, it reports the same type as Function so
+//both engines behave the same from the program's point of view
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object
+}
+
+func (c *Closure) Type() ObjectType { return FUNCTON_OBJ }
+func (c *Closure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", c)
+}
+
 type HashKey struct {
 	Type ObjectType
 	Value uint64
@@ -137,13 +381,21 @@ type Hash struct {
 }
 
 func (h *Hash) Type() ObjectType { return HASH_OBJ}
-func (h *Hash) Inspect() string{
+func (h *Hash) Inspect() string  { return h.inspect(make(map[Object]bool)) }
+
+func (h *Hash) inspect(seen map[Object]bool) string {
+	if seen[h] {
+		return "{...}"
+	}
+	seen[h] = true
+	defer delete(seen, h)
+
 	var out bytes.Buffer
 
 	pairs := []string{}
 
-	for _,pair := range h.Pairs {
-		pairs = append(pairs, fmt.Sprintf("%s: %s",pair.Key.Inspect(),pair.Value.Inspect()))
+	for _, pair := range h.OrderedPairs() {
+		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), inspect(pair.Value, seen)))
 	}
 
 	out.WriteString("{")
@@ -153,6 +405,42 @@ func (h *Hash) Inspect() string{
 	return out.String()
 }
 
+//OrderedPairs returns the pairs sorted by key, so that printing and iterating
+//over a hash do not depend on Go's map order. Keys are grouped by type, and
+//ordered by value within a type.
+func (h *Hash) OrderedPairs() []HashPair {
+	pairs := make([]HashPair, 0, len(h.Pairs))
+	for _, pair := range h.Pairs {
+		pairs = append(pairs, pair)
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		return keyLess(pairs[i].Key, pairs[j].Key)
+	})
+	return pairs
+}
+
+func keyLess(a, b Object) bool {
+	if a.Type() != b.Type() {
+		return a.Type() < b.Type()
+	}
+
+	switch a := a.(type) {
+	case *Integer:
+		return a.Value < b.(*Integer).Value
+	case *BigInt:
+		return a.Value.Cmp(b.(*BigInt).Value) < 0
+	case *Float:
+		return a.Value < b.(*Float).Value
+	case *String:
+		return a.Value < b.(*String).Value
+	case *Boolean:
+		return !a.Value && b.(*Boolean).Value
+	default:
+		return a.Inspect() < b.Inspect()
+	}
+}
+
 func(b *Boolean) HashKey() HashKey {
 	var value uint64
 
@@ -167,6 +455,29 @@ func(i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(),Value: uint64(i.Value)}
 }
 
+//HashKey of a float with an integral value is the key of the equal integer,
+//so that 1.0 and 1 find the same entry just as 1.0 == 1
+func (f *Float) HashKey() HashKey {
+	if f.Value == math.Trunc(f.Value) {
+		if integer, ok := FloatToInteger(f.Value); ok {
+			return integer.(Hashable).HashKey()
+		}
+	}
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
+
+//HashKey of a BigInt that fits in int64 is the key of the equal Integer
+func (b *BigInt) HashKey() HashKey {
+	if b.Value.IsInt64() {
+		return (&Integer{Value: b.Value.Int64()}).HashKey()
+	}
+
+	h := fnv.New64()
+	h.Write([]byte{byte(b.Value.Sign() + 1)})
+	h.Write(b.Value.Bytes())
+	return HashKey{Type: b.Type(), Value: h.Sum64()}
+}
+
 func (s *String) HashKey() HashKey {
 	h := fnv.New64()
 	h.Write([]byte(s.Value))
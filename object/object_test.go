@@ -1,6 +1,11 @@
 package object
 
-import "testing"
+import (
+	"math/big"
+	"strconv"
+	"strings"
+	"testing"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -21,3 +26,99 @@ func TestStringHashKey(t *testing.T) {
 	}
 }
 
+
+func TestFloatInspectRoundTrips(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{3.14, "3.14"},
+		{2, "2.0"},
+		{-0.5, "-0.5"},
+		{1e-9, "1e-09"},
+		{1e21, "1e+21"},
+		{0.30000000000000004, "0.30000000000000004"},
+	}
+
+	for _, tt := range tests {
+		inspected := (&Float{Value: tt.value}).Inspect()
+		if inspected != tt.expected {
+			t.Errorf("wrong Inspect for %v. expected=%q, got=%q", tt.value, tt.expected, inspected)
+			continue
+		}
+		parsed, err := strconv.ParseFloat(inspected, 64)
+		if err != nil || parsed != tt.value {
+			t.Errorf("%q does not parse back to %v", inspected, tt.value)
+		}
+	}
+}
+
+func TestFloatHashKey(t *testing.T) {
+	if (&Float{Value: 2}).HashKey() != (&Integer{Value: 2}).HashKey() {
+		t.Errorf("integral float and equal integer have different hash keys")
+	}
+	if (&Float{Value: 2.5}).HashKey() != (&Float{Value: 2.5}).HashKey() {
+		t.Errorf("floats with same value have different hash keys")
+	}
+	if (&Float{Value: 2.5}).HashKey() == (&Float{Value: 3.5}).HashKey() {
+		t.Errorf("floats with different values have same hash")
+	}
+}
+
+func TestBigIntHashKey(t *testing.T) {
+	small := &BigInt{Value: big.NewInt(42)}
+	if small.HashKey() != (&Integer{Value: 42}).HashKey() {
+		t.Errorf("BigInt that fits in int64 and equal Integer have different hash keys")
+	}
+
+	a, _ := new(big.Int).SetString("99999999999999999999", 10)
+	b, _ := new(big.Int).SetString("99999999999999999999", 10)
+	if (&BigInt{Value: a}).HashKey() != (&BigInt{Value: b}).HashKey() {
+		t.Errorf("BigInts with same value have different hash keys")
+	}
+	if (&BigInt{Value: a}).HashKey() == (&BigInt{Value: new(big.Int).Neg(a)}).HashKey() {
+		t.Errorf("BigInts of opposite sign have same hash")
+	}
+	if (&Float{Value: 1e20}).HashKey() != (&BigInt{Value: new(big.Int).Add(a, big.NewInt(1))}).HashKey() {
+		t.Errorf("integral float and equal BigInt have different hash keys")
+	}
+}
+
+func TestErrorInspectShortensLongTracebacks(t *testing.T) {
+	err := &Error{Message: "stack overflow"}
+	for i := 0; i < 25; i++ {
+		err.Frames = append(err.Frames, Frame{Function: "f", Args: []ObjectType{INTEGER_OBJ}})
+	}
+
+	lines := strings.Split(err.Inspect(), "\n")
+	if len(lines) != 1+2*traceEnds+1 {
+		t.Fatalf("wrong number of lines. got=%d:\n%s", len(lines), err.Inspect())
+	}
+	if lines[1+traceEnds] != "  ... 5 more calls" {
+		t.Errorf("wrong elision line. got=%q", lines[1+traceEnds])
+	}
+	if lines[1] != "  in f(INTEGER)" {
+		t.Errorf("wrong frame line. got=%q", lines[1])
+	}
+}
+
+func TestEqualStopsAtCycles(t *testing.T) {
+	a := &Array{}
+	a.Elements = []Object{a}
+	b := &Array{}
+	c := &Array{Elements: []Object{b}}
+	b.Elements = []Object{c}
+
+	if !Equal(a, b) {
+		t.Errorf("arrays nesting themselves to the same depth are not equal")
+	}
+
+	h := &Hash{Pairs: map[HashKey]HashPair{}}
+	key := &String{Value: "self"}
+	h.Pairs[key.HashKey()] = HashPair{Key: key, Value: h}
+	g := &Hash{Pairs: map[HashKey]HashPair{key.HashKey(): {Key: key, Value: &Integer{Value: 1}}}}
+
+	if Equal(h, g) {
+		t.Errorf("hash containing itself equals a hash containing 1")
+	}
+}
@@ -0,0 +1,52 @@
+package object
+
+import "strings"
+
+//StringInfix applies an operator to two strings: + concatenates them and the
+//ordering operators compare them byte by byte
+func StringInfix(operator string, left, right Object) Object {
+	leftVal := left.(*String).Value
+	rightVal := right.(*String).Value
+
+	switch operator {
+	case "+":
+		return &String{Value: leftVal + rightVal}
+	case "<":
+		return nativeBool(leftVal < rightVal)
+	case ">":
+		return nativeBool(leftVal > rightVal)
+	case "<=":
+		return nativeBool(leftVal <= rightVal)
+	case ">=":
+		return nativeBool(leftVal >= rightVal)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+//StringIndex returns the character at the given rune index of a string as a
+//one character string, or NULL if the index is out of range
+func StringIndex(str, index Object) Object {
+	i := index.(*Integer).Value
+	if i < 0 {
+		return NULL
+	}
+
+	for _, r := range str.(*String).Value {
+		if i == 0 {
+			return &String{Value: string(r)}
+		}
+		i--
+	}
+	return NULL
+}
+
+//Interpolate builds the value of an interpolated string by concatenating the
+//Inspect output of its evaluated parts
+func Interpolate(parts []Object) *String {
+	var out strings.Builder
+	for _, part := range parts {
+		out.WriteString(part.Inspect())
+	}
+	return &String{Value: out.String()}
+}
@@ -0,0 +1,362 @@
+package optimizer
+
+import (
+	"interpreter/ast"
+	"interpreter/object"
+	"interpreter/token"
+	"math"
+	"strconv"
+)
+
+//Optimize rewrites program in place so that running it does less work, and
+//returns it. Operators applied to literals are folded into their result, if
+//statements whose condition is a literal are replaced by the branch taken and
+//let bindings of a number or boolean that a function never rebinds are inlined
+//into the rest of the function. Anything that would fail at runtime is left as
+//it is, so an optimized program produces the same values and errors as the
+//original one. Top level bindings are never inlined as later programs and the
+//host may rebind globals.
+func Optimize(program *ast.Program) *ast.Program {
+	o := &optimizer{}
+	program.Statements = o.statements(program.Statements)
+	return program
+}
+
+type optimizer struct {
+	constants map[string]ast.Expression // the let bindings inlined into the code being optimized
+}
+
+func (o *optimizer) statements(statements []ast.Statement) []ast.Statement {
+	result := make([]ast.Statement, 0, len(statements))
+	for i, s := range statements {
+		result = append(result, o.statement(s, i == len(statements)-1)...)
+	}
+	return result
+}
+
+//statement optimizes s, which may be replaced by any number of statements.
+//The value of the last statement of a block is that of the block.
+func (o *optimizer) statement(s ast.Statement, last bool) []ast.Statement {
+	switch s := s.(type) {
+	case *ast.ExpressionStatement:
+		s.Expression = o.expression(s.Expression)
+		if ifExp, ok := s.Expression.(*ast.IfExpression); ok {
+			if branch, ok := o.branchTaken(ifExp); ok && (!last || endsInValue(branch)) && !binds(branch) {
+				return branch.Statements
+			}
+		}
+	case *ast.LetStatement:
+		s.Value = o.expression(s.Value)
+	case *ast.ReturnStatement:
+		s.ReturnValue = o.expression(s.ReturnValue)
+	case *ast.ThrowStatement:
+		s.Value = o.expression(s.Value)
+	case *ast.WhileStatement:
+		s.Condition = o.expression(s.Condition)
+		o.block(s.Body)
+	case *ast.ForInStatement:
+		s.Iterable = o.expression(s.Iterable)
+		o.block(s.Body)
+	}
+	return []ast.Statement{s}
+}
+
+func (o *optimizer) block(block *ast.BlockStatement) {
+	if block != nil {
+		block.Statements = o.statements(block.Statements)
+	}
+}
+
+//branchTaken returns the block an if expression with a literal condition
+//runs, false if its condition is not a literal or it runs none
+func (o *optimizer) branchTaken(ifExp *ast.IfExpression) (*ast.BlockStatement, bool) {
+	condition, ok := value(ifExp.Condition)
+	if !ok {
+		return nil, false
+	}
+	if condition != object.FALSE {
+		return ifExp.Consequence, true
+	}
+	if ifExp.Alternative != nil {
+		return ifExp.Alternative, true
+	}
+	return &ast.BlockStatement{Token: ifExp.Token}, true
+}
+
+//endsInValue reports whether the value of block is that of its last
+//statement, so that the statements can stand in for the block
+func endsInValue(block *ast.BlockStatement) bool {
+	if len(block.Statements) == 0 {
+		return false
+	}
+	_, ok := block.Statements[len(block.Statements)-1].(*ast.ExpressionStatement)
+	return ok
+}
+
+//binds reports whether block makes a let binding of its own, which would
+//become a top level one, hoisted to the start of the program, when the block
+//is replaced by its statements
+func binds(block *ast.BlockStatement) bool {
+	for _, s := range block.Statements {
+		if _, ok := s.(*ast.LetStatement); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *optimizer) expression(e ast.Expression) ast.Expression {
+	switch e := e.(type) {
+	case *ast.Identifier:
+		if constant, ok := o.constants[e.Value]; ok {
+			return relocate(constant, e.Token)
+		}
+	case *ast.PrefixExpression:
+		e.Right = o.expression(e.Right)
+		if folded, ok := foldPrefix(e); ok {
+			return folded
+		}
+	case *ast.InfixExpression:
+		e.Left = o.expression(e.Left)
+		e.Right = o.expression(e.Right)
+		if folded, ok := foldInfix(e); ok {
+			return folded
+		}
+	case *ast.LogicalExpression:
+		e.Left = o.expression(e.Left)
+		e.Right = o.expression(e.Right)
+	case *ast.AssignExpression:
+		if index, ok := e.Target.(*ast.IndexExpression); ok {
+			o.expression(index)
+		}
+		e.Value = o.expression(e.Value)
+	case *ast.IfExpression:
+		e.Condition = o.expression(e.Condition)
+		o.block(e.Consequence)
+		o.block(e.Alternative)
+	case *ast.TryExpression:
+		o.block(e.Block)
+		o.block(e.Catch)
+		o.block(e.Finally)
+	case *ast.BlockStatement:
+		o.block(e)
+	case *ast.FunctionLiteral:
+		o.function(e)
+	case *ast.CallExpression:
+		e.Function = o.expression(e.Function)
+		for i, a := range e.Arguments {
+			e.Arguments[i] = o.expression(a)
+		}
+	case *ast.InterpolatedString:
+		for i, p := range e.Parts {
+			e.Parts[i] = o.expression(p)
+		}
+	case *ast.ArrayLiteral:
+		for i, el := range e.Elements {
+			e.Elements[i] = o.expression(el)
+		}
+	case *ast.IndexExpression:
+		e.Left = o.expression(e.Left)
+		e.Index = o.expression(e.Index)
+	case *ast.HashLiteral:
+		pairs := make(map[ast.Expression]ast.Expression, len(e.Pairs))
+		for k, v := range e.Pairs {
+			pairs[o.expression(k)] = o.expression(v)
+		}
+		e.Pairs = pairs
+	}
+	return e
+}
+
+//function optimizes the body of a function, inlining the constant let
+//bindings made directly in it into the statements that follow them
+func (o *optimizer) function(fl *ast.FunctionLiteral) {
+	declared := make(map[string]int)
+	for _, p := range fl.Parameters {
+		declared[p.Value]++
+	}
+	countDeclarations(fl.Body, declared)
+	assigned := make(map[string]bool)
+	findAssignments(fl.Body, assigned)
+
+	// the variables of the function hide the constants of enclosing ones
+	outer := o.constants
+	o.constants = make(map[string]ast.Expression)
+	for name, constant := range outer {
+		if declared[name] == 0 {
+			o.constants[name] = constant
+		}
+	}
+
+	body := make([]ast.Statement, 0, len(fl.Body.Statements))
+	for i, s := range fl.Body.Statements {
+		body = append(body, o.statement(s, i == len(fl.Body.Statements)-1)...)
+
+		if let, ok := s.(*ast.LetStatement); ok && isConstant(let.Value) {
+			name := let.Name.Value
+			if declared[name] == 1 && !assigned[name] {
+				o.constants[name] = let.Value
+			}
+		}
+	}
+	fl.Body.Statements = body
+
+	o.constants = outer
+}
+
+//countDeclarations counts the bindings of each name made in node, leaving out
+//those of nested functions
+func countDeclarations(node ast.Node, declared map[string]int) {
+	ast.Walk(node, false, func(n ast.Node) {
+		switch n := n.(type) {
+		case *ast.LetStatement:
+			declared[n.Name.Value]++
+		case *ast.ForInStatement:
+			if n.Key != nil {
+				declared[n.Key.Value]++
+			}
+			declared[n.Value.Value]++
+		case *ast.TryExpression:
+			if n.Param != nil {
+				declared[n.Param.Value]++
+			}
+		}
+	})
+}
+
+//findAssignments records the names assigned to anywhere in node, nested
+//functions included as they may assign to a variable of an enclosing one
+func findAssignments(node ast.Node, assigned map[string]bool) {
+	ast.Walk(node, true, func(n ast.Node) {
+		if assign, ok := n.(*ast.AssignExpression); ok {
+			if name, ok := assign.Target.(*ast.Identifier); ok {
+				assigned[name.Value] = true
+			}
+		}
+	})
+}
+
+//isConstant reports whether e is a literal whose value can stand in for a
+//variable bound to it. Strings are left out, each evaluation of a string
+//literal makes a new string.
+func isConstant(e ast.Expression) bool {
+	switch e.(type) {
+	case *ast.IntegerLiteral, *ast.FloatLiteral, *ast.Boolean:
+		return true
+	default:
+		return false
+	}
+}
+
+//relocate returns a copy of the literal e at the position of tok
+func relocate(e ast.Expression, tok token.Token) ast.Expression {
+	switch e := e.(type) {
+	case *ast.IntegerLiteral:
+		return &ast.IntegerLiteral{Token: at(e.Token, tok), Value: e.Value, Big: e.Big}
+	case *ast.FloatLiteral:
+		return &ast.FloatLiteral{Token: at(e.Token, tok), Value: e.Value}
+	case *ast.Boolean:
+		return &ast.Boolean{Token: at(e.Token, tok), Value: e.Value}
+	}
+	return e
+}
+
+func at(literal token.Token, tok token.Token) token.Token {
+	return token.Token{Type: literal.Type, Literal: literal.Literal, Pos: tok.Pos, End: tok.End}
+}
+
+func foldPrefix(node *ast.PrefixExpression) (ast.Expression, bool) {
+	right, ok := value(node.Right)
+	if !ok {
+		return nil, false
+	}
+
+	switch node.Operator {
+	case "!":
+		return literal(boolean(right == object.FALSE), node)
+	case "-":
+		return literal(object.Negate(right), node)
+	}
+	return nil, false
+}
+
+//foldInfix applies the operator of node to its operands when both are
+//literals, taking the same cases in the same order as the evaluator
+func foldInfix(node *ast.InfixExpression) (ast.Expression, bool) {
+	left, ok := value(node.Left)
+	if !ok {
+		return nil, false
+	}
+	right, ok := value(node.Right)
+	if !ok {
+		return nil, false
+	}
+
+	switch {
+	case object.IsInteger(left) && object.IsInteger(right):
+		return literal(object.IntegerInfix(node.Operator, left, right), node)
+	case object.IsNumber(left) && object.IsNumber(right):
+		return literal(object.FloatInfix(node.Operator, left, right), node)
+	case node.Operator == "==" || node.Operator == "!=":
+		return literal(boolean(object.Equal(left, right) == (node.Operator == "==")), node)
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		return literal(object.StringInfix(node.Operator, left, right), node)
+	}
+	return nil, false
+}
+
+//value returns the value of e if it is a literal
+func value(e ast.Expression) (object.Object, bool) {
+	switch e := e.(type) {
+	case *ast.IntegerLiteral:
+		if e.Big != nil {
+			return &object.BigInt{Value: e.Big}, true
+		}
+		return &object.Integer{Value: e.Value}, true
+	case *ast.FloatLiteral:
+		return &object.Float{Value: e.Value}, true
+	case *ast.Boolean:
+		return boolean(e.Value), true
+	case *ast.StringLiteral:
+		return &object.String{Value: e.Value}, true
+	}
+	return nil, false
+}
+
+func boolean(b bool) *object.Boolean {
+	if b {
+		return object.TRUE
+	}
+	return object.FALSE
+}
+
+//literal returns the literal that evaluates to obj, spanning node, false if
+//obj is an error or a value no literal stands for
+func literal(obj object.Object, node ast.Node) (ast.Expression, bool) {
+	tok := token.Token{Pos: node.Pos(), End: node.End()}
+
+	switch obj := obj.(type) {
+	case *object.Integer:
+		tok.Type, tok.Literal = token.INT, strconv.FormatInt(obj.Value, 10)
+		return &ast.IntegerLiteral{Token: tok, Value: obj.Value}, true
+	case *object.BigInt:
+		tok.Type, tok.Literal = token.INT, obj.Value.String()
+		return &ast.IntegerLiteral{Token: tok, Big: obj.Value}, true
+	case *object.Float:
+		if math.IsInf(obj.Value, 0) || math.IsNaN(obj.Value) {
+			return nil, false
+		}
+		tok.Type, tok.Literal = token.FLOAT, obj.Inspect()
+		return &ast.FloatLiteral{Token: tok, Value: obj.Value}, true
+	case *object.Boolean:
+		tok.Type, tok.Literal = token.FALSE, "false"
+		if obj.Value {
+			tok.Type, tok.Literal = token.TRUE, "true"
+		}
+		return &ast.Boolean{Token: tok, Value: obj.Value}, true
+	case *object.String:
+		tok.Type, tok.Literal = token.STRING, obj.Value
+		return &ast.StringLiteral{Token: tok, Value: obj.Value}, true
+	}
+	return nil, false
+}
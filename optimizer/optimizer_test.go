@@ -0,0 +1,153 @@
+package optimizer
+
+import (
+	"interpreter/ast"
+	"interpreter/compiler"
+	"interpreter/evaluator"
+	"interpreter/lexer"
+	"interpreter/object"
+	"interpreter/parser"
+	"interpreter/vm"
+	"testing"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"60 * 60 * 24", "86400"},
+		{"x * 60 * 24", "((x * 60) * 24)"},
+		{`"a" + "b"`, "ab"},
+		{`"a" <= "b"`, "true"},
+		{"1.5 * 2", "3.0"},
+		{"1 + 2.5", "3.5"},
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"2 < 3 == true", "true"},
+		{"!true", "false"},
+		{"!5", "false"},
+		{"-(2 * 3)", "-6"},
+		{"1 / 0", "(1 / 0)"},
+		{"1.0 / 0", "(1.0 / 0)"},
+		{`"a" == "a"`, "true"},
+		{`"a" != "b"`, "true"},
+		{`"b" > "a"`, "true"},
+		{`"a" - "b"`, "(a - b)"},
+		{"-true", "(-true)"},
+		{"1 + true", "(1 + true)"},
+		{"if (1 < 2) { a } else { b }; c", "ac"},
+		{"if (false) { a } else { b }; c", "bc"},
+		{"if (false) { a }; c", "c"},
+		{"c; if (false) { a }", "ciffalse a"},
+		{"c; if (true) { let a = 1 }", "ciftrue let a = 1;"},
+		{"if (true) { let a = 1 }; c", "iftrue let a = 1;c"},
+		{"let x = if (true) { 1 } else { 2 }", "let x = iftrue 1else2;"},
+		{"let h = 60; h * 2", "let h = 60;(h * 2)"},
+		{"fn(x) { let h = 60; let m = h * 60; x * m }", "fn(x)let h = 60;let m = 3600;(x * 3600)"},
+		{"fn() { let b = !false; if (b) { 1 } else { 2 } }", "fn()let b = true;1"},
+		{"fn() { x; let x = 1; x }", "fn()xlet x = 1;1"},
+		{"fn() { let x = 1; x = 2; x }", "fn()let x = 1;(x = 2)x"},
+		{"fn() { let x = 1; fn() { x += 1 } }", "fn()let x = 1;fn()(x += 1)"},
+		{"fn() { let x = 1; let x = 2; x }", "fn()let x = 1;let x = 2;x"},
+		{"fn(x) { let x = 1; x }", "fn(x)let x = 1;x"},
+		{"fn() { let x = 1; for (x in [2]) { x } }", "fn()let x = 1;for (x in [2]) x"},
+		{"fn() { let x = 1; fn(x) { x } }", "fn()let x = 1;fn(x)x"},
+		{"fn() { let x = 1; fn(y) { x + y } }", "fn()let x = 1;fn(y)(1 + y)"},
+		{`fn() { let s = "a"; s }`, "fn()let s = a;s"},
+	}
+
+	for _, tt := range tests {
+		optimized := Optimize(parse(t, tt.input)).String()
+		if optimized != tt.expected {
+			t.Errorf("input %q: wrong optimization.\nexpected=%q\ngot=%q", tt.input, tt.expected, optimized)
+		}
+	}
+}
+
+//programs whose optimized and original versions must give the same results
+var programs = []string{
+	"let day = 60 * 60 * 24; day * 7",
+	`let greet = fn(name) { let greeting = "hello" + ", "; greeting + name }; greet("you")`,
+	"let f = fn(x) { let h = 60; let m = h * 60; x * m }; f(2)",
+	"let f = fn() { let x = 1; let g = fn() { x += 1; x }; g(); g() }; f()",
+	"let f = fn(n) { let limit = 3; if (n < limit) { f(n + 1) } else { n * limit } }; f(0)",
+	"let f = fn() { let big = 9223372036854775807; big + 1 }; f()",
+	`let s = "a"; s == s`,
+	`let f = fn() { let s = "a"; s == s }; f()`,
+	"let f = fn(x) { let zero = 0; x / zero }; f(1)",
+	"let f = fn() { let t = true; if (!t) { 1 } }; f()",
+	"let f = fn() { 5; if (false) { 1 } }; f()",
+	"let f = fn() { if (true) { let y = 2 }; y }; f()",
+	"if (1 > 2) { 1 } else { 2 }",
+	"let x = 1; if (true) { x = 2 }; x",
+	"let f = fn() { y }; if (true) { let y = 1 }; f()",
+	"let total = 0; for (x in [1, 2, 3]) { let k = 10; total += x * k }; total",
+	"let f = fn() { let n = 3; let out = []; while (n > 0) { out = push(out, n * 2); n -= 1 }; out }; f()",
+	`let f = fn() { let code = 7; try { throw "x" } catch (e) { code * 6 } }; f()`,
+	`let f = fn() { let k = 2; {"a": k * 2, k: "b"} }; f()`,
+	`let f = fn() { let k = 2; "k is ${k * 21}" }; f()`,
+	"-true",
+	"1 + 2 * 3 - 4 / 2 % 3",
+	"2.5 * 4 - 1",
+	`"a" <= "b"`,
+	`"a" == "a"`,
+	`1 == "1"`,
+	"!!5",
+}
+
+func TestOptimizedProgramsMatch(t *testing.T) {
+	for _, input := range programs {
+		for _, run := range []struct {
+			engine string
+			run    func(*ast.Program) string
+		}{
+			{"eval", runEvaluator},
+			{"vm", runVM},
+		} {
+			original := run.run(parse(t, input))
+			optimized := run.run(Optimize(parse(t, input)))
+			if original != optimized {
+				t.Errorf("%s: input %q: optimized program gives a different result.\noriginal=%q\noptimized=%q",
+					run.engine, input, original, optimized)
+			}
+		}
+	}
+}
+
+func runEvaluator(program *ast.Program) string {
+	result := evaluator.Eval(program, object.NewEnvironment())
+	if result == nil {
+		return "<nil>"
+	}
+	return result.Inspect()
+}
+
+func runVM(program *ast.Program) string {
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		return err.Error()
+	}
+
+	machine := vm.New(comp.Bytecode())
+	if err := machine.Run(); err != nil {
+		if errObj, ok := err.(*object.Error); ok {
+			return errObj.Inspect()
+		}
+		return err.Error()
+	}
+	if result := machine.LastPoppedStackElem(); result != nil {
+		return result.Inspect()
+	}
+	return "<nil>"
+}
@@ -3,18 +3,24 @@ package parser
 import (
 	"fmt"
 	"interpreter/ast"
+	"interpreter/diagnostic"
 	"interpreter/lexer"
 	"interpreter/token"
+	"math/big"
 	"strconv"
+	"strings"
 )
 
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN      //= += -= *= /=
+	OR          //||
+	AND         //&&
 	EQUALS      //== or !=
-	LESSGREATER //< OR >
+	LESSGREATER //< > <= or >=
 	SUM         //+ or -
-	PRODUCT     //* or /
+	PRODUCT     //*, / or %
 	PREFIX      //-X OR +X
 	CALL        // myFunc(x)
 	INDEX       //array[index]
@@ -22,17 +28,38 @@ const (
 
 var precedences = map[token.TokenType]int{
 
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.ASTERISK: PRODUCT,
-	token.SLASH:    PRODUCT,
-	token.GT:       LESSGREATER,
-	token.LT:       LESSGREATER,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
-}
+	token.ASSIGN:          ASSIGN,
+	token.PLUS_ASSIGN:     ASSIGN,
+	token.MINUS_ASSIGN:    ASSIGN,
+	token.ASTERISK_ASSIGN: ASSIGN,
+	token.SLASH_ASSIGN:    ASSIGN,
+	token.OR:              OR,
+	token.AND:             AND,
+	token.EQ:              EQUALS,
+	token.NOT_EQ:          EQUALS,
+	token.PLUS:            SUM,
+	token.MINUS:           SUM,
+	token.ASTERISK:        PRODUCT,
+	token.SLASH:           PRODUCT,
+	token.PERCENT:         PRODUCT,
+	token.GT:              LESSGREATER,
+	token.LT:              LESSGREATER,
+	token.LT_EQ:           LESSGREATER,
+	token.GT_EQ:           LESSGREATER,
+	token.LPAREN:          CALL,
+	token.LBRACKET:        INDEX,
+}
+
+//diagnostic codes reported by the parser
+const (
+	CodeUnexpectedToken   = "P001"
+	CodeMissingExpression = "P002"
+	CodeInvalidInteger    = "P003"
+	CodeOutsideLoop       = "P004"
+	CodeInvalidAssignment = "P005"
+	CodeInvalidFloat      = "P006"
+	CodeInvalidToken      = "P007"
+)
 
 type (
 	prefixparseFn func() ast.Expression
@@ -41,7 +68,9 @@ type (
 
 type Parser struct {
 	l              *lexer.Lexer
-	errors         []string
+	diagnostics    []diagnostic.Diagnostic
+	panicking      bool // set after an error until the parser resynchronizes at a statement boundary
+	loopDepth      int  // number of loops enclosing the current token within the current function
 	curToken       token.Token
 	peekToken      token.Token
 	prefixparseFns map[token.TokenType]prefixparseFn
@@ -49,7 +78,7 @@ type Parser struct {
 }
 
 func New(l *lexer.Lexer) *Parser {
-	p := &Parser{l: l, errors: []string{}}
+	p := &Parser{l: l, diagnostics: []diagnostic.Diagnostic{}}
 	// read two tokens so current and next are both set
 	p.nextToken()
 	p.nextToken()
@@ -57,14 +86,17 @@ func New(l *lexer.Lexer) *Parser {
 
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.ParseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.BANG, p.ParsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.ParsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.ParseBoolean)
 	p.registerPrefix(token.FALSE, p.ParseBoolean)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.IF, p.ParseIfExpression)
+	p.registerPrefix(token.TRY, p.parseTryExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
+	p.registerPrefix(token.STRING_START, p.parseInterpolatedString)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.LBRACE,p.parseHashLiteral)
 
@@ -74,10 +106,20 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.MINUS, p.ParseInfixExpression)
 	p.registerInfix(token.SLASH, p.ParseInfixExpression)
 	p.registerInfix(token.ASTERISK, p.ParseInfixExpression)
+	p.registerInfix(token.PERCENT, p.ParseInfixExpression)
 	p.registerInfix(token.GT, p.ParseInfixExpression)
 	p.registerInfix(token.LT, p.ParseInfixExpression)
 	p.registerInfix(token.EQ, p.ParseInfixExpression)
 	p.registerInfix(token.NOT_EQ, p.ParseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.ParseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.ParseInfixExpression)
+	p.registerInfix(token.AND, p.parseLogicalExpression)
+	p.registerInfix(token.OR, p.parseLogicalExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.PLUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.MINUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.ASTERISK_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.SLASH_ASSIGN, p.parseAssignExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
 
@@ -85,8 +127,66 @@ func New(l *lexer.Lexer) *Parser {
 }
 
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected token %s got %s instead", t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	if p.peekTokenIs(token.ILLEGAL) {
+		p.illegalTokenError(p.peekToken)
+		return
+	}
+	d := p.errorAt(p.peekToken, CodeUnexpectedToken, "expected token %s got %s instead", t, p.peekToken.Type)
+	if d != nil && p.peekTokenIs(token.EOF) {
+		d.Hint = "the input ended early, check for an unclosed bracket or a missing " + string(t)
+	}
+}
+
+//errorAt records an error diagnostic spanning tok. Once an error has been
+//reported further errors are dropped until the parser resynchronizes, since
+//they are almost always caused by the first one. It returns the recorded
+//diagnostic so callers can add a hint, or nil if it was dropped.
+func (p *Parser) errorAt(tok token.Token, code string, format string, a ...interface{}) *diagnostic.Diagnostic {
+	if p.panicking {
+		return nil
+	}
+	p.panicking = true
+
+	p.diagnostics = append(p.diagnostics, diagnostic.Diagnostic{
+		Severity: diagnostic.Error,
+		Code:     code,
+		Pos:      tok.Pos,
+		End:      tok.End,
+		Message:  fmt.Sprintf(format, a...),
+	})
+	return &p.diagnostics[len(p.diagnostics)-1]
+}
+
+//synchronize skips the rest of a statement that failed to parse, leaving the
+//current token at the start of the next statement, a closing brace or EOF.
+//start is the first token of the failed statement and is always skipped.
+func (p *Parser) synchronize(start token.Token) {
+	p.panicking = false
+	depth := 0
+
+	for !p.curTokenIs(token.EOF) {
+		if p.curToken.Pos != start.Pos && depth == 0 {
+			switch p.curToken.Type {
+			case token.LET, token.RETURN, token.RBRACE:
+				return
+			}
+		}
+
+		switch p.curToken.Type {
+		case token.LBRACE:
+			depth++
+		case token.RBRACE:
+			if depth > 0 {
+				depth--
+			}
+		case token.SEMICOLON:
+			if depth == 0 {
+				p.nextToken()
+				return
+			}
+		}
+		p.nextToken()
+	}
 }
 
 func (p *Parser) ParseProgram() *ast.Program {
@@ -96,7 +196,12 @@ func (p *Parser) ParseProgram() *ast.Program {
 	}
 
 	for p.curToken.Type != token.EOF {
+		start := p.curToken
 		stmt := p.ParseStatement()
+		if p.panicking {
+			p.synchronize(start)
+			continue
+		}
 		if stmt != nil {
 			program.Statements = append(program.Statements, stmt)
 		}
@@ -111,6 +216,16 @@ func (p *Parser) ParseStatement() ast.Statement {
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.WHILE:
+		return p.parseWhileStatement()
+	case token.FOR:
+		return p.parseForInStatement()
+	case token.BREAK:
+		return p.parseBreakStatement()
+	case token.CONTINUE:
+		return p.parseContinueStatement()
+	case token.THROW:
+		return p.parseThrowStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -152,6 +267,104 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 
 }
 
+func (p *Parser) parseWhileStatement() ast.Statement {
+	stmt := &ast.WhileStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Condition = p.ParseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseForInStatement() ast.Statement {
+	stmt := &ast.ForInStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Value = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Key = stmt.Value
+		stmt.Value = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
+
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Iterable = p.ParseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	p.loopDepth++
+	defer func() { p.loopDepth-- }()
+
+	return p.parseBlockStatement()
+}
+
+func (p *Parser) parseBreakStatement() ast.Statement {
+	stmt := &ast.BreakStatement{Token: p.curToken}
+	if p.loopDepth == 0 {
+		p.errorAt(p.curToken, CodeOutsideLoop, "break outside loop")
+		return nil
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseContinueStatement() ast.Statement {
+	stmt := &ast.ContinueStatement{Token: p.curToken}
+	if p.loopDepth == 0 {
+		p.errorAt(p.curToken, CodeOutsideLoop, "continue outside loop")
+		return nil
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
 //ParseExpression is the heart of our parser, shows how Pratt parser actually works
 func (p *Parser) ParseExpression(precedence int) ast.Expression {
 	prefix := p.prefixparseFns[p.curToken.Type]
@@ -212,6 +425,48 @@ func (p *Parser) ParseInfixExpression(left ast.Expression) ast.Expression {
 	return exp
 }
 
+func (p *Parser) parseLogicalExpression(left ast.Expression) ast.Expression {
+	exp := &ast.LogicalExpression{
+		Token:    p.curToken,
+		Left:     left,
+		Operator: p.curToken.Literal,
+	}
+
+	precedence := p.curPrecedence()
+	p.nextToken()
+	exp.Right = p.ParseExpression(precedence)
+
+	return exp
+}
+
+//parseAssignExpression parses the right hand side of an assignment. It binds
+//more loosely than any operator and is right associative, so a = b = c
+//assigns c to both.
+func (p *Parser) parseAssignExpression(left ast.Expression) ast.Expression {
+	exp := &ast.AssignExpression{
+		Token:    p.curToken,
+		Target:   left,
+		Operator: p.curToken.Literal,
+	}
+
+	if left == nil {
+		return nil
+	}
+	switch left.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	default:
+		if d := p.errorAt(p.curToken, CodeInvalidAssignment, "cannot assign to %s", left.String()); d != nil {
+			d.Pos = left.Pos()
+		}
+		return nil
+	}
+
+	p.nextToken()
+	exp.Value = p.ParseExpression(LOWEST)
+
+	return exp
+}
+
 func (p *Parser) parseIdentifier() ast.Expression {
 	return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 }
@@ -221,9 +476,14 @@ func (p *Parser) ParseIntegerLiteral() ast.Expression {
 	value, err := strconv.ParseInt(p.curToken.Literal, 10, 64)
 	//	defer untrace(trace("ParseIntegerLiteral"))
 
+	if numErr, ok := err.(*strconv.NumError); ok && numErr.Err == strconv.ErrRange {
+		if v, ok := new(big.Int).SetString(p.curToken.Literal, 10); ok {
+			lit.Big = v
+			return lit
+		}
+	}
 	if err != nil {
-		msg := fmt.Sprintf("couldn't parse %v as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.errorAt(p.curToken, CodeInvalidInteger, "couldn't parse %v as integer", p.curToken.Literal)
 		return lit
 	}
 	lit.Value = value
@@ -231,6 +491,18 @@ func (p *Parser) ParseIntegerLiteral() ast.Expression {
 
 }
 
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.curToken}
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+
+	if err != nil {
+		p.errorAt(p.curToken, CodeInvalidFloat, "couldn't parse %v as float", p.curToken.Literal)
+		return lit
+	}
+	lit.Value = value
+	return lit
+}
+
 func (p *Parser) ParseBoolean() ast.Expression {
 	exp := &ast.Boolean{
 		Token: p.curToken,
@@ -267,6 +539,59 @@ func (p *Parser) ParseIfExpression() ast.Expression {
 	return expression
 }
 
+func (p *Parser) parseThrowStatement() ast.Statement {
+	stmt := &ast.ThrowStatement{Token: p.curToken}
+	p.nextToken()
+
+	stmt.Value = p.ParseExpression(LOWEST)
+	if stmt.Value == nil {
+		return nil
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseTryExpression() ast.Expression {
+	expression := &ast.TryExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	expression.Block = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+		if !p.expectPeek(token.LPAREN) || !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		expression.Param = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		if !p.expectPeek(token.RPAREN) || !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		expression.Catch = p.parseBlockStatement()
+	}
+
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		expression.Finally = p.parseBlockStatement()
+	}
+
+	if expression.Catch == nil && expression.Finally == nil {
+		d := p.errorAt(p.peekToken, CodeUnexpectedToken, "expected catch or finally after try block, got %s instead", p.peekToken.Type)
+		if d != nil {
+			d.Hint = "add a catch (e) { } or a finally { } block"
+		}
+		return nil
+	}
+	return expression
+}
+
 func (p *Parser) parseFunctionLiteral() ast.Expression {
 	lit := &ast.FunctionLiteral{Token: p.curToken}
 
@@ -280,7 +605,13 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 		return nil
 	}
 
+	// break and continue cannot reach loops outside the function
+	outerLoopDepth := p.loopDepth
+	p.loopDepth = 0
 	lit.Body = p.parseBlockStatement()
+	p.loopDepth = outerLoopDepth
+	ast.MarkTailCalls(lit.Body)
+
 	return lit
 }
 
@@ -316,6 +647,7 @@ func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 
 	exp := &ast.CallExpression{Token: p.curToken, Function: function}
 	exp.Arguments = p.parseExpressionList(token.RPAREN)
+	exp.Rparen = p.curToken
 	return exp
 }
 
@@ -352,12 +684,18 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	p.nextToken()
 
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
+		start := p.curToken
 		stmt := p.ParseStatement()
+		if p.panicking {
+			p.synchronize(start)
+			continue
+		}
 		if stmt != nil {
 			block.Statements = append(block.Statements, stmt)
 		}
 		p.nextToken()
 	}
+	block.Rbrace = p.curToken
 	return block
 }
 
@@ -377,10 +715,38 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 	}
 }
 
+func (p *Parser) parseInterpolatedString() ast.Expression {
+	str := &ast.InterpolatedString{Token: p.curToken}
+
+	for {
+		if p.curToken.Literal != "" {
+			str.Parts = append(str.Parts, &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal})
+		}
+		if p.curTokenIs(token.STRING_END) {
+			str.Tail = p.curToken
+			return str
+		}
+
+		p.nextToken()
+		part := p.ParseExpression(LOWEST)
+		if part == nil {
+			return nil
+		}
+		str.Parts = append(str.Parts, part)
+
+		if p.peekTokenIs(token.STRING_MIDDLE) {
+			p.nextToken()
+		} else if !p.expectPeek(token.STRING_END) {
+			return nil
+		}
+	}
+}
+
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: p.curToken}
 
 	array.Elements = p.parseExpressionList(token.RBRACKET)
+	array.Rbracket = p.curToken
 	return array
 }
 
@@ -418,6 +784,7 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	if !p.expectPeek(token.RBRACKET) {
 		return nil
 	}
+	exp.Rbracket = p.curToken
 	return exp
 }
 
@@ -445,6 +812,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 	if !p.expectPeek(token.RBRACE) {
 		return nil
 	}
+	hash.Rbrace = p.curToken
 	return hash
 }
 
@@ -467,13 +835,54 @@ func (p *Parser) nextToken() {
 	p.peekToken = p.l.NextToken()
 }
 
+//Errors returns the error diagnostics formatted one per line
 func (p *Parser) Errors() []string {
-	return p.errors
+	errors := []string{}
+	for _, d := range p.diagnostics {
+		if d.Severity == diagnostic.Error {
+			errors = append(errors, d.String())
+		}
+	}
+	return errors
+}
+
+//Diagnostics returns everything the parser reported, in source order
+func (p *Parser) Diagnostics() []diagnostic.Diagnostic {
+	return p.diagnostics
 }
 
 func (p *Parser) NoPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found ", t)
-	p.errors = append(p.errors, msg)
+	if t == token.ILLEGAL {
+		p.illegalTokenError(p.curToken)
+		return
+	}
+	p.errorAt(p.curToken, CodeMissingExpression, "expected an expression, found %s", t)
+}
+
+//illegalTokenError reports an ILLEGAL token, telling an unterminated string or
+//comment and a bad escape sequence apart from a stray character by the
+//literal the lexer gave the token
+func (p *Parser) illegalTokenError(tok token.Token) {
+	switch {
+	case len(tok.Literal) <= 1:
+		p.errorAt(tok, CodeMissingExpression, "unexpected character %q", tok.Literal)
+	case tok.Literal[0] == '"' || tok.Literal[0] == '}':
+		if d := p.errorAt(tok, CodeInvalidToken, "unterminated string literal"); d != nil {
+			d.Hint = `close the string with "`
+		}
+	case tok.Literal[0] == '`':
+		if d := p.errorAt(tok, CodeInvalidToken, "unterminated string literal"); d != nil {
+			d.Hint = "close the string with `"
+		}
+	case tok.Literal[0] == '\\':
+		if d := p.errorAt(tok, CodeInvalidToken, "invalid escape sequence %s", tok.Literal); d != nil {
+			d.Hint = `valid escapes are \n \t \" \\ \$ and \u{...}`
+		}
+	case strings.HasPrefix(tok.Literal, "/*"):
+		p.errorAt(tok, CodeInvalidToken, "unterminated block comment")
+	default:
+		p.errorAt(tok, CodeMissingExpression, "unexpected character %q", tok.Literal)
+	}
 }
 
 func (p *Parser) peekTokenIs(t token.TokenType) bool {
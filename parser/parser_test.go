@@ -72,6 +72,34 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"a * [1, 2, 3, 4][b * c] * d",
 			"((a * ([1, 2, 3, 4][(b * c)])) * d)",
 		},
+		{
+			"x = y = a + b == c",
+			"(x = (y = ((a + b) == c)))",
+		},
+		{
+			"x += f(1) * 2",
+			"(x += (f(1) * 2))",
+		},
+		{
+			"a || b && c == d",
+			"(a || (b && (c == d)))",
+		},
+		{
+			"a && b || c && d",
+			"((a && b) || (c && d))",
+		},
+		{
+			"x = a <= b && c >= d",
+			"(x = ((a <= b) && (c >= d)))",
+		},
+		{
+			"a + b % c * d",
+			"(a + ((b % c) * d))",
+		},
+		{
+			"a[i + 1] = b[0]",
+			"((a[(i + 1)]) = (b[0]))",
+		},
 	}
 
 	for _, tt := range tests {
@@ -808,3 +836,424 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 		testFunc(value)
 	}
 }
+
+func TestNodePositions(t *testing.T) {
+	input := "let add = fn(x, y) {\n  x + y;\n};\nadd(1, [2][0])"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParseErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d", len(program.Statements))
+	}
+
+	let := program.Statements[0].(*ast.LetStatement)
+	function := let.Value.(*ast.FunctionLiteral)
+	body := function.Body.Statements[0].(*ast.ExpressionStatement)
+	call := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.CallExpression)
+
+	tests := []struct {
+		node      ast.Node
+		startLine int
+		startCol  int
+		endLine   int
+		endCol    int
+	}{
+		{let, 1, 1, 3, 2},
+		{function, 1, 11, 3, 2},
+		{body.Expression, 2, 3, 2, 8},
+		{call, 4, 1, 4, 15},
+		{call.Arguments[1], 4, 8, 4, 14},
+		{program, 1, 1, 4, 15},
+	}
+
+	for i, tt := range tests {
+		pos, end := tt.node.Pos(), tt.node.End()
+		if pos.Line != tt.startLine || pos.Column != tt.startCol {
+			t.Errorf("tests[%d] (%s) - start wrong. expected=%d:%d, got=%s", i, tt.node, tt.startLine, tt.startCol, pos)
+		}
+		if end.Line != tt.endLine || end.Column != tt.endCol {
+			t.Errorf("tests[%d] (%s) - end wrong. expected=%d:%d, got=%s", i, tt.node, tt.endLine, tt.endCol, end)
+		}
+	}
+}
+
+func TestParseErrorPositions(t *testing.T) {
+	input := "let x = 5;\nlet = 10;"
+
+	l := lexer.NewFile("main.hk", input)
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) == 0 {
+		t.Fatalf("expected parse errors, got none")
+	}
+
+	expected := "main.hk:2:5: error[P001]: expected token IDENT got = instead"
+	if errors[0] != expected {
+		t.Errorf("wrong error. expected=%q, got=%q", expected, errors[0])
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input              string
+		expectedErrors     []string
+		expectedStatements int
+	}{
+		{
+			"let = 5; let y = 10; y;",
+			[]string{"1:5: error[P001]: expected token IDENT got = instead"},
+			2,
+		},
+		{
+			"let x 5; let y = ; return y;",
+			[]string{
+				"1:7: error[P001]: expected token = got INT instead",
+				"1:18: error[P002]: expected an expression, found ;",
+			},
+			1,
+		},
+		{
+			"let f = fn(x) {\n  let a = ;\n  x\n};\nf(1)",
+			[]string{"2:11: error[P002]: expected an expression, found ;"},
+			2,
+		},
+		{
+			"if (x { 1 } let y = 2;",
+			[]string{"1:7: error[P001]: expected token ) got { instead"},
+			1,
+		},
+		{
+			"add(1, 2",
+			[]string{"1:9: error[P001]: expected token ) got EOF instead"},
+			0,
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != len(tt.expectedErrors) {
+			t.Errorf("input %q: wrong number of errors. expected=%d, got=%d (%q)", tt.input, len(tt.expectedErrors), len(errors), errors)
+			continue
+		}
+		for i, msg := range tt.expectedErrors {
+			if errors[i] != msg {
+				t.Errorf("input %q: wrong error. expected=%q, got=%q", tt.input, msg, errors[i])
+			}
+		}
+
+		if len(program.Statements) != tt.expectedStatements {
+			t.Errorf("input %q: wrong number of statements. expected=%d, got=%d", tt.input, tt.expectedStatements, len(program.Statements))
+		}
+	}
+}
+
+func TestDiagnosticsCarrySpansAndHints(t *testing.T) {
+	l := lexer.New("let x = [1, 2")
+	p := New(l)
+	p.ParseProgram()
+
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) != 1 {
+		t.Fatalf("expected 1 diagnostic. got=%d", len(diagnostics))
+	}
+
+	d := diagnostics[0]
+	if d.Code != CodeUnexpectedToken {
+		t.Errorf("wrong code. expected=%s, got=%s", CodeUnexpectedToken, d.Code)
+	}
+	if d.Pos.Column != 14 {
+		t.Errorf("wrong column. expected=14, got=%d", d.Pos.Column)
+	}
+	if d.Hint == "" {
+		t.Errorf("expected a hint for unexpected EOF")
+	}
+}
+
+func TestLoopStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"while (x < 10) { x }", "while ((x < 10)) x"},
+		{"for (x in xs) { x }", "for (x in xs) x"},
+		{"for (i, x in [1, 2]) { break; }", "for (i, x in [1, 2]) break;"},
+		{"while (true) { continue; };", "while (true) continue;"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParseErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("input %q: expected 1 statement, got=%d", tt.input, len(program.Statements))
+		}
+		if actual := program.String(); actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+}
+
+func TestLoopControlOutsideLoop(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"break;", "1:1: error[P004]: break outside loop"},
+		{"if (true) { continue; }", "1:13: error[P004]: continue outside loop"},
+		{"while (true) { fn() { break; } }", "1:23: error[P004]: break outside loop"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != 1 {
+			t.Errorf("input %q: expected 1 error, got=%d (%q)", tt.input, len(errors), errors)
+			continue
+		}
+		if errors[0] != tt.expected {
+			t.Errorf("input %q: wrong error. expected=%q, got=%q", tt.input, tt.expected, errors[0])
+		}
+	}
+}
+
+func TestAssignExpression(t *testing.T) {
+	tests := []struct {
+		input            string
+		expectedOperator string
+		expectedValue    interface{}
+	}{
+		{"x = 5;", "=", 5},
+		{"x += y;", "+=", "y"},
+		{"x -= 1;", "-=", 1},
+		{"x *= 2;", "*=", 2},
+		{"x /= z;", "/=", "z"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParseErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		exp, ok := stmt.Expression.(*ast.AssignExpression)
+		if !ok {
+			t.Fatalf("exp not *ast.AssignExpression. got=%T", stmt.Expression)
+		}
+		if !testIdentifier(t, exp.Target, "x") {
+			return
+		}
+		if exp.Operator != tt.expectedOperator {
+			t.Errorf("exp.Operator is not %q. got=%q", tt.expectedOperator, exp.Operator)
+		}
+		if !testLiteralExpression(t, exp.Value, tt.expectedValue) {
+			return
+		}
+	}
+}
+
+func TestInvalidAssignmentTarget(t *testing.T) {
+	p := New(lexer.New("a + b = 5; let c = 1;"))
+	program := p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) != 1 || errors[0] != "1:1: error[P005]: cannot assign to (a + b)" {
+		t.Fatalf("wrong errors. got=%q", errors)
+	}
+	if len(program.Statements) != 1 {
+		t.Errorf("parser did not recover after the error. got=%d statements", len(program.Statements))
+	}
+}
+
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.14;", 3.14},
+		{"1e-9;", 1e-9},
+		{"2.5E2;", 250},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParseErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		literal, ok := stmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.FloatLiteral. got=%T", stmt.Expression)
+		}
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %g. got=%g", tt.expected, literal.Value)
+		}
+	}
+
+	p := New(lexer.New("1e999"))
+	p.ParseProgram()
+	if errors := p.Errors(); len(errors) != 1 || errors[0] != "1:1: error[P006]: couldn't parse 1e999 as float" {
+		t.Errorf("wrong errors for an out of range float. got=%q", errors)
+	}
+}
+
+func TestCommentsAreIgnored(t *testing.T) {
+	input := `// adds two numbers
+let add = fn(a, b) {
+	a + b // the sum
+};
+/* call it */ add(1, /* two */ 2);`
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParseErrors(t, p)
+
+	expected := "let add = fn(a,b)(a + b);add(1,2)"
+	if program.String() != expected {
+		t.Errorf("expected=%q, got=%q", expected, program.String())
+	}
+}
+
+func TestInvalidTokenErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let s = "open;`, "1:9: error[P007]: unterminated string literal"},
+		{"puts(`open", "1:6: error[P007]: unterminated string literal"},
+		{`"a\qb"`, `1:3: error[P007]: invalid escape sequence \q`},
+		{"1 /* open", "1:3: error[P007]: unterminated block comment"},
+		{"let x = 1 # 2", `1:11: error[P002]: unexpected character "#"`},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != 1 {
+			t.Errorf("input %q: expected 1 error, got=%d (%q)", tt.input, len(errors), errors)
+			continue
+		}
+		if errors[0] != tt.expected {
+			t.Errorf("input %q: wrong error. expected=%q, got=%q", tt.input, tt.expected, errors[0])
+		}
+	}
+}
+
+func TestInterpolatedStringParsing(t *testing.T) {
+	tests := []struct {
+		input         string
+		expected      string
+		expectedParts int
+	}{
+		{`"count: ${n + 1}"`, `"count: ${(n + 1)}"`, 2},
+		{`"${a}${b}"`, `"${a}${b}"`, 2},
+		{`"<${f("${x}!")}>"`, `"<${f("${x}!")}>"`, 3},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParseErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		str, ok := stmt.Expression.(*ast.InterpolatedString)
+		if !ok {
+			t.Fatalf("input %s: expression is not *ast.InterpolatedString. got=%T", tt.input, stmt.Expression)
+		}
+		if len(str.Parts) != tt.expectedParts {
+			t.Errorf("input %s: wrong number of parts. expected=%d, got=%d", tt.input, tt.expectedParts, len(str.Parts))
+		}
+		if str.String() != tt.expected {
+			t.Errorf("input %s: expected=%q, got=%q", tt.input, tt.expected, str.String())
+		}
+		if str.End().Offset != len(tt.input) {
+			t.Errorf("input %s: wrong end offset. got=%d", tt.input, str.End().Offset)
+		}
+	}
+}
+
+func TestInvalidInterpolatedStrings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"a ${}"`, "1:6: error[P002]: expected an expression, found STRING_END"},
+		{`"a ${1 2}"`, "1:8: error[P001]: expected token STRING_END got INT instead"},
+		{`"a ${b} c`, "1:7: error[P007]: unterminated string literal"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 || errors[0] != tt.expected {
+			t.Errorf("input %q: wrong errors. expected=%q, got=%q", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestTryAndThrow(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`throw "boom";`, `throw boom;`},
+		{"try { f() } catch (e) { e }", "try f() catch (e) e"},
+		{"try { f() } finally { g() }", "try f() finally g()"},
+		{"let x = try { 1 } catch (err) { 2 } finally { 3 };", "let x = try 1 catch (err) 2 finally 3;"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParseErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("input %q: expected 1 statement, got=%d", tt.input, len(program.Statements))
+		}
+		if actual := program.String(); actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+}
+
+func TestInvalidTryExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"try { 1 };", "1:10: error[P001]: expected catch or finally after try block, got ; instead"},
+		{"try { 1 } catch { 2 }", "1:17: error[P001]: expected token ( got { instead"},
+		{"try { 1 } catch (1) { 2 }", "1:18: error[P001]: expected token IDENT got INT instead"},
+		{"throw;", "1:6: error[P002]: expected an expression, found ;"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != 1 {
+			t.Errorf("input %q: expected 1 error, got=%d (%q)", tt.input, len(errors), errors)
+			continue
+		}
+		if errors[0] != tt.expected {
+			t.Errorf("input %q: wrong error. expected=%q, got=%q", tt.input, tt.expected, errors[0])
+		}
+	}
+}
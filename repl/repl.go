@@ -1,38 +1,58 @@
 package repl
 
 import (
-	"bufio"
 	"fmt"
-	"interpreter/evaluator"
+	"interpreter/diagnostic"
 	"interpreter/lexer"
 	"interpreter/object"
 	"interpreter/parser"
 	"io"
+	"strings"
 )
 
 const PROMPT = ">>"
 
-func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
-	env := object.NewEnvironment()
+//Engine selects how the REPL runs programs
+type Engine string
+
+const (
+	EngineEval Engine = "eval" // the tree-walking evaluator
+	EngineVM   Engine = "vm"   // the bytecode compiler and virtual machine
+)
+
+//Start runs the REPL. Programs write to out and read from in, so readline
+//reads the lines that follow the one that called it. Each line runs under
+//limits.
+func Start(in io.Reader, out io.Writer, engine Engine, limits object.Limits) {
+	ctx := object.NewContext(in, out, out)
+	session := NewSession(engine)
+	session.SetContext(ctx)
+	session.SetLimits(limits)
+	session.SetOpen(true)
 	for {
 		fmt.Fprintf(out, PROMPT)
-		scanned := scanner.Scan()
-		if !scanned {
+		line, err := ctx.Stdin.ReadString('\n')
+		if err != nil && line == "" {
 			return
 		}
 
-		line := scanner.Text()
+		line = strings.TrimRight(line, "\r\n")
 		l := lexer.New(line)
 		p := parser.New(l)
 
 		program := p.ParseProgram()
 
 		if len(p.Errors()) != 0 {
-			printParseErrors(out, p.Errors())
+			printParseErrors(out, line, p.Diagnostics())
+			continue
+		}
+
+		evaluated, err := session.Run(program)
+		diagnostic.Render(out, line, session.Warnings())
+		if err != nil {
+			PrintError(out, line, err)
 			continue
 		}
-		evaluated := evaluator.Eval(program, env)
 		if evaluated != nil {
 			io.WriteString(out, evaluated.Inspect())
 			io.WriteString(out, "\n")
@@ -41,11 +61,21 @@ func Start(in io.Reader, out io.Writer) {
 	}
 }
 
-func printParseErrors(out io.Writer, errors []string) {
+func printParseErrors(out io.Writer, source string, diagnostics []diagnostic.Diagnostic) {
 
 	io.WriteString(out, "Woopsyy!, guess you just missed something\n")
-	io.WriteString(out, "parser errors:")
-	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\n")
+	diagnostic.Render(out, source, diagnostics)
+}
+
+//PrintError reports an error returned by Session.Run, rendering compile
+//errors against the source they were found in
+func PrintError(out io.Writer, source string, err error) {
+	switch err := err.(type) {
+	case diagnostic.Diagnostic:
+		diagnostic.Render(out, source, []diagnostic.Diagnostic{err})
+	case *object.Error:
+		io.WriteString(out, err.Inspect()+"\n")
+	default:
+		io.WriteString(out, "ERROR: "+err.Error()+"\n")
 	}
 }
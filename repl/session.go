@@ -0,0 +1,133 @@
+package repl
+
+import (
+	"context"
+	"interpreter/ast"
+	"interpreter/compiler"
+	"interpreter/diagnostic"
+	"interpreter/evaluator"
+	"interpreter/object"
+	"interpreter/resolver"
+	"interpreter/vm"
+)
+
+//Session runs programs one after the other on an engine, keeping the global
+//bindings of earlier programs visible to later ones
+type Session struct {
+	engine Engine
+	ctx    *object.Context
+	limits object.Limits
+	open   bool
+	budget *object.Budget // that of the program last run
+
+	warnings []diagnostic.Diagnostic
+
+	env *object.Environment
+
+	constants   []object.Object
+	globals     []object.Object
+	symbolTable *compiler.SymbolTable
+}
+
+func NewSession(engine Engine) *Session {
+	return &Session{
+		engine:      engine,
+		ctx:         object.DefaultContext,
+		env:         object.NewEnvironment(),
+		constants:   []object.Object{},
+		globals:     vm.NewGlobalsStore(),
+		symbolTable: compiler.NewSymbolTableWithBuiltins(),
+	}
+}
+
+//SetContext sets the streams programs use for input and output
+func (s *Session) SetContext(ctx *object.Context) {
+	s.ctx = ctx
+	s.env.SetContext(ctx)
+}
+
+//SetLimits bounds the resources of each later program
+func (s *Session) SetLimits(limits object.Limits) {
+	s.limits = limits
+}
+
+//SetOpen lets functions read globals that later programs define, as the REPL
+//needs, reporting such names as warnings rather than errors
+func (s *Session) SetOpen(open bool) {
+	s.open = open
+}
+
+//AllocatedBytes returns the approximate bytes the program last run allocated
+//in total, garbage included, an upper bound on its peak memory
+func (s *Session) AllocatedBytes() int64 {
+	if s.budget == nil {
+		return 0
+	}
+	return s.budget.AllocatedBytes()
+}
+
+//Warnings returns the warnings about the program last run
+func (s *Session) Warnings() []diagnostic.Diagnostic {
+	return s.warnings
+}
+
+//Define binds a global before any program runs
+func (s *Session) Define(name string, val object.Object) {
+	if s.engine == EngineVM {
+		symbol := s.symbolTable.Define(name)
+		s.globals[symbol.Index] = val
+		return
+	}
+	s.env.Set(name, val)
+}
+
+//Run executes program and returns the value it produced, nil if it produced
+//none. Compile errors and undefined variables are returned as
+//diagnostic.Diagnostic and runtime errors as *object.Error, warnings are left
+//for Warnings.
+func (s *Session) Run(program *ast.Program) (object.Object, error) {
+	s.warnings = nil
+	s.budget = object.NewBudget(context.Background(), s.limits)
+
+	if s.engine == EngineVM {
+		// a program that fails to compile must not leave its globals defined
+		symbolTable := s.symbolTable.Copy()
+		comp := compiler.NewWithState(symbolTable, s.constants)
+		comp.SetOpen(s.open)
+		err := comp.Compile(program)
+		s.warnings = comp.Warnings()
+		if err != nil {
+			return nil, err
+		}
+
+		bytecode := comp.Bytecode()
+		s.symbolTable, s.constants = symbolTable, bytecode.Constants
+
+		machine := vm.NewWithGlobalsState(bytecode, s.globals)
+		machine.SetContext(s.ctx)
+		machine.SetBudget(s.budget)
+		if err := machine.Run(); err != nil {
+			return nil, err
+		}
+		return machine.LastPoppedStackElem(), nil
+	}
+
+	resolve := resolver.Resolve
+	if s.open {
+		resolve = resolver.ResolveOpen
+	}
+	for _, d := range resolve(program, s.env) {
+		if d.Severity == diagnostic.Error {
+			return nil, d
+		}
+		s.warnings = append(s.warnings, d)
+	}
+
+	previous := s.env.SetBudget(s.budget)
+	evaluated := evaluator.Eval(program, s.env)
+	s.env.SetBudget(previous)
+	if err, ok := evaluated.(*object.Error); ok {
+		return nil, err
+	}
+	return evaluated, nil
+}
@@ -0,0 +1,294 @@
+package repl
+
+import (
+	"interpreter/lexer"
+	"interpreter/object"
+	"interpreter/parser"
+	"strings"
+	"testing"
+	"time"
+)
+
+//engineTest is a program both engines must agree on, expected is the Inspect
+//output of its value or of the error it fails with
+type engineTest struct {
+	input    string
+	expected string
+}
+
+func runEngineTests(t *testing.T, tests []engineTest) {
+	t.Helper()
+
+	for _, engine := range []Engine{EngineEval, EngineVM} {
+		for _, tt := range tests {
+			if actual := run(t, NewSession(engine), tt.input); actual != tt.expected {
+				t.Errorf("%s: input %q: wrong result.\nexpected=%q\ngot=%q", engine, tt.input, tt.expected, actual)
+			}
+		}
+	}
+}
+
+func run(t *testing.T, session *Session, input string) string {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+
+	result, err := session.Run(program)
+	switch err := err.(type) {
+	case nil:
+	case *object.Error:
+		return err.Inspect()
+	default:
+		return err.Error()
+	}
+	if result == nil {
+		return "<nil>"
+	}
+	return result.Inspect()
+}
+
+func TestErrorTracebacks(t *testing.T) {
+	runEngineTests(t, []engineTest{
+		{"1 / 0", "ERROR 1:3: division by zero"},
+		{
+			"let divide = fn(a, b) { a / b };\nlet ratio = fn(x) { 1 + divide(x, 0) };\nratio(10)",
+			"ERROR 1:27: division by zero\n  in divide(INTEGER, INTEGER) called at 2:25\n  in ratio(INTEGER) called at 3:1",
+		},
+		{
+			"let apply = fn(f, v) { let r = f(v); r };\napply(fn(s) { -s }, \"a\")",
+			"ERROR 2:15: unknown operator: -STRING\n  in f(STRING) called at 1:32\n  in apply(FUNCTION, STRING) called at 2:1",
+		},
+		{
+			"let a = fn() { 1 / 0 };\nlet b = fn() { a() };\nb()",
+			"ERROR 1:18: division by zero\n  in a() called at 2:16",
+		},
+		{
+			"fn(s) {\n  -s\n}(\"a\")",
+			"ERROR 2:3: unknown operator: -STRING\n  in fn(STRING) called at 1:1",
+		},
+		{
+			"let f = fn(x) { len(x) };\nf(1)",
+			"ERROR 1:17: argument to `len` is not supported, got INTEGER\n  in f(INTEGER) called at 2:1",
+		},
+		{"let f = fn(a) { a };\nlet g = fn() { f() };\ng()", "ERROR 2:16: wrong number of arguments: want=1, got=0\n  in g() called at 3:1"},
+	})
+}
+
+func TestTryExpressions(t *testing.T) {
+	runEngineTests(t, []engineTest{
+		{`try { 1 / 0 } catch (e) { e["message"] }`, "division by zero"},
+		{`try { 1 / 0 } catch (e) { e["kind"] }`, "RuntimeError"},
+		{`try { throw "boom" } catch (e) { e["kind"] + ": " + e["message"] }`, "ThrownError: boom"},
+		{`try { throw [1, 2] } catch (e) { e["message"] }`, "[1, 2]"},
+		{`try { len(1) } catch (e) { e["message"] }`, "argument to `len` is not supported, got INTEGER"},
+		{`try { int("abc") } catch (e) { e["message"] }`, `could not parse "abc" as INTEGER`},
+		{`try { throw "x" } catch (e) { e["other"] }`, "null"},
+		{"try { 5 } catch (e) { 0 }", "5"},
+		{"try { let x = 5; } catch (e) { 0 }", "null"},
+		{`1 + try { throw "x" } catch (e) { 41 }`, "42"},
+		{`let log = ""; let r = try { 1 } finally { log += "f" }; log + "${r}"`, "f1"},
+		{`let log = ""; try { throw "x" } catch (e) { log += "c" } finally { log += "f" }; log`, "cf"},
+		{`let f = fn() { try { throw "a" } catch (e) { return e["message"] } finally { 0 }; "b" }; f()`, "a"},
+		{`let log = ""; let f = fn() { try { return 1 } finally { log += "f" } }; "${f()}" + log`, "1f"},
+		{`let g = fn() { try { 1 } finally { throw "from finally" } }; try { g() } catch (e) { e["message"] }`, "from finally"},
+		{`let h = fn() { try { throw "a" } catch (e) { throw "b" } finally { 0 } }; try { h() } catch (e) { e["message"] }`, "b"},
+		{`try { try { throw "inner" } catch (e) { throw e } } catch (e) { e["kind"] + ": " + e["message"] }`, "ThrownError: inner"},
+		{`let out = ""; for (x in [1, 2, 3, 4]) { try { if (x % 2 == 0) { continue } out += "${x}" } finally { out += "f" } }; out`, "1ff3ff"},
+		{`let out = ""; let i = 0; while (true) { i += 1; try { try { if (i == 2) { break } } finally { out += "i" } } finally { out += "o" } }; out`, "ioio"},
+		{`let out = ""; for (x in [1, 2, 3]) { try { if (x == 2) { throw "skip" } out += "${x}" } catch (e) { out += "!" } }; out`, "1!3"},
+		{`let inner = fn() { throw "deep" }; let outer = fn() { inner() + 1 }; try { outer() } catch (e) { "${e["trace"]}" }`, "[inner() called at 1:55, outer() called at 1:76]"},
+		{`let inner = fn(x) { throw "deep" }; let middle = fn() { try { inner(1) } catch (e) { throw e } }; try { middle() } catch (e) { "${e["trace"]}" }`, "[inner(INTEGER) called at 1:63, middle() called at 1:105]"},
+		{`let f = fn(x) { try { if (x == 0) { throw "x" } f(x - 1) } catch (e) { if (x < 2) { throw e } len(e["trace"]) } }; f(4)`, "2"},
+		{`let e = "outer"; try { throw "x" } catch (e) { 0 }; e`, "outer"},
+		{`let e = "outer"; let r = try { throw "x" } catch (e) { e["message"] }; r + e`, "xouter"},
+		{`let f = fn() { let e = "outer"; try { throw "x" } catch (e) { 0 }; e }; f()`, "outer"},
+		{`let f = fn() { try { throw "x" } catch (e) { fn() { e["message"] } } }; let e = "outer"; f()() + e`, "xouter"},
+		{`let f = fn(e) { try { throw "x" } catch (e) { let e = 1 } finally { e += "!" }; e }; f("a")`, "a!"},
+		{`try { throw "x" } catch (e) { 0 }; e`, "1:36: error[R001]: undefined variable e"},
+		{`try { throw "x" } finally { 1 }`, "ERROR 1:7: x"},
+		{`try { 1 } catch (e) { throw "again: " + e["message"] }; try { 1 / 0 } catch (e) { throw "again: " + e["message"] }`, "ERROR 1:83: again: division by zero"},
+		{`let e = try { throw "x" } catch (e) { e }; throw e`, "ERROR 1:15: x"},
+	})
+}
+
+func TestTailCalls(t *testing.T) {
+	runEngineTests(t, []engineTest{
+		{"let loop = fn(n, acc) { if (n == 0) { acc } else { loop(n - 1, acc + n) } }; loop(100000, 0)", "5000050000"},
+		{"let even = fn(n) { if (n == 0) { return true }; odd(n - 1) }; let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } }; even(100001)", "false"},
+		{`let count = fn(n) { while (true) { if (n == 0) { return "done" } return count(n - 1) } }; count(100000)`, "done"},
+		{`let f = fn(n) { if (n == 0) { len("abc") } else { if (n % 2 == 0) { f(n - 1) } else { return f(n - 1) } } }; f(5000)`, "3"},
+		{"let apply = fn(f, x) { f(x) }; let down = fn(n) { if (n == 0) { 0 } else { apply(down, n - 1) } }; down(5000)", "0"},
+	})
+}
+
+func TestControlFlowInExpressions(t *testing.T) {
+	runEngineTests(t, []engineTest{
+		{"let n = 0; while (n < 3) { n += 1; let y = if (true) { break }; }; n", "1"},
+		{`let out = ""; let n = 0; while (n < 3) { n += 1; out += "${if (n == 2) { continue } else { n }}" }; out`, "13"},
+		{"let s = 0; for (x in [1, 2, 3]) { s += [if (x == 2) { continue } else { x }][0] }; s", "4"},
+		{"let s = 0; for (x in [1, 2, 3]) { for (y in [1, 2]) { s += -(if (y == 2) { break } else { x }) } }; s", "-6"},
+		{`let n = 0; while (true) { n += 1; let h = {"a": if (n == 2) { break } else { n }} }; n`, "2"},
+		{"let f = fn() { let x = [if (true) { return 7 }]; 0 }; f()", "7"},
+		{"let f = fn(x) { len(if (x) { return x } else { \"abc\" }) }; [f(5), f(false)]", "[5, 3]"},
+	})
+}
+
+func TestSelfContainingCollections(t *testing.T) {
+	runEngineTests(t, []engineTest{
+		{"let a = [1]; a[0] = a; a", "[[...]]"},
+		{`let h = {}; h["s"] = h; "${h}"`, "{s: {...}}"},
+		{`let a = [1]; let h = {"a": a}; a[0] = h; [a, h]`, "[[{a: [...]}], {a: [{...}]}]"},
+		{"let b = [1]; [b, b]", "[[1], [1]]"},
+	})
+}
+
+func TestFunctionScope(t *testing.T) {
+	runEngineTests(t, []engineTest{
+		{"let f = fn() { let g = fn() { y }; let y = 2; g() }; f()", "2"},
+		{"let f = fn(n) { let even = fn(n) { n == 0 || odd(n - 1) }; let odd = fn(n) { n != 0 && even(n - 1) }; even(n) }; [f(4), f(7)]", "[true, false]"},
+		{"let f = fn() { let g = fn() { y }; let r = g(); let y = 2; r }; f()", "ERROR 1:31: identifier not found:y\n  in g() called at 1:44\n  in f() called at 1:65"},
+		{"let x = 1; let f = fn() { let y = x; let x = 2; y }; f()", "1"},
+		{"let x = 1; let f = fn() { let x = x + 1; x }; f()", "2"},
+		{"let x = 1; let f = fn() { let y = x; let g = fn() { x }; let x = 2; [y, g()] }; f()", "[1, 2]"},
+		{"let f = fn(a) { let y = fn() { a }; let g = fn() { let z = y(); let y = 5; [z, y] }; g() }; f(3)", "[3, 5]"},
+	})
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		limits       object.Limits
+		input        string
+		expectedKind object.ErrorKind
+		expected     string
+	}{
+		{object.Limits{MaxSteps: 1000}, "while (true) { }", object.StepLimitExceeded, "step limit of 1000 exceeded"},
+		{object.Limits{}, "let f = fn() { 1 + f() }; f()", object.CallDepthExceeded, "stack overflow"},
+		{object.Limits{MaxCallDepth: 10}, "let f = fn(n) { if (n > 0) { 1 + f(n - 1) } }; f(20)", object.CallDepthExceeded, "stack overflow"},
+		{object.Limits{Timeout: 10 * time.Millisecond}, "while (true) { }", object.Timeout, "execution timed out after 10ms"},
+		{object.Limits{Timeout: 10 * time.Millisecond}, "let spin = fn(n) { spin(n + 1) }; spin(0)", object.Timeout, "execution timed out after 10ms"},
+		{object.Limits{MaxSteps: 1000}, "1 + true", object.RuntimeError, "type mismatch: INTEGER + BOOLEAN"},
+		{object.Limits{MaxMemory: 10000}, `let s = ""; while (true) { s += "x" }`, object.MemoryLimitExceeded, "memory limit of 10000 bytes exceeded"},
+		{object.Limits{MaxMemory: 10000}, "let a = []; while (true) { a = push(a, 1) }", object.MemoryLimitExceeded, "memory limit of 10000 bytes exceeded"},
+		{object.Limits{MaxMemory: 10000}, "let h = {}; let i = 0; while (true) { h[i] = i; i += 1 }", object.MemoryLimitExceeded, "memory limit of 10000 bytes exceeded"},
+		{object.Limits{MaxMemory: 10000}, "let h = {}; let i = 0; while (true) { set(h, i, i); i += 1 }", object.MemoryLimitExceeded, "memory limit of 10000 bytes exceeded"},
+		{object.Limits{MaxMemory: 10000}, `for (c in "abc") { let s = "${c}${c}" }; while (true) { [1, 2, 3] }`, object.MemoryLimitExceeded, "memory limit of 10000 bytes exceeded"},
+		{object.Limits{MaxMemory: 10000}, `let s = "abc"; while (true) { for (c in s) { s[0] } }`, object.MemoryLimitExceeded, "memory limit of 10000 bytes exceeded"},
+		{object.Limits{MaxMemory: 10000}, `let a = "a"; while (true) { let s = a + a }`, object.MemoryLimitExceeded, "memory limit of 10000 bytes exceeded"},
+	}
+
+	for _, engine := range []Engine{EngineEval, EngineVM} {
+		for _, tt := range tests {
+			session := NewSession(engine)
+			session.SetLimits(tt.limits)
+
+			_, err := session.Run(parser.New(lexer.New(tt.input)).ParseProgram())
+			errObj, ok := err.(*object.Error)
+			if !ok {
+				t.Errorf("%s: input %q: expected an error. got=%v", engine, tt.input, err)
+				continue
+			}
+			if errObj.Kind != tt.expectedKind || errObj.Message != tt.expected {
+				t.Errorf("%s: input %q: wrong error. expected=%s %q, got=%s %q",
+					engine, tt.input, tt.expectedKind, tt.expected, errObj.Kind, errObj.Message)
+			}
+			if errObj.IsLimit() != (tt.expectedKind != object.RuntimeError) {
+				t.Errorf("%s: input %q: IsLimit is wrong for %s", engine, tt.input, errObj.Kind)
+			}
+		}
+	}
+}
+
+func TestLimitsAllowWorkWithinThem(t *testing.T) {
+	for _, engine := range []Engine{EngineEval, EngineVM} {
+		session := NewSession(engine)
+		session.SetLimits(object.Limits{MaxCallDepth: 10, MaxSteps: 10000})
+		if actual := run(t, session, "let f = fn(n) { if (n > 0) { 1 + f(n - 1) } else { 0 } }; f(9)"); actual != "9" {
+			t.Errorf("%s: wrong result within limits. got=%q", engine, actual)
+		}
+	}
+}
+
+func TestCallDepthFollowsTheLimit(t *testing.T) {
+	input := "let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(2000)"
+	for _, engine := range []Engine{EngineEval, EngineVM} {
+		session := NewSession(engine)
+		session.SetLimits(object.Limits{MaxCallDepth: 5000})
+		if actual := run(t, session, input); actual != "2000" {
+			t.Errorf("%s: wrong result under a depth limit of 5000. got=%q", engine, actual)
+		}
+		if actual := run(t, NewSession(engine), input); actual != "2000" {
+			t.Errorf("%s: wrong result under the default depth limit. got=%q", engine, actual)
+		}
+
+		session = NewSession(engine)
+		session.SetLimits(object.Limits{MaxCallDepth: 1500})
+		_, err := session.Run(parser.New(lexer.New(input)).ParseProgram())
+		if errObj, ok := err.(*object.Error); !ok || errObj.Kind != object.CallDepthExceeded {
+			t.Errorf("%s: expected a depth limit of 1500 to stop f(2000). got=%v", engine, err)
+		}
+	}
+}
+
+func TestOpenSessionsLetFunctionsReadLaterGlobals(t *testing.T) {
+	lines := []struct {
+		input    string
+		expected string
+		warnings []string
+	}{
+		{"let f = fn() { g() }", "<nil>", []string{"1:16: warning[R001]: undefined variable g"}},
+		{"f()", "ERROR 1:16: identifier not found:g\n  in f() called at 1:1", nil},
+		{"let g = fn() { 2 }", "<nil>", nil},
+		{"f()", "2", nil},
+		{"let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } }", "<nil>", []string{"1:48: warning[R001]: undefined variable odd"}},
+		{"let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } }", "<nil>", nil},
+		{"even(10)", "true", nil},
+		{"let h = fn() { nope = 1 }", "1:16: error[R001]: undefined variable nope", nil},
+	}
+
+	for _, engine := range []Engine{EngineEval, EngineVM} {
+		session := NewSession(engine)
+		session.SetOpen(true)
+		for _, tt := range lines {
+			if actual := run(t, session, tt.input); actual != tt.expected {
+				t.Errorf("%s: input %q: wrong result.\nexpected=%q\ngot=%q", engine, tt.input, tt.expected, actual)
+			}
+			warnings := []string{}
+			for _, w := range session.Warnings() {
+				warnings = append(warnings, w.String())
+			}
+			if strings.Join(warnings, "\n") != strings.Join(tt.warnings, "\n") {
+				t.Errorf("%s: input %q: wrong warnings. expected=%q, got=%q", engine, tt.input, tt.warnings, warnings)
+			}
+		}
+	}
+
+	if actual := run(t, NewSession(EngineEval), "let f = fn() { g() }"); actual != "1:16: error[R001]: undefined variable g" {
+		t.Errorf("expected a closed session to reject g. got=%q", actual)
+	}
+}
+
+func TestFailedProgramsDefineNothing(t *testing.T) {
+	lines := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = 1; nope", "1:12: error[R001]: undefined variable nope"},
+		{"x", "1:1: error[R001]: undefined variable x"},
+		{"let f = fn() { 1 }; len = 3", "1:21: error[R002]: cannot assign to builtin len"},
+		{"f", "1:1: error[R001]: undefined variable f"},
+		{"let x = 2; x", "2"},
+	}
+
+	for _, engine := range []Engine{EngineEval, EngineVM} {
+		session := NewSession(engine)
+		for _, tt := range lines {
+			if actual := run(t, session, tt.input); actual != tt.expected {
+				t.Errorf("%s: input %q: wrong result.\nexpected=%q\ngot=%q", engine, tt.input, tt.expected, actual)
+			}
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package resolver
+
+import (
+	"fmt"
+	"interpreter/ast"
+	"interpreter/diagnostic"
+	"interpreter/object"
+	"sort"
+)
+
+//diagnostic codes reported by the resolver
+const (
+	CodeUndefinedVariable = "R001"
+	CodeAssignToBuiltin   = "R002"
+)
+
+//LaterGlobalHint goes with the warning about a name a function reads in an open program
+const LaterGlobalHint = "define it before the function runs"
+
+//Resolve works out where every identifier of program is bound before the
+//evaluator runs it. A variable local to a function gets the slot it has in
+//the environment of a call, so the evaluator finds it without looking it up
+//by name. Top level let bindings are visible to the whole program, so
+//functions can refer to globals defined further down. A let binding in a
+//function body is visible from the let onwards, and to the functions nested
+//in the body from the start, so local closures can refer to each other.
+//A name bound nowhere in the program, in env or among its builtins is
+//reported as an undefined variable, and an assignment to a builtin is
+//reported as well.
+func Resolve(program *ast.Program, env *object.Environment) []diagnostic.Diagnostic {
+	return resolve(program, env, false)
+}
+
+//ResolveOpen is Resolve for a program that later programs may add globals
+//to, as in the REPL. A name a function reads that is bound nowhere yet is
+//taken for such a global, looked up when the function runs, and reported as
+//a warning rather than an error.
+func ResolveOpen(program *ast.Program, env *object.Environment) []diagnostic.Diagnostic {
+	return resolve(program, env, true)
+}
+
+func resolve(program *ast.Program, env *object.Environment, open bool) []diagnostic.Diagnostic {
+	r := &resolver{env: env, globals: make(map[string]bool), open: open, global: newScope(env.NumSlots())}
+
+	for _, s := range program.Statements {
+		if let, ok := s.(*ast.LetStatement); ok {
+			r.globals[let.Name.Value] = true
+		}
+	}
+	for _, s := range program.Statements {
+		r.resolve(s)
+	}
+	program.Slots = r.global.size
+	program.Resolved = !diagnostic.HasErrors(r.diagnostics)
+	return r.diagnostics
+}
+
+type resolver struct {
+	env         *object.Environment
+	open        bool            // names functions read may be globals of later programs
+	globals     map[string]bool // the globals the program defines
+	global      *scope          // the slots of the global environment, used by catch parameters
+	functions   []*scope        // the functions enclosing the node being resolved, innermost last
+	diagnostics []diagnostic.Diagnostic
+}
+
+//scope holds the slots of a function call or of the global environment
+type scope struct {
+	slots   map[string]int  // the slots of the variables in scope by name
+	pending map[string]bool // let bindings not reached yet, visible to nested functions only
+	size    int             // the number of slots, including those of catch parameters out of scope
+}
+
+func newScope(size int) *scope {
+	return &scope{slots: make(map[string]int), pending: make(map[string]bool), size: size}
+}
+
+//add gives name the next free slot
+func (s *scope) add(name string) int {
+	s.slots[name] = s.size
+	s.size++
+	return s.slots[name]
+}
+
+func (r *resolver) resolve(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.ExpressionStatement:
+		r.resolve(node.Expression)
+	case *ast.LetStatement:
+		// a function bound by let can call itself through the name
+		if _, ok := node.Value.(*ast.FunctionLiteral); ok {
+			r.declare(node.Name)
+			r.resolve(node.Value)
+			return
+		}
+		r.resolve(node.Value)
+		r.declare(node.Name)
+	case *ast.ReturnStatement:
+		r.resolve(node.ReturnValue)
+	case *ast.ThrowStatement:
+		r.resolve(node.Value)
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			r.resolve(s)
+		}
+	case *ast.WhileStatement:
+		r.resolve(node.Condition)
+		r.resolve(node.Body)
+	case *ast.ForInStatement:
+		r.resolve(node.Iterable)
+		if node.Key != nil {
+			r.declare(node.Key)
+		}
+		r.declare(node.Value)
+		r.resolve(node.Body)
+
+	case *ast.Identifier:
+		r.lookup(node, false)
+	case *ast.PrefixExpression:
+		r.resolve(node.Right)
+	case *ast.InfixExpression:
+		r.resolve(node.Left)
+		r.resolve(node.Right)
+	case *ast.LogicalExpression:
+		r.resolve(node.Left)
+		r.resolve(node.Right)
+	case *ast.AssignExpression:
+		if name, ok := node.Target.(*ast.Identifier); ok {
+			r.lookup(name, true)
+		} else {
+			r.resolve(node.Target)
+		}
+		r.resolve(node.Value)
+	case *ast.IfExpression:
+		r.resolve(node.Condition)
+		r.resolve(node.Consequence)
+		if node.Alternative != nil {
+			r.resolve(node.Alternative)
+		}
+	case *ast.TryExpression:
+		r.resolve(node.Block)
+		if node.Catch != nil {
+			r.resolveCatch(node)
+		}
+		if node.Finally != nil {
+			r.resolve(node.Finally)
+		}
+	case *ast.FunctionLiteral:
+		r.resolveFunction(node)
+	case *ast.CallExpression:
+		r.resolve(node.Function)
+		for _, a := range node.Arguments {
+			r.resolve(a)
+		}
+	case *ast.InterpolatedString:
+		for _, p := range node.Parts {
+			r.resolve(p)
+		}
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			r.resolve(el)
+		}
+	case *ast.IndexExpression:
+		r.resolve(node.Left)
+		r.resolve(node.Index)
+	case *ast.HashLiteral:
+		keys := []ast.Expression{}
+		for k := range node.Pairs {
+			keys = append(keys, k)
+		}
+		// the pairs are stored in a map, sort them so diagnostics come in source order
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].Pos().Offset < keys[j].Pos().Offset
+		})
+
+		for _, k := range keys {
+			r.resolve(k)
+			r.resolve(node.Pairs[k])
+		}
+	}
+}
+
+func (r *resolver) resolveFunction(node *ast.FunctionLiteral) {
+	s := newScope(0)
+	r.functions = append(r.functions, s)
+	for _, p := range node.Parameters {
+		r.declare(p)
+	}
+	ast.Walk(node.Body, false, func(n ast.Node) {
+		if let, ok := n.(*ast.LetStatement); ok {
+			if _, ok := s.slots[let.Name.Value]; !ok {
+				s.add(let.Name.Value)
+				s.pending[let.Name.Value] = true
+			}
+		}
+	})
+	r.resolve(node.Body)
+	node.Slots = s.size
+	r.functions = r.functions[:len(r.functions)-1]
+}
+
+//resolveCatch gives the catch parameter a slot of its own, so that it hides
+//a variable of the same name in the catch block only
+func (r *resolver) resolveCatch(node *ast.TryExpression) {
+	s := r.global
+	if len(r.functions) > 0 {
+		s = r.functions[len(r.functions)-1]
+	}
+
+	name := node.Param.Value
+	shadowed, ok := s.slots[name]
+	pending := s.pending[name]
+	delete(s.pending, name)
+
+	node.Param.Local, node.Param.Depth, node.Param.Slot = true, 0, s.add(name)
+	r.resolve(node.Catch)
+
+	delete(s.slots, name)
+	if ok {
+		s.slots[name] = shadowed
+	}
+	if pending {
+		s.pending[name] = true
+	}
+}
+
+//declare binds name in the innermost function, giving it the next free slot
+//unless the function already has a variable of that name, or as a global
+//outside any function
+func (r *resolver) declare(name *ast.Identifier) {
+	if len(r.functions) == 0 {
+		// a let in a catch block rebinds the catch parameter
+		if slot, ok := r.global.slots[name.Value]; ok {
+			name.Local, name.Depth, name.Slot = true, 0, slot
+			return
+		}
+		r.globals[name.Value] = true
+		name.Local = false
+		return
+	}
+
+	s := r.functions[len(r.functions)-1]
+	delete(s.pending, name.Value)
+	slot, ok := s.slots[name.Value]
+	if !ok {
+		slot = s.add(name.Value)
+	}
+	name.Local, name.Depth, name.Slot = true, 0, slot
+}
+
+//lookup binds a name that is read, or assigned to when assign is set
+func (r *resolver) lookup(name *ast.Identifier, assign bool) {
+	for i := len(r.functions) - 1; i >= 0; i-- {
+		// a let not reached yet leaves the name to the enclosing scopes here
+		if i == len(r.functions)-1 && r.functions[i].pending[name.Value] {
+			continue
+		}
+		if slot, ok := r.functions[i].slots[name.Value]; ok {
+			name.Local, name.Depth, name.Slot = true, len(r.functions)-1-i, slot
+			return
+		}
+	}
+	if slot, ok := r.global.slots[name.Value]; ok {
+		name.Local, name.Depth, name.Slot = true, len(r.functions), slot
+		return
+	}
+
+	name.Local = false
+	if r.globals[name.Value] {
+		return
+	}
+	if _, ok := r.env.Get(name.Value); ok {
+		return
+	}
+	if _, ok := r.env.Builtin(name.Value); ok {
+		if assign {
+			r.diagnostics = append(r.diagnostics, diagnostic.Diagnostic{
+				Severity: diagnostic.Error,
+				Code:     CodeAssignToBuiltin,
+				Pos:      name.Pos(),
+				End:      name.End(),
+				Message:  fmt.Sprintf("cannot assign to builtin %s", name.Value),
+			})
+		}
+		return
+	}
+
+	d := diagnostic.Diagnostic{
+		Severity: diagnostic.Error,
+		Code:     CodeUndefinedVariable,
+		Pos:      name.Pos(),
+		End:      name.End(),
+		Message:  fmt.Sprintf("undefined variable %s", name.Value),
+	}
+	if r.open && len(r.functions) > 0 && !assign {
+		d.Severity, d.Hint = diagnostic.Warning, LaterGlobalHint
+	}
+	r.diagnostics = append(r.diagnostics, d)
+}
@@ -0,0 +1,113 @@
+package resolver
+
+import (
+	"interpreter/ast"
+	"interpreter/lexer"
+	"interpreter/object"
+	"interpreter/parser"
+	"testing"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+func TestResolveSlots(t *testing.T) {
+	program := parse(t, "let f = fn(a) { let b = a; fn(c) { a + b + c + len } }")
+	if diagnostics := Resolve(program, object.NewEnvironment()); len(diagnostics) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", diagnostics)
+	}
+
+	let := program.Statements[0].(*ast.LetStatement)
+	if let.Name.Local {
+		t.Errorf("global f resolved to a local")
+	}
+
+	outer := let.Value.(*ast.FunctionLiteral)
+	if outer.Slots != 2 {
+		t.Errorf("outer function has %d slots, want 2", outer.Slots)
+	}
+	inner := outer.Body.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	if inner.Slots != 1 {
+		t.Errorf("inner function has %d slots, want 1", inner.Slots)
+	}
+
+	sum := inner.Body.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.InfixExpression)
+	abc := sum.Left.(*ast.InfixExpression)
+	ab := abc.Left.(*ast.InfixExpression)
+
+	tests := []struct {
+		ident *ast.Identifier
+		depth int // -1 for a name looked up by name
+		slot  int
+	}{
+		{ab.Left.(*ast.Identifier), 1, 0},
+		{ab.Right.(*ast.Identifier), 1, 1},
+		{abc.Right.(*ast.Identifier), 0, 0},
+		{sum.Right.(*ast.Identifier), -1, 0},
+	}
+
+	for _, tt := range tests {
+		if tt.ident.Local != (tt.depth >= 0) || (tt.ident.Local && (tt.ident.Depth != tt.depth || tt.ident.Slot != tt.slot)) {
+			t.Errorf("%s resolved to depth %d slot %d, want depth %d slot %d",
+				tt.ident.Value, tt.ident.Depth, tt.ident.Slot, tt.depth, tt.slot)
+		}
+	}
+}
+
+func TestUndefinedVariables(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"let x = 1; x + len([])", nil},
+		{"let f = fn() { g() }; let g = fn() { 1 }", nil},
+		{"let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }", nil},
+		{"let f = fn() { let inner = fn() { inner() }; inner }", nil},
+		{"for (i, x in [1]) { i + x }; try { 1 } catch (e) { e }", nil},
+		{"defined + 1", nil},
+		{"y", []string{"1:1: error[R001]: undefined variable y"}},
+		{"y = 1", []string{"1:1: error[R001]: undefined variable y"}},
+		{"len = 3", []string{"1:1: error[R002]: cannot assign to builtin len"}},
+		{"let f = fn() { len += 1 }", []string{"1:16: error[R002]: cannot assign to builtin len"}},
+		{"let len = 1; len = 3", nil},
+		{"let f = fn(len) { len = 3 }", nil},
+		{"let f = fn(a) { a + b }; c", []string{
+			"1:21: error[R001]: undefined variable b",
+			"1:26: error[R001]: undefined variable c",
+		}},
+		{"let f = fn() { let z = 1 }; z", []string{"1:29: error[R001]: undefined variable z"}},
+		{"let f = fn() { g() }; let h = fn() { let g = 1 }", []string{"1:16: error[R001]: undefined variable g"}},
+		{"let f = fn() { let g = fn() { y }; let y = 2; g() }", nil},
+		{"let f = fn() { let even = fn(n) { n == 0 || odd(n - 1) }; let odd = fn(n) { n != 0 && even(n - 1) } }", nil},
+		{"let x = 1; let f = fn() { let y = x; let g = fn() { x }; let x = 2; y + g() }", nil},
+		{"let f = fn() { let x = x }", []string{"1:24: error[R001]: undefined variable x"}},
+	}
+
+	for _, tt := range tests {
+		env := object.NewEnvironment()
+		env.Set("defined", &object.Integer{Value: 1})
+
+		program := parse(t, tt.input)
+		diagnostics := Resolve(program, env)
+		if program.Resolved != (len(tt.expected) == 0) {
+			t.Errorf("input %q: program.Resolved is %t", tt.input, program.Resolved)
+		}
+		if len(diagnostics) != len(tt.expected) {
+			t.Errorf("input %q: expected %d diagnostics, got %v", tt.input, len(tt.expected), diagnostics)
+			continue
+		}
+		for i, d := range diagnostics {
+			if d.String() != tt.expected[i] {
+				t.Errorf("input %q: diagnostic %d wrong.\nexpected=%q\ngot=%q", tt.input, i, tt.expected[i], d.String())
+			}
+		}
+	}
+}
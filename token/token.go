@@ -1,22 +1,76 @@
 package token
 
+import "fmt"
+
 type TokenType string
 
 type Token struct {
 	Type    TokenType
 	Literal string
+	Pos     Position // position of the first character of the token
+	End     Position // position immediately after the last character of the token
+	Leading []Trivia // comments before the token, only kept when the lexer is asked to
+}
+
+//Trivia is a comment. The parser never sees comments, but a lexer keeping
+//trivia attaches them to the token that follows so that tools such as a
+//formatter or a documentation generator can recover them.
+type Trivia struct {
+	Type    TokenType // LINE_COMMENT or BLOCK_COMMENT
+	Literal string    // the comment including its delimiters
+	Pos     Position
+	End     Position
+}
+
+//Position describes a location in the source. Lines and columns start at 1,
+//the byte offset starts at 0. The zero value is an unknown position.
+type Position struct {
+	Filename string
+	Offset   int
+	Line     int
+	Column   int
+}
+
+//IsValid reports whether the position is known.
+func (p Position) IsValid() bool { return p.Line > 0 }
+
+//String returns the position in the form file:line:column, leaving out the
+//file name if there is none and returning "-" for an unknown position.
+func (p Position) String() string {
+	if !p.IsValid() {
+		if p.Filename != "" {
+			return p.Filename
+		}
+		return "-"
+	}
+	if p.Filename != "" {
+		return fmt.Sprintf("%s:%d:%d", p.Filename, p.Line, p.Column)
+	}
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
 }
 
 const (
 	ILLEGAL = "ILLEGAL"
 	EOF     = "EOF"
 
+	//trivia
+
+	LINE_COMMENT  = "LINE_COMMENT"  // a comment
+	BLOCK_COMMENT = "BLOCK_COMMENT" /* a comment */
+
 	//identifier and literals
 
 	IDENT  = "IDENT"  //add,a,b,x,foo
 	INT    = "INT"    //1,2,3
+	FLOAT  = "FLOAT"  //3.14,1e-9
 	STRING = "STRING" //Hello how are you today?
 
+	//the parts of an interpolated string such as "a${x}b${y}c"
+
+	STRING_START  = "STRING_START"  //"a${
+	STRING_MIDDLE = "STRING_MIDDLE" //}b${
+	STRING_END    = "STRING_END"    //}c"
+
 	//operators
 
 	ASSIGN   = "="
@@ -25,6 +79,12 @@ const (
 	BANG     = "!"
 	ASTERISK = "*"
 	SLASH    = "/"
+	PERCENT  = "%"
+
+	PLUS_ASSIGN     = "+="
+	MINUS_ASSIGN    = "-="
+	ASTERISK_ASSIGN = "*="
+	SLASH_ASSIGN    = "/="
 
 	//Delimiter
 
@@ -49,19 +109,41 @@ const (
 	TRUE     = "TRUE"
 	FALSE    = "FALSE"
 	RETURN   = "RETURN"
+	WHILE    = "WHILE"
+	FOR      = "FOR"
+	IN       = "IN"
+	BREAK    = "BREAK"
+	CONTINUE = "CONTINUE"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	FINALLY  = "FINALLY"
+	THROW    = "THROW"
 
 	EQ     = "=="
 	NOT_EQ = "!="
+	LT_EQ  = "<="
+	GT_EQ  = ">="
+	AND    = "&&"
+	OR     = "||"
 )
 
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
-	"true":   TRUE,
-	"false":  FALSE,
+	"fn":       FUNCTION,
+	"let":      LET,
+	"if":       IF,
+	"else":     ELSE,
+	"return":   RETURN,
+	"true":     TRUE,
+	"false":    FALSE,
+	"while":    WHILE,
+	"for":      FOR,
+	"in":       IN,
+	"break":    BREAK,
+	"continue": CONTINUE,
+	"try":      TRY,
+	"catch":    CATCH,
+	"finally":  FINALLY,
+	"throw":    THROW,
 }
 
 func LookupIdentifier(ident string) TokenType {
@@ -0,0 +1,24 @@
+package vm
+
+import "interpreter/object"
+
+const CELL_OBJ = "CELL"
+
+//cell boxes a local variable once a closure captures it. The local slot and
+//the Free slot of every closure capturing it then hold the same cell, so an
+//assignment through any of them is seen by all. Like iterator it never
+//reaches programs: loads unwrap it.
+type cell struct {
+	value object.Object
+}
+
+func (c *cell) Type() object.ObjectType { return CELL_OBJ }
+func (c *cell) Inspect() string         { return "cell(" + c.value.Inspect() + ")" }
+
+//unwrap returns the value held by obj if it is a cell and obj otherwise
+func unwrap(obj object.Object) object.Object {
+	if c, ok := obj.(*cell); ok {
+		return c.value
+	}
+	return obj
+}
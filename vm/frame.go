@@ -0,0 +1,26 @@
+package vm
+
+import (
+	"interpreter/code"
+	"interpreter/object"
+)
+
+type Frame struct {
+	cl          *object.Closure
+	ip          int
+	basePointer int
+	loops       []int // stack heights recorded by OpLoopEnter for the loops running in this frame
+
+	// the function and offset of the call instruction that started this
+	// frame, for tracebacks
+	callFn *object.CompiledFunction
+	callIP int
+}
+
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	return &Frame{cl: cl, ip: -1, basePointer: basePointer}
+}
+
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
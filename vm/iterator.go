@@ -0,0 +1,62 @@
+package vm
+
+import "interpreter/object"
+
+const ITERATOR_OBJ = "ITERATOR"
+
+//iterator walks an array, string or hash for a for loop. It lives on the
+//stack for the duration of the loop and is never visible to programs.
+type iterator struct {
+	array *object.Array
+	runes []rune // the characters of a string
+	pairs []object.HashPair
+	index int
+}
+
+func (it *iterator) Type() object.ObjectType { return ITERATOR_OBJ }
+func (it *iterator) Inspect() string         { return "iterator" }
+
+func newIterator(collection object.Object) (*iterator, bool) {
+	switch collection := collection.(type) {
+	case *object.Array:
+		return &iterator{array: collection}, true
+	case *object.String:
+		return &iterator{runes: []rune(collection.Value)}, true
+	case *object.Hash:
+		return &iterator{pairs: collection.OrderedPairs()}, true
+	default:
+		return nil, false
+	}
+}
+
+//next returns the key and value of the next element, the key being the index
+//for arrays and strings. ok is false once the collection is exhausted.
+func (it *iterator) next() (key, value object.Object, ok bool) {
+	i := it.index
+
+	switch {
+	case it.array != nil:
+		if i >= len(it.array.Elements) {
+			return nil, nil, false
+		}
+		key, value = &object.Integer{Value: int64(i)}, it.array.Elements[i]
+	case it.runes != nil:
+		if i >= len(it.runes) {
+			return nil, nil, false
+		}
+		key, value = &object.Integer{Value: int64(i)}, &object.String{Value: string(it.runes[i])}
+	default:
+		if i >= len(it.pairs) {
+			return nil, nil, false
+		}
+		key, value = it.pairs[i].Key, it.pairs[i].Value
+	}
+
+	it.index++
+	return key, value, true
+}
+
+//isHash reports whether a single loop variable receives the key rather than the value
+func (it *iterator) isHash() bool {
+	return it.array == nil && it.runes == nil
+}
@@ -0,0 +1,877 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"interpreter/code"
+	"interpreter/compiler"
+	"interpreter/object"
+)
+
+//StackSize is the initial size of the value stack, the stack and the frames
+//grow with the calls and the budget alone bounds their depth
+const StackSize = 2048
+const GlobalsSize = 65536
+
+var (
+	NULL  = object.NULL
+	TRUE  = object.TRUE
+	FALSE = object.FALSE
+)
+
+type VM struct {
+	constants   []object.Object
+	globals     []object.Object
+	globalNames []string
+
+	stack      []object.Object
+	sp         int // always points to the next free slot, the top of the stack is stack[sp-1]
+	lastPopped object.Object
+
+	frames      []*Frame
+	framesIndex int
+	handlers    []handler // the try blocks running, innermost last
+
+	ctx    *object.Context // passed to builtins, bound to budget
+	budget *object.Budget
+}
+
+//handler is the state an OpTry saved to return to on an error
+type handler struct {
+	frames int // vm.framesIndex
+	sp     int
+	loops  int // the number of loops running in the frame
+	target int // the instruction handling the error
+}
+
+func New(bytecode *compiler.Bytecode) *VM {
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		SourceMap:    bytecode.SourceMap,
+	}
+	mainClosure := &object.Closure{Fn: mainFn}
+	mainFrame := NewFrame(mainClosure, 0)
+
+	frames := []*Frame{mainFrame}
+
+	budget := object.NewBudget(context.Background(), object.Limits{})
+
+	return &VM{
+		constants:   bytecode.Constants,
+		globals:     make([]object.Object, GlobalsSize),
+		globalNames: bytecode.GlobalNames,
+
+		stack: make([]object.Object, StackSize),
+		sp:    0,
+
+		frames:      frames,
+		framesIndex: 1,
+
+		ctx:    object.DefaultContext.WithBudget(budget),
+		budget: budget,
+	}
+}
+
+//NewWithGlobalsState returns a vm that shares its globals with an earlier
+//run, which is how the REPL keeps bindings between lines
+func NewWithGlobalsState(bytecode *compiler.Bytecode, s []object.Object) *VM {
+	vm := New(bytecode)
+	vm.globals = s
+	return vm
+}
+
+//SetContext sets the context builtins called by the program run in
+func (vm *VM) SetContext(ctx *object.Context) {
+	vm.ctx = ctx.WithBudget(vm.budget)
+}
+
+//SetBudget charges the program to budget, which stops it with an error of a
+//limit kind once its context is done or the program exceeds its limits
+func (vm *VM) SetBudget(budget *object.Budget) {
+	vm.budget = budget
+	vm.ctx = vm.ctx.WithBudget(budget)
+}
+
+//NewGlobalsStore returns an empty globals store for NewWithGlobalsState
+func NewGlobalsStore() []object.Object {
+	return make([]object.Object, GlobalsSize)
+}
+
+//LastPoppedStackElem returns the value of the last expression statement that
+//ran, or the value of a top level return, nil if there was none
+func (vm *VM) LastPoppedStackElem() object.Object {
+	return vm.lastPopped
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.framesIndex-1]
+}
+
+func (vm *VM) pushFrame(f *Frame) error {
+	if err := vm.budget.Enter(); err != nil {
+		return vm.stampError(err)
+	}
+	if vm.framesIndex == len(vm.frames) {
+		vm.frames = append(vm.frames, f)
+	} else {
+		vm.frames[vm.framesIndex] = f
+	}
+	vm.framesIndex++
+	return nil
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.budget.Leave()
+	vm.framesIndex--
+
+	// try blocks of the returning function end with it
+	for len(vm.handlers) > 0 && vm.handlers[len(vm.handlers)-1].frames > vm.framesIndex {
+		vm.handlers = vm.handlers[:len(vm.handlers)-1]
+	}
+	return vm.frames[vm.framesIndex]
+}
+
+//Run executes the bytecode until the end of the main program. Runtime errors
+//are returned as *object.Error carrying the position of the failing code. A
+//Go panic while running, for instance in a builtin, is returned as an error
+//too so that a host embedding the vm keeps running.
+func (vm *VM) Run() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = vm.newError("internal error: %v", r)
+		}
+	}()
+
+	return vm.run()
+}
+
+func (vm *VM) run() error {
+	var ip int
+	var ins code.Instructions
+	var op code.Opcode
+
+	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		vm.currentFrame().ip++
+
+		if err := vm.budget.Step(); err != nil {
+			return vm.stampError(err)
+		}
+
+		ip = vm.currentFrame().ip
+		ins = vm.currentFrame().Instructions()
+		op = code.Opcode(ins[ip])
+
+		var err error
+
+		switch op {
+		case code.OpConstant:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			err = vm.push(vm.constants[constIndex])
+
+		case code.OpPop:
+			vm.lastPopped = vm.pop()
+
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod:
+			err = vm.executeBinaryOperation(op)
+
+		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan, code.OpLessEqual, code.OpGreaterEqual:
+			err = vm.executeComparison(op)
+
+		case code.OpTrue:
+			err = vm.push(TRUE)
+
+		case code.OpFalse:
+			err = vm.push(FALSE)
+
+		case code.OpNull:
+			err = vm.push(NULL)
+
+		case code.OpBang:
+			err = vm.executeBangOperator()
+
+		case code.OpMinus:
+			err = vm.executeMinusOperator()
+
+		case code.OpJump:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip = pos - 1
+
+		case code.OpJumpNotTruthy:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			condition := vm.pop()
+			if !isTruthy(condition) {
+				vm.currentFrame().ip = pos - 1
+			}
+
+		case code.OpSetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			vm.globals[globalIndex] = vm.pop()
+
+		case code.OpGetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			global := vm.globals[globalIndex]
+			if global == nil {
+				err = vm.newError("identifier not found:%s", vm.globalName(int(globalIndex)))
+				break
+			}
+			err = vm.push(global)
+
+		case code.OpSetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			slot := frame.basePointer + int(localIndex)
+			if c, ok := vm.stack[slot].(*cell); ok {
+				c.value = vm.pop()
+			} else {
+				vm.stack[slot] = vm.pop()
+			}
+
+		case code.OpGetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			local := unwrap(vm.stack[frame.basePointer+int(localIndex)])
+			if local == nil {
+				err = vm.newError("identifier not found:%s", slotName(frame.cl.Fn.LocalNames, int(localIndex)))
+				break
+			}
+			err = vm.push(local)
+
+		case code.OpCaptureLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			slot := frame.basePointer + int(localIndex)
+			c, ok := vm.stack[slot].(*cell)
+			if !ok {
+				c = &cell{value: vm.stack[slot]}
+				vm.stack[slot] = c
+			}
+			err = vm.push(c)
+
+		case code.OpGetBuiltin:
+			builtinIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			err = vm.push(object.Builtins[builtinIndex].Builtin)
+
+		case code.OpGetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			cl := vm.currentFrame().cl
+			free := unwrap(cl.Free[freeIndex])
+			if free == nil {
+				err = vm.newError("identifier not found:%s", slotName(cl.Fn.FreeNames, int(freeIndex)))
+				break
+			}
+			err = vm.push(free)
+
+		case code.OpSetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			free := vm.currentFrame().cl.Free
+			if c, ok := free[freeIndex].(*cell); ok {
+				c.value = vm.pop()
+			} else {
+				free[freeIndex] = vm.pop()
+			}
+
+		case code.OpCaptureFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			err = vm.push(vm.currentFrame().cl.Free[freeIndex])
+
+		case code.OpCurrentClosure:
+			err = vm.push(vm.currentFrame().cl)
+
+		case code.OpArray:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			array := vm.buildArray(vm.sp-numElements, vm.sp)
+			vm.sp = vm.sp - numElements
+
+			err = vm.pushNew(array)
+
+		case code.OpInterpolate:
+			numParts := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			str := object.Interpolate(vm.stack[vm.sp-numParts : vm.sp])
+			vm.sp = vm.sp - numParts
+
+			err = vm.pushNew(str)
+
+		case code.OpHash:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			var hash object.Object
+			hash, err = vm.buildHash(vm.sp-numElements, vm.sp)
+			if err != nil {
+				break
+			}
+			vm.sp = vm.sp - numElements
+
+			err = vm.pushNew(hash)
+
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+
+			err = vm.executeIndexExpression(left, index)
+
+		case code.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+
+			size := object.SizeOf(left)
+			result := object.SetIndex(left, index, value)
+			if chargeErr := vm.budget.Charge(object.SizeOf(left) - size); chargeErr != nil {
+				err = vm.stampError(chargeErr)
+				break
+			}
+			err = vm.pushResult(result)
+
+		case code.OpDup:
+			n := int(code.ReadUint8(ins[ip+1:]))
+			vm.currentFrame().ip += 1
+
+			start := vm.sp - n
+			for i := 0; i < n && err == nil; i++ {
+				err = vm.push(vm.stack[start+i])
+			}
+
+		case code.OpCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			err = vm.executeCall(int(numArgs))
+
+		case code.OpTailCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			err = vm.executeTailCall(int(numArgs))
+
+		case code.OpReturnValue:
+			returnValue := vm.pop()
+
+			if vm.framesIndex == 1 {
+				// a return at the top level ends the program
+				vm.lastPopped = returnValue
+				return nil
+			}
+
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+
+			err = vm.push(returnValue)
+
+		case code.OpReturn:
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+
+			err = vm.push(NULL)
+
+		case code.OpClosure:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			numFree := code.ReadUint8(ins[ip+3:])
+			vm.currentFrame().ip += 3
+
+			err = vm.pushClosure(int(constIndex), int(numFree))
+
+		case code.OpLoopEnter:
+			frame := vm.currentFrame()
+			frame.loops = append(frame.loops, vm.sp)
+
+		case code.OpLoopExit:
+			frame := vm.currentFrame()
+			frame.loops = frame.loops[:len(frame.loops)-1]
+
+		case code.OpLoopJump:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+
+			frame := vm.currentFrame()
+			vm.sp = frame.loops[len(frame.loops)-1]
+			frame.ip = pos - 1
+
+		case code.OpIterInit:
+			collection := vm.pop()
+
+			it, ok := newIterator(collection)
+			if !ok {
+				err = vm.newError("cannot iterate over %s", collection.Type())
+				break
+			}
+			err = vm.push(it)
+
+		case code.OpIterNext:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			numNames := code.ReadUint8(ins[ip+3:])
+			vm.currentFrame().ip += 3
+
+			it := vm.stack[vm.sp-1].(*iterator)
+			key, value, ok := it.next()
+			if !ok {
+				vm.currentFrame().ip = pos - 1
+				break
+			}
+
+			if numNames == 2 {
+				err = vm.push(key)
+				if err == nil {
+					err = vm.push(value)
+				}
+			} else if it.isHash() {
+				err = vm.push(key)
+			} else if it.runes != nil {
+				err = vm.pushNew(value)
+			} else {
+				err = vm.push(value)
+			}
+
+		case code.OpTry:
+			target := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			vm.handlers = append(vm.handlers, handler{
+				frames: vm.framesIndex,
+				sp:     vm.sp,
+				loops:  len(vm.currentFrame().loops),
+				target: target,
+			})
+
+		case code.OpEndTry:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+		case code.OpThrow:
+			thrown := object.Throw(vm.pop())
+			// a caught error thrown again goes on to the calls outside the try block
+			thrown.Frames = append(thrown.Frames, vm.traceback()...)
+			err = vm.stampError(thrown)
+
+		default:
+			def, lookupErr := code.Lookup(byte(op))
+			if lookupErr != nil {
+				return lookupErr
+			}
+			return fmt.Errorf("opcode %s not implemented", def.Name)
+		}
+
+		if err != nil {
+			if vm.catch(err) {
+				continue
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+//catch hands err to the innermost try block and reports whether there was one
+func (vm *VM) catch(err error) bool {
+	errObj, ok := err.(*object.Error)
+	if !ok || errObj.IsLimit() || len(vm.handlers) == 0 {
+		return false
+	}
+	vm.stampError(errObj)
+
+	h := vm.handlers[len(vm.handlers)-1]
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+	// the trace only keeps the calls the error escaped from, as the
+	// evaluator's does
+	if keep := len(errObj.Frames) - (h.frames - 1); keep >= 0 {
+		errObj.Frames = errObj.Frames[:keep]
+	}
+
+	for vm.framesIndex > h.frames {
+		vm.popFrame()
+	}
+	frame := vm.currentFrame()
+	frame.loops = frame.loops[:h.loops]
+	frame.ip = h.target - 1
+	vm.sp = h.sp
+
+	return vm.push(&object.ErrorValue{Err: errObj}) == nil
+}
+
+func (vm *VM) push(o object.Object) error {
+	vm.grow(vm.sp + 1)
+	vm.stack[vm.sp] = o
+	vm.sp++
+
+	return nil
+}
+
+//grow makes room for size values on the stack
+func (vm *VM) grow(size int) {
+	if size <= len(vm.stack) {
+		return
+	}
+	stack := make([]object.Object, 2*size)
+	copy(stack, vm.stack[:vm.sp])
+	vm.stack = stack
+}
+
+func (vm *VM) pop() object.Object {
+	o := vm.stack[vm.sp-1]
+	vm.sp--
+	return o
+}
+
+func (vm *VM) executeBinaryOperation(op code.Opcode) error {
+	right := vm.pop()
+	left := vm.pop()
+
+	leftType := left.Type()
+	rightType := right.Type()
+
+	switch {
+	case object.IsInteger(left) && object.IsInteger(right):
+		return vm.pushResult(object.IntegerInfix(operatorSymbol(op), left, right))
+	case object.IsNumber(left) && object.IsNumber(right):
+		return vm.pushResult(object.FloatInfix(operatorSymbol(op), left, right))
+	case leftType != rightType:
+		return vm.newError("type mismatch: %s %s %s", leftType, operatorSymbol(op), rightType)
+	case leftType == object.STRING_OBJ && rightType == object.STRING_OBJ:
+		return vm.pushNew(object.StringInfix(operatorSymbol(op), left, right))
+	default:
+		return vm.newError("unknown operator: %s %s %s", leftType, operatorSymbol(op), rightType)
+	}
+}
+
+func (vm *VM) executeComparison(op code.Opcode) error {
+	right := vm.pop()
+	left := vm.pop()
+
+	switch {
+	case object.IsInteger(left) && object.IsInteger(right):
+		return vm.pushResult(object.IntegerInfix(operatorSymbol(op), left, right))
+	case object.IsNumber(left) && object.IsNumber(right):
+		return vm.pushResult(object.FloatInfix(operatorSymbol(op), left, right))
+	case op == code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(object.Equal(left, right)))
+	case op == code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(!object.Equal(left, right)))
+	case left.Type() != right.Type():
+		return vm.newError("type mismatch: %s %s %s", left.Type(), operatorSymbol(op), right.Type())
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		return vm.pushResult(object.StringInfix(operatorSymbol(op), left, right))
+	default:
+		return vm.newError("unknown operator: %s %s %s", left.Type(), operatorSymbol(op), right.Type())
+	}
+}
+
+func (vm *VM) executeBangOperator() error {
+	operand := vm.pop()
+
+	switch operand {
+	case TRUE:
+		return vm.push(FALSE)
+	case FALSE:
+		return vm.push(TRUE)
+	case NULL:
+		return vm.push(TRUE)
+	default:
+		return vm.push(FALSE)
+	}
+}
+
+func (vm *VM) executeMinusOperator() error {
+	operand := vm.pop()
+
+	return vm.pushResult(object.Negate(operand))
+}
+
+func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
+	elements := make([]object.Object, endIndex-startIndex)
+
+	for i := startIndex; i < endIndex; i++ {
+		elements[i-startIndex] = vm.stack[i]
+	}
+
+	return &object.Array{Elements: elements}
+}
+
+func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
+	hashedPairs := make(map[object.HashKey]object.HashPair)
+
+	for i := startIndex; i < endIndex; i += 2 {
+		key := vm.stack[i]
+		value := vm.stack[i+1]
+
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return nil, vm.newError("unusable as hash key: %s", key.Type())
+		}
+
+		hashedPairs[hashKey.HashKey()] = object.HashPair{Key: key, Value: value}
+	}
+
+	return &object.Hash{Pairs: hashedPairs}, nil
+}
+
+func (vm *VM) executeIndexExpression(left, index object.Object) error {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		return vm.executeArrayIndex(left, index)
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		return vm.pushNew(object.StringIndex(left, index))
+	case left.Type() == object.HASH_OBJ:
+		return vm.executeHashIndex(left, index)
+	case left.Type() == object.ERROR_VALUE_OBJ:
+		return vm.push(left.(*object.ErrorValue).Field(index))
+	default:
+		return vm.newError("index operator not supported: %s", left.Type())
+	}
+}
+
+func (vm *VM) executeArrayIndex(array, index object.Object) error {
+	arrayObject := array.(*object.Array)
+	i := index.(*object.Integer).Value
+	max := int64(len(arrayObject.Elements) - 1)
+
+	if i < 0 || i > max {
+		return vm.push(NULL)
+	}
+
+	return vm.push(arrayObject.Elements[i])
+}
+
+func (vm *VM) executeHashIndex(hash, index object.Object) error {
+	hashObject := hash.(*object.Hash)
+
+	key, ok := index.(object.Hashable)
+	if !ok {
+		return vm.newError("unusable as hash key: %s", index.Type())
+	}
+
+	pair, ok := hashObject.Pairs[key.HashKey()]
+	if !ok {
+		return vm.push(NULL)
+	}
+
+	return vm.push(pair.Value)
+}
+
+func (vm *VM) executeCall(numArgs int) error {
+	callee := vm.stack[vm.sp-1-numArgs]
+	switch callee := callee.(type) {
+	case *object.Closure:
+		return vm.callClosure(callee, numArgs)
+	case *object.Builtin:
+		return vm.callBuiltin(callee, numArgs)
+	default:
+		return vm.newError("not a function: %s", callee.Type())
+	}
+}
+
+func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
+	if numArgs != cl.Fn.NumParameters {
+		return vm.newError("wrong number of arguments: want=%d, got=%d", cl.Fn.NumParameters, numArgs)
+	}
+
+	caller := vm.currentFrame()
+	frame := NewFrame(cl, vm.sp-numArgs)
+	frame.callFn, frame.callIP = caller.cl.Fn, caller.ip
+	if err := vm.pushFrame(frame); err != nil {
+		return err
+	}
+	vm.grow(frame.basePointer + cl.Fn.NumLocals)
+
+	// clear the locals so a cell left behind by an earlier call is not mistaken
+	// for a captured local of this one
+	for i := vm.sp; i < frame.basePointer+cl.Fn.NumLocals; i++ {
+		vm.stack[i] = nil
+	}
+	vm.sp = frame.basePointer + cl.Fn.NumLocals
+	return nil
+}
+
+//executeTailCall calls a closure reusing the current frame
+func (vm *VM) executeTailCall(numArgs int) error {
+	cl, ok := vm.stack[vm.sp-1-numArgs].(*object.Closure)
+	if !ok {
+		// builtins keep the frame, the instructions after OpTailCall return their value
+		return vm.executeCall(numArgs)
+	}
+	if numArgs != cl.Fn.NumParameters {
+		return vm.newError("wrong number of arguments: want=%d, got=%d", cl.Fn.NumParameters, numArgs)
+	}
+
+	frame := vm.currentFrame()
+	vm.grow(frame.basePointer + cl.Fn.NumLocals)
+
+	// the callee and its arguments replace those of the current call
+	copy(vm.stack[frame.basePointer-1:], vm.stack[vm.sp-1-numArgs:vm.sp])
+	callFn, callIP := frame.cl.Fn, frame.ip
+	*frame = Frame{cl: cl, ip: -1, basePointer: frame.basePointer, callFn: callFn, callIP: callIP}
+
+	for i := frame.basePointer + numArgs; i < frame.basePointer+cl.Fn.NumLocals; i++ {
+		vm.stack[i] = nil
+	}
+	vm.sp = frame.basePointer + cl.Fn.NumLocals
+	return nil
+}
+
+func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
+	args := vm.stack[vm.sp-numArgs : vm.sp]
+
+	result := builtin.Fn(vm.ctx, args...)
+	vm.sp = vm.sp - numArgs - 1
+
+	if result == nil {
+		return vm.push(NULL)
+	}
+	return vm.pushResult(result)
+}
+
+//pushResult pushes the result of an operation implemented in the object
+//package, turning it into the vm's error if it is one
+func (vm *VM) pushResult(result object.Object) error {
+	if err, ok := result.(*object.Error); ok {
+		return vm.stampError(err)
+	}
+	return vm.push(result)
+}
+
+//pushNew pushes obj, a value the vm has just built, charging it to the budget
+func (vm *VM) pushNew(obj object.Object) error {
+	if err := vm.budget.Charge(object.SizeOf(obj)); err != nil {
+		return vm.stampError(err)
+	}
+	return vm.pushResult(obj)
+}
+
+func (vm *VM) pushClosure(constIndex int, numFree int) error {
+	constant := vm.constants[constIndex]
+	function, ok := constant.(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("not a function: %+v", constant)
+	}
+
+	free := make([]object.Object, numFree)
+	for i := 0; i < numFree; i++ {
+		free[i] = vm.stack[vm.sp-numFree+i]
+	}
+	vm.sp = vm.sp - numFree
+
+	closure := &object.Closure{Fn: function, Free: free}
+	return vm.push(closure)
+}
+
+func (vm *VM) globalName(index int) string {
+	if index < len(vm.globalNames) {
+		return vm.globalNames[index]
+	}
+	return fmt.Sprintf("global#%d", index)
+}
+
+//slotName returns the name of a local or free variable of a function
+func slotName(names []string, index int) string {
+	if index < len(names) {
+		return names[index]
+	}
+	return fmt.Sprintf("slot#%d", index)
+}
+
+//newError returns a runtime error located at the instruction being executed
+func (vm *VM) newError(format string, a ...interface{}) error {
+	return vm.stampError(&object.Error{Message: fmt.Sprintf(format, a...)})
+}
+
+func (vm *VM) stampError(err *object.Error) error {
+	if !err.Pos.IsValid() {
+		frame := vm.currentFrame()
+		err.Pos = frame.cl.Fn.SourceMap.Lookup(frame.ip)
+	}
+	if err.Frames == nil {
+		err.Frames = vm.traceback()
+	}
+	return err
+}
+
+//traceback returns the function calls in progress, innermost first
+func (vm *VM) traceback() []object.Frame {
+	var frames []object.Frame
+	for i := vm.framesIndex - 1; i > 0; i-- {
+		callee := vm.frames[i]
+		site := callee.callFn.SourceMap.Entry(callee.callIP)
+
+		args := make([]object.ObjectType, callee.cl.Fn.NumParameters)
+		for j := range args {
+			arg := vm.stack[callee.basePointer+j]
+			if c, ok := arg.(*cell); ok {
+				arg = c.value
+			}
+			args[j] = arg.Type()
+		}
+		frames = append(frames, object.Frame{Function: site.Callee, Pos: site.Pos, Args: args})
+	}
+	return frames
+}
+
+func isTruthy(obj object.Object) bool {
+	switch obj := obj.(type) {
+	case *object.Boolean:
+		return obj.Value
+	case *object.Null:
+		return false
+	default:
+		return true
+	}
+}
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	if input {
+		return TRUE
+	}
+	return FALSE
+}
+
+//operatorSymbol returns the source operator of a binary opcode for error messages
+func operatorSymbol(op code.Opcode) string {
+	switch op {
+	case code.OpAdd:
+		return "+"
+	case code.OpSub:
+		return "-"
+	case code.OpMul:
+		return "*"
+	case code.OpDiv:
+		return "/"
+	case code.OpMod:
+		return "%"
+	case code.OpEqual:
+		return "=="
+	case code.OpNotEqual:
+		return "!="
+	case code.OpGreaterThan:
+		return ">"
+	case code.OpLessThan:
+		return "<"
+	case code.OpLessEqual:
+		return "<="
+	case code.OpGreaterEqual:
+		return ">="
+	default:
+		return fmt.Sprintf("op(%d)", op)
+	}
+}
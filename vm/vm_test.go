@@ -0,0 +1,830 @@
+package vm
+
+import (
+	"bytes"
+	"context"
+	"interpreter/compiler"
+	"interpreter/diagnostic"
+	"interpreter/lexer"
+	"interpreter/object"
+	"interpreter/parser"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+//these cases mirror evaluator_test.go, both engines must agree on them
+
+type vmTestCase struct {
+	input    string
+	expected interface{}
+}
+
+//vmError is the expected outcome of a program that fails at runtime or compile time
+type vmError string
+
+func parse(t *testing.T, input string) *parser.Parser {
+	t.Helper()
+	return parser.New(lexer.New(input))
+}
+
+func testRun(t *testing.T, input string) (object.Object, error) {
+	t.Helper()
+
+	p := parse(t, input)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		return nil, err
+	}
+
+	vm := New(comp.Bytecode())
+	if err := vm.Run(); err != nil {
+		return nil, err
+	}
+	return vm.LastPoppedStackElem(), nil
+}
+
+func runVmTests(t *testing.T, tests []vmTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		result, err := testRun(t, tt.input)
+
+		if expected, ok := tt.expected.(vmError); ok {
+			if err == nil {
+				t.Errorf("input %q: expected error %q, got result %v", tt.input, expected, result)
+				continue
+			}
+			if message := errorMessage(err); message != string(expected) {
+				t.Errorf("input %q: wrong error. expected=%q, got=%q", tt.input, expected, message)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %s", tt.input, err)
+			continue
+		}
+		testExpectedObject(t, tt.input, tt.expected, result)
+	}
+}
+
+func errorMessage(err error) string {
+	switch err := err.(type) {
+	case *object.Error:
+		return err.Message
+	case diagnostic.Diagnostic:
+		return err.Message
+	default:
+		return err.Error()
+	}
+}
+
+func testExpectedObject(t *testing.T, input string, expected interface{}, actual object.Object) {
+	t.Helper()
+
+	switch expected := expected.(type) {
+	case int:
+		testIntegerObject(t, input, int64(expected), actual)
+	case float64:
+		float, ok := actual.(*object.Float)
+		if !ok {
+			t.Errorf("input %q: object is not Float. got=%T (%+v)", input, actual, actual)
+			return
+		}
+		if float.Value != expected {
+			t.Errorf("input %q: wrong value. want=%g, got=%g", input, expected, float.Value)
+		}
+	case *big.Int:
+		bigInt, ok := actual.(*object.BigInt)
+		if !ok {
+			t.Errorf("input %q: object is not BigInt. got=%T (%+v)", input, actual, actual)
+			return
+		}
+		if bigInt.Value.Cmp(expected) != 0 {
+			t.Errorf("input %q: wrong value. want=%s, got=%s", input, expected, bigInt.Value)
+		}
+	case bool:
+		testBooleanObject(t, input, expected, actual)
+	case string:
+		str, ok := actual.(*object.String)
+		if !ok {
+			t.Errorf("input %q: object is not String. got=%T (%+v)", input, actual, actual)
+			return
+		}
+		if str.Value != expected {
+			t.Errorf("input %q: wrong value. want=%q, got=%q", input, expected, str.Value)
+		}
+	case []int:
+		array, ok := actual.(*object.Array)
+		if !ok {
+			t.Errorf("input %q: object not Array: %T (%+v)", input, actual, actual)
+			return
+		}
+		if len(array.Elements) != len(expected) {
+			t.Errorf("input %q: wrong num of elements. want=%d, got=%d", input, len(expected), len(array.Elements))
+			return
+		}
+		for i, expectedElem := range expected {
+			testIntegerObject(t, input, int64(expectedElem), array.Elements[i])
+		}
+	case map[object.HashKey]int64:
+		hash, ok := actual.(*object.Hash)
+		if !ok {
+			t.Errorf("input %q: object is not Hash. got=%T (%+v)", input, actual, actual)
+			return
+		}
+		if len(hash.Pairs) != len(expected) {
+			t.Errorf("input %q: hash has wrong number of Pairs. want=%d, got=%d", input, len(expected), len(hash.Pairs))
+			return
+		}
+		for expectedKey, expectedValue := range expected {
+			pair, ok := hash.Pairs[expectedKey]
+			if !ok {
+				t.Errorf("input %q: no pair for given key in Pairs", input)
+				continue
+			}
+			testIntegerObject(t, input, expectedValue, pair.Value)
+		}
+	case *object.Null:
+		if actual != NULL {
+			t.Errorf("input %q: object is not Null: %T (%+v)", input, actual, actual)
+		}
+	default:
+		t.Fatalf("input %q: unsupported expectation %T", input, expected)
+	}
+}
+
+func testIntegerObject(t *testing.T, input string, expected int64, actual object.Object) {
+	t.Helper()
+
+	result, ok := actual.(*object.Integer)
+	if !ok {
+		t.Errorf("input %q: object is not Integer. got=%T (%+v)", input, actual, actual)
+		return
+	}
+	if result.Value != expected {
+		t.Errorf("input %q: object has wrong value. got=%d, want=%d", input, result.Value, expected)
+	}
+}
+
+func testBooleanObject(t *testing.T, input string, expected bool, actual object.Object) {
+	t.Helper()
+
+	result, ok := actual.(*object.Boolean)
+	if !ok {
+		t.Errorf("input %q: object is not Boolean. got=%T (%+v)", input, actual, actual)
+		return
+	}
+	if result.Value != expected {
+		t.Errorf("input %q: object has wrong value. got=%t, want=%t", input, result.Value, expected)
+	}
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"5", 5},
+		{"10", 10},
+		{"-5", -5},
+		{"-10", -10},
+		{"5 + 5 + 10 - 5", 15},
+		{"2 * 2 * 2 * 2 * 2", 32},
+		{"-50 + 100 - 50", 0},
+		{"5 * 2 + 10", 20},
+		{"50 / 2 * 2 + 10", 60},
+		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBooleanExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"true", true},
+		{"false", false},
+		{"1 < 2", true},
+		{"1 > 2", false},
+		{"1 < 1", false},
+		{"1 > 1", false},
+		{"1 == 1", true},
+		{"1 != 1", false},
+		{"1 == 2", false},
+		{"1 != 2", true},
+		{"true == true", true},
+		{"false == false", true},
+		{"true == false", false},
+		{"true != false", true},
+		{"false != true", true},
+		{"(1 < 2) == true", true},
+		{"(1 < 2) == false", false},
+		{"(1 > 2) == true", false},
+		{"(1 > 2) == false", true},
+		{"!true", false},
+		{"!false", true},
+		{"!5", false},
+		{"!!true", true},
+		{"!!false", false},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []vmTestCase{
+		{"if (true) {10}", 10},
+		{"if (false) {10}", NULL},
+		{"if (1) {10}", 10},
+		{"if (1 < 2) {10}", 10},
+		{"if (1 > 2) {10} else {20}", 20},
+		{"if (true) {}", NULL},
+		{"if (true) { let a = 1; }", NULL},
+		{"if ((if (false) { 10 })) { 10 } else { 20 }", 20},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestReturnStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{"2;return 10;", 10},
+		{"return 2*5; 9;", 10},
+		{"if (10 > 1) { if (10 > 1) { return 10; } return 1; }", 10},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestErrorHandling(t *testing.T) {
+	tests := []vmTestCase{
+		{"5 + true", vmError("type mismatch: INTEGER + BOOLEAN")},
+		{"5 + true; 5", vmError("type mismatch: INTEGER + BOOLEAN")},
+		{"-true", vmError("unknown operator: -BOOLEAN")},
+		{"true + false", vmError("unknown operator: BOOLEAN + BOOLEAN")},
+		{"5; true + false; 5", vmError("unknown operator: BOOLEAN + BOOLEAN")},
+		{"if (10 > 1) {true + false}", vmError("unknown operator: BOOLEAN + BOOLEAN")},
+		{`"Hello" - "World!"`, vmError("unknown operator: STRING - STRING")},
+		{
+			`if (10 > 1) {
+					if (10 > 1) {
+						return true + false;
+					}
+				  return 1;
+				 }
+				`,
+			vmError("unknown operator: BOOLEAN + BOOLEAN"),
+		},
+		{"foobar", vmError("undefined variable foobar")},
+		{`{"name": "Monkey"}[fn(x) { x }];`, vmError("unusable as hash key: FUNCTION")},
+		{"let a = a;", vmError("identifier not found:a")},
+		{"1(2)", vmError("not a function: INTEGER")},
+		{"fn(a, b) { a }(1)", vmError("wrong number of arguments: want=2, got=1")},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input        string
+		expectedLine int
+		expectedCol  int
+	}{
+		{"5 + true", 1, 3},
+		{"let a = 1;\n  -true", 2, 3},
+		{"let f = fn(x) {\n  x + true\n};\nf(1)", 2, 5},
+		{"len(1)", 1, 1},
+		{"[1, 2][fn(x){x}]", 1, 7},
+	}
+
+	for _, tt := range tests {
+		_, err := testRun(t, tt.input)
+		errObj, ok := err.(*object.Error)
+		if !ok {
+			t.Errorf("input %q: no runtime error returned. got=%T (%v)", tt.input, err, err)
+			continue
+		}
+		if errObj.Pos.Line != tt.expectedLine || errObj.Pos.Column != tt.expectedCol {
+			t.Errorf("wrong error position for %q. expected=%d:%d, got=%s", tt.input, tt.expectedLine, tt.expectedCol, errObj.Pos)
+		}
+	}
+}
+
+func TestLetStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = 5; a;", 5},
+		{"let a = 5 * 5; a;", 25},
+		{"let a= 5; let b = a; b;", 5},
+		{"let a = 5; let b = a; let c= a + b + 5; c;", 15},
+		{"let a = 1; let a = a + 1; a", 2},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestFunctionApplication(t *testing.T) {
+	tests := []vmTestCase{
+		{"let identity = fn(x) { x; }; identity(5);", 5},
+		{"let identity = fn(x) { return x; }; identity(5);", 5},
+		{"let double = fn(x) { return x * 2 }; double(5);", 10},
+		{"let add = fn(x, y) { return x + y; }; add(5, 5);", 10},
+		{"let add = fn(x, y) { return x + y; }; add(5+5,add(5,5));", 20},
+		{"fn(x) { x; }(5)", 5},
+		{"fn() { }()", NULL},
+		{"let f = fn() { let a = 1; }; f()", NULL},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			`
+	let newAdder = fn(x) {
+		fn(y) {x + y};
+	};
+
+	let addTwo = newAdder(2);
+	addTwo(2);`,
+			4,
+		},
+		{
+			`
+	let newClosure = fn(a, b) {
+		let one = fn() { a; };
+		let two = fn() { b; };
+		fn() { one() + two(); };
+	};
+	let closure = newClosure(9, 90);
+	closure();`,
+			99,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			`
+	let countDown = fn(x) {
+		if (x == 0) {
+			return 0;
+		} else {
+			countDown(x - 1);
+		}
+	};
+	countDown(10);`,
+			0,
+		},
+		{
+			`
+	let wrapper = fn() {
+		let fib = fn(x) {
+			if (x < 2) { return x; }
+			fib(x - 1) + fib(x - 2)
+		};
+		fib(15);
+	};
+	wrapper();`,
+			610,
+		},
+		{
+			`
+	let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+	let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+	isEven(10);`,
+			true,
+		},
+		{"let f = fn() { 1 + f() }; f()", vmError("stack overflow")},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestStrings(t *testing.T) {
+	tests := []vmTestCase{
+		{`"Hello World!"`, "Hello World!"},
+		{`"Hello" + " " + "World!"`, "Hello World!"},
+		{`"tab\tquote\"\u{263A}"`, "tab\tquote\"\u263a"},
+		{"`raw\n\\n`", "raw\n\\n"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestInterpolatedStrings(t *testing.T) {
+	tests := []vmTestCase{
+		{`let n = 41; "count: ${n + 1}"`, "count: 42"},
+		{`"${1.5} ${true} ${[1, "a"]} ${if (false) { 1 }}"`, "1.5 true [1, a] null"},
+		{`let name = "x"; "<${"${name}!"}>"`, "<x!>"},
+		{`"${len("abc")}${"d"}"`, "3d"},
+		{`"a ${1 + true} b"`, vmError("type mismatch: INTEGER + BOOLEAN")},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestUnicodeStrings(t *testing.T) {
+	tests := []vmTestCase{
+		{`len("héllo")`, 5},
+		{`len("日本語")`, 3},
+		{`"héllo"[1]`, "é"},
+		{`"日本語"[2]`, "語"},
+		{`"日本語"[3]`, NULL},
+		{`"abc"[-1]`, NULL},
+		{`let s = ""; for (i, c in "añb") { s += c + "${i}"; }; s`, "a0ñ1b2"},
+		{`let größe = 3; größe * 2`, 6},
+		{`bytes("é")`, []int{195, 169}},
+		{`runes("é!")`, []int{233, 33}},
+		{`len(bytes("日本語"))`, 9},
+		{`bytes(1)`, vmError("argument to `bytes` must be STRING, got INTEGER")},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{`len("")`, 0},
+		{`len("four")`, 4},
+		{`len("hello world")`, 11},
+		{`len(1)`, vmError("argument to `len` is not supported, got INTEGER")},
+		{`len("one", "two")`, vmError("wrong number of arguments. got=2,want =1")},
+		{`len([1, 2, 3])`, 3},
+		{`first([1, 2, 3])`, 1},
+		{`first([])`, NULL},
+		{`last([1, 2, 3])`, 3},
+		{`rest([1, 2, 3])`, []int{2, 3}},
+		{`push([], 1)`, []int{1}},
+		{`puts()`, NULL},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestArrayLiterals(t *testing.T) {
+	tests := []vmTestCase{
+		{"[]", []int{}},
+		{"[1, 2 * 2, 3 + 3]", []int{1, 4, 6}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestIndexExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"[1, 2, 3][0]", 1},
+		{"[1, 2, 3][1]", 2},
+		{"let i = 0;[1][i];", 1},
+		{"[1, 2, 3][1 + 1];", 3},
+		{"let myArray = [1, 2, 3]; myArray[2];", 3},
+		{"let myArray = [1, 2, 3]; let i = myArray[0]; myArray[i]", 2},
+		{"[1, 2, 3][3]", NULL},
+		{"[1, 2, 3][-1]", NULL},
+		{`{"foo":5}["foo"]`, 5},
+		{`{"foo": 5}["bar"]`, NULL},
+		{`let key = "foo";{"foo": 5} [key]`, 5},
+		{`{}["foo"]`, NULL},
+		{`{true: 5}[true]`, 5},
+		{`{false: 5}[false]`, 5},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestHashLiterals(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			`let two = "two";
+	{
+		"one": 10 - 9,
+		two: 1 + 1,
+		"thr" + "ee": 6 / 2,
+		4: 4,
+		true: 5,
+		false: 6
+	}`,
+			map[object.HashKey]int64{
+				(&object.String{Value: "one"}).HashKey():   1,
+				(&object.String{Value: "two"}).HashKey():   2,
+				(&object.String{Value: "three"}).HashKey(): 3,
+				(&object.Integer{Value: 4}).HashKey():      4,
+				TRUE.HashKey():                             5,
+				FALSE.HashKey():                            6,
+			},
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"let i = 0; while (i < 10) { let i = i + 1; }; i", 10},
+		{"while (false) { 1 }", NULL},
+		{"let s = 0; for (x in [1, 2, 3]) { let s = s + x; }; s", 6},
+		{"let s = 0; for (i, x in [5, 6, 7]) { let s = s + i; }; s", 3},
+		{`let s = ""; for (c in "abc") { let s = c + s; }; s`, "cba"},
+		{`let s = ""; for (k in {"b": 2, "a": 1}) { let s = s + k; }; s`, "ab"},
+		{`let s = 0; for (k, v in {"b": 2, "a": 1}) { let s = s * 10 + v; }; s`, 12},
+		{"let i = 0; while (true) { let i = i + 1; if (i == 5) { break; } }; i", 5},
+		{"let s = 0; for (x in [1, 2, 3, 4]) { if (x == 2) { continue; } let s = s + x; }; s", 8},
+		{"let f = fn() { for (x in [1, 2, 3]) { if (x == 2) { return x * 10; } } }; f()", 20},
+		{"let s = 0; for (x in [1, 2]) { for (y in [10, 20]) { if (y == 20) { break; } let s = s + x * y; } }; s", 30},
+		{"let f = fn() { let s = 0; for (x in [1, 2, 3]) { let s = s + x; }; s }; f()", 6},
+		{"let i = 0; while (i < 3) { let i = i + 1; [1, 2, if (i == 2) { continue; }] }; i", 3},
+		{"for (x in 5) { x }", vmError("cannot iterate over INTEGER")},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let x = 1; x = 2; x", 2},
+		{"let x = 1; x = 5", 5},
+		{"let x = 1; let y = 1; x = y = 3; x + y", 6},
+		{"let x = 10; x += 5; x -= 3; x *= 2; x /= 4; x", 6},
+		{"let i = 0; let s = 0; while (i < 5) { s += i; i += 1; }; s", 10},
+		{"let x = 1; let f = fn() { x = 7 }; f(); x", 7},
+		{"let f = fn() { let x = 1; let g = fn() { x += 1 }; g(); g(); x }; f()", 3},
+		{
+			`let counter = fn() { let n = 0; fn() { n += 1 } };
+			let c = counter(); c(); c(); let d = counter(); d(); c()`,
+			3,
+		},
+		{"let f = fn() { let x = 1; fn() { fn() { x *= 10 } } }; let inc = f()(); inc(); inc()", 100},
+		{"let f = fn(x) { let g = fn() { x }; x = 4; g() }; f(1)", 4},
+		{
+			`let mk = fn() { let n = 0; fn() { n += 1 } };
+			let a = mk(); a(); let b = mk(); b()`,
+			1,
+		},
+		{"y = 1", vmError("undefined variable y")},
+		{"len = 1", vmError("cannot assign to builtin len")},
+		{`let x = 1; x += "a"`, vmError("type mismatch: INTEGER + STRING")},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestIndexAssignment(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = [1, 2, 3]; a[1] = 5; a", []int{1, 5, 3}},
+		{"let a = [1, 2, 3]; a[0] += 10", 11},
+		{"let a = [1, 2, 3]; let b = a; b[2] *= 3; a", []int{1, 2, 9}},
+		{`let h = {}; h["a"] = 1; h["b"] = 2; h["a"] += 5; h`, map[object.HashKey]int64{
+			(&object.String{Value: "a"}).HashKey(): 6,
+			(&object.String{Value: "b"}).HashKey(): 2,
+		}},
+		{"let m = [[1, 2], [3, 4]]; m[1][0] = 0; m[1]", []int{0, 4}},
+		{"let f = fn() { let h = {}; for (x in [1, 2, 3]) { h[x] = x * x }; h[3] }; f()", 9},
+		{"let f = fn(a) { a[0] = 99 }; let xs = [1]; f(xs); xs", []int{99}},
+		{"let a = [1]; a[1] = 2", vmError("index out of range: 1 with length 1")},
+		{"let a = [1]; a[-1] = 2", vmError("index out of range: -1 with length 1")},
+		{`let a = [1]; a["x"] = 2`, vmError("array index must be INTEGER, got STRING")},
+		{`let s = "abc"; s[0] = "x"`, vmError("index assignment not supported: STRING")},
+		{"let h = {}; h[fn() {}] = 1", vmError("unusable as hash key: FUNCTION")},
+		{`let h = {}; h["a"] += 1`, vmError("type mismatch: NULL + INTEGER")},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestHashBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`let h = {}; set(h, "a", 1); h["a"]`, 1},
+		{`let h = {"a": 1, "b": 2}; delete(h, "a"); len(keys(h))`, 1},
+		{`keys({"b": 1, "a": 2})[0]`, "a"},
+		{`values({"b": 1, "a": 2})`, []int{2, 1}},
+		{`has({"a": 1}, "a")`, true},
+		{`has({"a": 1}, "b")`, false},
+		{`set([], 0, 1)`, vmError("argument to `set` must be HASH, got ARRAY")},
+		{`has({}, [])`, vmError("unusable as hash key: ARRAY")},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestFloatArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"3.5", 3.5},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2.0},
+		{"7 / 2.0", 3.5},
+		{"7 / 2", 3},
+		{"10 - 0.25", 9.75},
+		{"(1 + 2 + 3) / 3.0", 2.0},
+		{"1 == 1.0", true},
+		{"0.1 + 0.2 == 0.3", false},
+		{"2.5 > 2", true},
+		{"1 < 0.5", false},
+		{"1.5 != 1.5", false},
+		{`let h = {1: "one"}; h[1.0]`, "one"},
+		{"1.5 + true", vmError("type mismatch: FLOAT + BOOLEAN")},
+		{`"a" + 1.5`, vmError("type mismatch: STRING + FLOAT")},
+		{"let x = 1; x += 0.5; x", 1.5},
+		{"int(3.9)", 3},
+		{`int(" 42 ")`, 42},
+		{"int(1.0 / 0)", vmError("cannot convert +Inf to INTEGER")},
+		{"float(2)", 2.0},
+		{`float("1e3")`, 1000.0},
+		{"round(2.5)", 3},
+		{"round(-2.5)", -3},
+		{"round(3.14159, 2)", 3.14},
+	}
+
+	runVmTests(t, tests)
+}
+
+func bigInt(s string) *big.Int {
+	v, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		panic("invalid big integer " + s)
+	}
+	return v
+}
+
+func TestBigIntegers(t *testing.T) {
+	tests := []vmTestCase{
+		{"9223372036854775807 + 1", bigInt("9223372036854775808")},
+		{"-9223372036854775807 - 2", bigInt("-9223372036854775809")},
+		{"9223372036854775807 * 2", bigInt("18446744073709551614")},
+		{"-(-9223372036854775807 - 1)", bigInt("9223372036854775808")},
+		{"(-9223372036854775807 - 1) / -1", bigInt("9223372036854775808")},
+		{"99999999999999999999", bigInt("99999999999999999999")},
+		{"99999999999999999999 - 99999999999999999998", 1},
+		{"let f = fn(n) { if (n < 2) { 1 } else { n * f(n - 1) } }; f(25)", bigInt("15511210043330985984000000")},
+		{"let f = fn(n) { if (n < 2) { 1 } else { n * f(n - 1) } }; f(25) / f(24)", 25},
+		{"99999999999999999999 > 1", true},
+		{"99999999999999999999 + 0.5", 1e20},
+		{`99999999999999999999 + "a"`, vmError("type mismatch: BIGINT + STRING")},
+		{"int(1e20)", bigInt("100000000000000000000")},
+		{`let h = {99999999999999999999: "big"}; h[99999999999999999998 + 1]`, "big"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestDivisionAndModulo(t *testing.T) {
+	tests := []vmTestCase{
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"7 % -3", 1},
+		{"-7 / 2", -3},
+		{"2 + 7 % 4 * 2", 8},
+		{"7.5 % 2", 1.5},
+		{"99999999999999999999 % 7", 1},
+		{"1 / 0", vmError("division by zero")},
+		{"1 % 0", vmError("modulo by zero")},
+		{"99999999999999999999 / 0", vmError("division by zero")},
+		{"1.5 % 0", vmError("modulo by zero")},
+		{"let f = fn(a, b) { a / b }; f(1, 0); 5", vmError("division by zero")},
+		{"true % 2", vmError("type mismatch: BOOLEAN % INTEGER")},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestPanicsBecomeErrors(t *testing.T) {
+	saved := object.Builtins
+	defer func() { object.Builtins = saved }()
+
+	object.Builtins = append(object.Builtins[:len(saved):len(saved)], struct {
+		Name    string
+		Builtin *object.Builtin
+	}{"explode", &object.Builtin{Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+		panic("boom")
+	}}})
+
+	_, err := testRun(t, "let x = 1;\nexplode(); x")
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if err.Error() != "2:1: internal error: boom" {
+		t.Errorf("wrong error. got=%q", err.Error())
+	}
+}
+
+func TestLogicalExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"true && true", true},
+		{"true && false", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 && 2", true},
+		{"if (false) { 1 } || 0", true},
+		{"if (false) { 1 } && 1", false},
+		{"let n = 0; let f = fn() { n += 1; true }; false && f(); true || f(); n", 0},
+		{"let n = 0; let f = fn() { n += 1; true }; true && f(); false || f(); n", 2},
+		{"1 < 2 && 2 < 3 || false", true},
+		{"let f = fn(x) { x > 0 && x < 10 }; f(5) && !f(10)", true},
+		{"false && undefined", vmError("undefined variable undefined")},
+		{"1 <= 1", true},
+		{"2 <= 1", false},
+		{"1 >= 1", true},
+		{"1 >= 2", false},
+		{"1.5 <= 2", true},
+		{"99999999999999999999 >= 1", true},
+		{`"a" <= "b"`, true},
+		{`"b" >= "c"`, false},
+		{`"a" >= "a"`, true},
+		{`"a" <= 1`, vmError("type mismatch: STRING <= INTEGER")},
+		{"true >= false", vmError("unknown operator: BOOLEAN >= BOOLEAN")},
+		{`"a" == "a"`, true},
+		{`"a" != "a"`, false},
+		{`"a" == "b"`, false},
+		{"[1, 2] == [1, 2]", true},
+		{"[1, 2] != [1, 3]", true},
+		{"[1, 2] == [1]", false},
+		{"[1, [2, 3]] == [1.0, [2, 3]]", true},
+		{"{1: 2} == {1: 2}", true},
+		{"{1: 2} == {1: 3}", false},
+		{"{1: 2} == {2: 2}", false},
+		{`{"a": [1, {"b": 2}]} == {"a": [1, {"b": 2}]}`, true},
+		{"[if (false) { 1 }] == [if (false) { 2 }]", true},
+		{`[1] == {1: 1}`, false},
+		{`"1" == 1`, false},
+		{"let f = fn() { 1 }; f == f", true},
+		{"fn() { 1 } == fn() { 1 }", false},
+		{"let a = [1]; a[0] = a; let b = [1]; b[0] = b; a == b", true},
+		{"let a = [1, 2]; a[0] = a; let b = [1, 3]; b[0] = b; a == b", false},
+		{"let h = {}; h[0] = h; let g = {}; g[0] = g; h == g", true},
+		{`"a" < "b"`, true},
+		{`"b" < "a"`, false},
+		{`"b" > "a"`, true},
+		{`"a" > "a"`, false},
+		{`"Z" < "a"`, true},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestIOBuiltins(t *testing.T) {
+	input := `let name = readline(); print("hi ", name, "!"); puts(1, 2); eprint("warn"); [readline(), read_all(), readline()]`
+
+	comp := compiler.New()
+	if err := comp.Compile(parse(t, input).ParseProgram()); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	vm := New(comp.Bytecode())
+	vm.SetContext(object.NewContext(strings.NewReader("alice\r\nbob\nrest\n"), &stdout, &stderr))
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	if stdout.String() != "hi alice!1\n2\n" {
+		t.Errorf("wrong stdout. got=%q", stdout.String())
+	}
+	if stderr.String() != "warn" {
+		t.Errorf("wrong stderr. got=%q", stderr.String())
+	}
+	if result := vm.LastPoppedStackElem().Inspect(); result != "[bob, rest\n, null]" {
+		t.Errorf("wrong result. got=%q", result)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	comp := compiler.New()
+	if err := comp.Compile(parse(t, "while (true) { }").ParseProgram()); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	vm.SetBudget(object.NewBudget(ctx, object.Limits{}))
+	err := vm.Run()
+	if errObj, ok := err.(*object.Error); !ok || errObj.Kind != object.Cancelled {
+		t.Errorf("expected a cancellation error. got=%v", err)
+	}
+}
+
+func TestBuiltinsChargeTheBudget(t *testing.T) {
+	comp := compiler.New()
+	if err := comp.Compile(parse(t, "let a = []; while (true) { a = push(a, 1) }").ParseProgram()); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	// the context set after the budget must still be charged to it
+	vm := New(comp.Bytecode())
+	vm.SetBudget(object.NewBudget(context.Background(), object.Limits{MaxMemory: 1000}))
+	vm.SetContext(object.NewContext(strings.NewReader(""), &bytes.Buffer{}, &bytes.Buffer{}))
+
+	err := vm.Run()
+	if errObj, ok := err.(*object.Error); !ok || errObj.Kind != object.MemoryLimitExceeded {
+		t.Errorf("expected a memory limit error. got=%v", err)
+	}
+}